@@ -0,0 +1,126 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"errors"
+	"runtime"
+	"time"
+
+	wa "github.com/wneessen/argon2"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// minCalibrationMemory defines the minimum memory in kibibytes that Calibrate will return.
+	minCalibrationMemory = 8 * 1024
+
+	// calibrationMemoryStep defines the granularity in kibibytes of the memory returned by Calibrate.
+	calibrationMemoryStep = 1024
+
+	// calibrationMaxGrowth defines the factor by which Calibrate grows the memory at most between two
+	// measurements.
+	calibrationMaxGrowth = 4
+)
+
+var (
+	// ErrInvalidCalibrationTarget indicates that the target duration passed to Calibrate is not positive.
+	ErrInvalidCalibrationTarget = errors.New("calibration target must be greater than zero")
+
+	// ErrCalibrationMemoryTooLow indicates that the maximum memory passed to Calibrate is below the
+	// minimum of 8 MiB.
+	ErrCalibrationMemoryTooLow = errors.New("calibration memory must be at least 8 MiB")
+)
+
+// calibrationPassword is the password the Argon2 key derivation is benchmarked with.
+var calibrationPassword = []byte("iocrypter calibration")
+
+// Calibrate benchmarks the Argon2id key derivation on the current machine and returns the settings
+// for which a single key derivation takes about the given target duration, without exceeding the
+// given memory in kibibytes. The returned settings can be passed to NewEncrypterWithSettings or
+// WithArgon2.
+//
+// The memory is maximized first, since it is the parameter that makes attacks with dedicated hardware
+// expensive, and the remaining time is spent on additional passes. Starting at 8 MiB, the memory grows
+// at most fourfold per measurement, so that small machines are not exhausted by a large maxMemory
+// before the target duration is reached. The number of threads matches the
+// number of CPUs available to the process. The settings are capped to the limits of the
+// DefaultDecryptPolicy, so that the ciphertext can be decrypted with the default policy. If the target
+// cannot be met even with the minimum memory of 8 MiB and a single pass, those settings are returned.
+//
+// Since the key derivation takes about the same time when decrypting, the settings should be
+// calibrated on the slowest machine that is expected to decrypt the ciphertext.
+func Calibrate(target time.Duration, maxMemory uint32) (wa.Settings, error) {
+	threads := uint8(min(runtime.GOMAXPROCS(0), defaultPolicyMaxThreads))
+	return calibrate(target, maxMemory, threads, measureArgon2)
+}
+
+// calibrate returns the Argon2 settings with the given number of threads for which the duration
+// returned by measure is about the given target, without exceeding the given memory.
+func calibrate(target time.Duration, maxMemory uint32, threads uint8,
+	measure func(wa.Settings) time.Duration,
+) (wa.Settings, error) {
+	if target <= 0 {
+		return wa.Settings{}, ErrInvalidCalibrationTarget
+	}
+	if maxMemory < minCalibrationMemory {
+		return wa.Settings{}, ErrCalibrationMemoryTooLow
+	}
+	settings := func(memory, passes uint32) wa.Settings {
+		return wa.NewSettings(memory, passes, threads, saltSize, aesKeySize+hmacSize)
+	}
+
+	// Find the largest memory for which a single pass fits into the target, scaling the memory up
+	// from the minimum in proportion to the measured duration
+	limit := uint64(min(maxMemory, defaultPolicyMaxMemory))
+	memory := uint32(minCalibrationMemory)
+	elapsed := max(measure(settings(memory, 1)), 1)
+	for elapsed < target && uint64(memory) < limit {
+		scaled := min(scaleCalibration(uint64(memory), target, elapsed), calibrationMaxGrowth*uint64(memory), limit)
+		scaled = scaled / calibrationMemoryStep * calibrationMemoryStep
+		if scaled <= uint64(memory) {
+			break
+		}
+		memory = uint32(scaled)
+		elapsed = max(measure(settings(memory, 1)), 1)
+	}
+
+	// If the last step overshot the target, scale the memory down in proportion to the measured
+	// duration, by at least one step per measurement
+	for elapsed > target && memory > minCalibrationMemory {
+		scaled := scaleCalibration(uint64(memory), target, elapsed)
+		scaled = min(scaled/calibrationMemoryStep*calibrationMemoryStep, uint64(memory-calibrationMemoryStep))
+		memory = uint32(max(scaled, minCalibrationMemory))
+		elapsed = max(measure(settings(memory, 1)), 1)
+	}
+	if elapsed >= target {
+		return settings(memory, 1), nil
+	}
+
+	// Spend the remaining time on additional passes. Their duration is estimated from the single
+	// pass, so the estimate is measured once and reduced if it exceeds the target
+	passes := uint32(min(scaleCalibration(1, target, elapsed), defaultPolicyMaxTime))
+	if passes > 1 {
+		elapsed = max(measure(settings(memory, passes)), 1)
+		if elapsed > target {
+			passes = uint32(max(scaleCalibration(uint64(passes), target, elapsed), 1))
+		}
+	}
+	return settings(memory, passes), nil
+}
+
+// scaleCalibration scales the given value by the ratio of the target to the elapsed duration.
+func scaleCalibration(value uint64, target, elapsed time.Duration) uint64 {
+	return value * uint64(target) / uint64(elapsed)
+}
+
+// measureArgon2 returns the duration of a single Argon2id key derivation with the given settings.
+func measureArgon2(settings wa.Settings) time.Duration {
+	salt := make([]byte, settings.SaltLength)
+	start := time.Now()
+	_ = argon2.IDKey(calibrationPassword, salt, settings.Time, settings.Memory, settings.Threads,
+		settings.KeyLength)
+	return time.Since(start)
+}
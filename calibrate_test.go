@@ -0,0 +1,151 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	wa "github.com/wneessen/argon2"
+)
+
+func TestCalibrate(t *testing.T) {
+	t.Run("calibrating on the current machine", func(t *testing.T) {
+		settings, err := Calibrate(50*time.Millisecond, minCalibrationMemory)
+		if err != nil {
+			t.Fatalf("failed to calibrate settings: %s", err)
+		}
+		if settings.Memory != minCalibrationMemory {
+			t.Errorf("expected memory to be %d, got %d", minCalibrationMemory, settings.Memory)
+		}
+		if settings.Time < 1 || settings.Time > defaultPolicyMaxTime {
+			t.Errorf("expected time to be between 1 and %d, got %d", defaultPolicyMaxTime, settings.Time)
+		}
+		if settings.Threads < 1 {
+			t.Errorf("expected at least 1 thread, got %d", settings.Threads)
+		}
+		encrypter, err := NewEncrypterWithSettings(bytes.NewReader([]byte("plaintext")), testPassword,
+			settings.Memory, settings.Time, settings.Threads)
+		if err != nil {
+			t.Fatalf("failed to create encrypter: %s", err)
+		}
+		ciphertext, err := io.ReadAll(encrypter)
+		if err != nil {
+			t.Fatalf("failed to encrypt plaintext: %s", err)
+		}
+		if err = Verify(bytes.NewReader(ciphertext), testPassword); err != nil {
+			t.Errorf("expected calibrated settings to be accepted by the default policy, got %s", err)
+		}
+	})
+	t.Run("calibrating with invalid target should fail", func(t *testing.T) {
+		for _, target := range []time.Duration{0, -time.Second} {
+			if _, err := Calibrate(target, defaultArgon2Memory); !errors.Is(err, ErrInvalidCalibrationTarget) {
+				t.Errorf("expected error to be %s, got %s", ErrInvalidCalibrationTarget, err)
+			}
+		}
+	})
+	t.Run("calibrating with too little memory should fail", func(t *testing.T) {
+		if _, err := Calibrate(time.Second, minCalibrationMemory-1); !errors.Is(err, ErrCalibrationMemoryTooLow) {
+			t.Errorf("expected error to be %s, got %s", ErrCalibrationMemoryTooLow, err)
+		}
+	})
+
+	// A cost model in which each pass takes one nanosecond per kibibyte of memory, divided among the
+	// threads, so that the calibration can be tested independently of the machine
+	model := func(settings wa.Settings) time.Duration {
+		return time.Duration(uint64(settings.Memory) * uint64(settings.Time) / uint64(settings.Threads))
+	}
+	tests := []struct {
+		name       string
+		target     time.Duration
+		maxMemory  uint32
+		threads    uint8
+		wantMemory uint32
+		wantTime   uint32
+	}{
+		{"fast machine uses maximum memory and more passes", 4 * 256 * 1024, 256 * 1024, 1, 256 * 1024, 4},
+		{"threads are taken into account", 4 * 256 * 1024, 256 * 1024, 4, 256 * 1024, 16},
+		{"memory is capped to the default policy", time.Hour, 4 * 1024 * 1024, 4, defaultPolicyMaxMemory, 16},
+		{"slow machine limits memory", 100 * 1024, 1024 * 1024, 1, 100 * 1024, 1},
+		{"unreachable target returns minimum settings", time.Nanosecond, 1024 * 1024, 1, minCalibrationMemory, 1},
+		{"partial passes are rounded down", 3*64*1024 + 1000, 64 * 1024, 1, 64 * 1024, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings, err := calibrate(tt.target, tt.maxMemory, tt.threads, model)
+			if err != nil {
+				t.Fatalf("failed to calibrate settings: %s", err)
+			}
+			if settings.Memory != tt.wantMemory {
+				t.Errorf("expected memory to be %d, got %d", tt.wantMemory, settings.Memory)
+			}
+			if settings.Time != tt.wantTime {
+				t.Errorf("expected time to be %d, got %d", tt.wantTime, settings.Time)
+			}
+			if settings.Threads != tt.threads {
+				t.Errorf("expected threads to be %d, got %d", tt.threads, settings.Threads)
+			}
+			if settings.SaltLength != saltSize || settings.KeyLength != aesKeySize+hmacSize {
+				t.Errorf("expected salt and key length to be %d and %d, got %d and %d", saltSize,
+					aesKeySize+hmacSize, settings.SaltLength, settings.KeyLength)
+			}
+			if model(settings) > tt.target && settings.Memory > minCalibrationMemory {
+				t.Errorf("expected settings to meet the target of %s, got %s", tt.target, model(settings))
+			}
+		})
+	}
+	t.Run("memory grows gradually from the minimum", func(t *testing.T) {
+		var measured []uint32
+		recording := func(settings wa.Settings) time.Duration {
+			measured = append(measured, settings.Memory)
+			return model(settings)
+		}
+		if _, err := calibrate(time.Nanosecond, 1024*1024, 1, recording); err != nil {
+			t.Fatalf("failed to calibrate settings: %s", err)
+		}
+		if len(measured) != 1 || measured[0] != minCalibrationMemory {
+			t.Errorf("expected only the minimum memory to be measured for unreachable target, got %v", measured)
+		}
+		measured = nil
+		if _, err := calibrate(time.Hour, 1024*1024, 1, recording); err != nil {
+			t.Fatalf("failed to calibrate settings: %s", err)
+		}
+		for i := 1; i < len(measured); i++ {
+			if measured[i] > calibrationMaxGrowth*measured[i-1] {
+				t.Errorf("expected memory to grow at most %d-fold, got %d after %d", calibrationMaxGrowth,
+					measured[i], measured[i-1])
+			}
+		}
+	})
+	t.Run("memory that exceeds the estimate is reduced", func(t *testing.T) {
+		// The duration grows quadratically with the memory, so that the linear estimate overshoots
+		quadratic := func(settings wa.Settings) time.Duration {
+			return time.Duration(uint64(settings.Memory) * uint64(settings.Memory) / 1024)
+		}
+		settings, err := calibrate(900*1024, 1024*1024, 1, quadratic)
+		if err != nil {
+			t.Fatalf("failed to calibrate settings: %s", err)
+		}
+		if quadratic(settings) > 900*1024 || settings.Memory < 16*1024 {
+			t.Errorf("expected settings to meet the target, got %d KiB of memory", settings.Memory)
+		}
+	})
+	t.Run("passes that exceed the estimate are reduced", func(t *testing.T) {
+		// Additional passes take twice as long as the first one
+		slow := func(settings wa.Settings) time.Duration {
+			return time.Duration(uint64(settings.Memory) * uint64(2*settings.Time-1))
+		}
+		settings, err := calibrate(4*64*1024, 64*1024, 1, slow)
+		if err != nil {
+			t.Fatalf("failed to calibrate settings: %s", err)
+		}
+		if slow(settings) > 4*64*1024 {
+			t.Errorf("expected settings to meet the target, got %d passes", settings.Time)
+		}
+	})
+}
@@ -0,0 +1,134 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// CipherSuite identifies the algorithms that are used to encrypt and authenticate the segments
+// of the ciphertext. The cipher suite is stored in the header of the ciphertext, so that the
+// decrypter can select it automatically.
+type CipherSuite byte
+
+const (
+	// CipherSuiteAES256CTRHMACSHA512 encrypts the segments with AES-256-CTR and authenticates
+	// them with HMAC-SHA512. This is the default cipher suite.
+	CipherSuiteAES256CTRHMACSHA512 CipherSuite = iota + 1
+
+	// CipherSuiteAES256GCM encrypts and authenticates the segments with AES-256-GCM. It is the
+	// fastest choice on CPUs with hardware AES support.
+	CipherSuiteAES256GCM
+
+	// CipherSuiteXChaCha20Poly1305 encrypts and authenticates the segments with
+	// XChaCha20-Poly1305. It is the fastest choice on CPUs without hardware AES support.
+	CipherSuiteXChaCha20Poly1305
+)
+
+// defaultCipherSuite is the cipher suite that is used if none is specified.
+const defaultCipherSuite = CipherSuiteAES256CTRHMACSHA512
+
+// String satisfies the fmt.Stringer interface for the CipherSuite type.
+func (c CipherSuite) String() string {
+	switch c {
+	case CipherSuiteAES256CTRHMACSHA512:
+		return "AES-256-CTR-HMAC-SHA512"
+	case CipherSuiteAES256GCM:
+		return "AES-256-GCM"
+	case CipherSuiteXChaCha20Poly1305:
+		return "XChaCha20-Poly1305"
+	default:
+		return fmt.Sprintf("unknown cipher suite (%d)", byte(c))
+	}
+}
+
+// supported returns true if the cipher suite is supported by this version of the package.
+func (c CipherSuite) supported() bool {
+	switch c {
+	case CipherSuiteAES256CTRHMACSHA512, CipherSuiteAES256GCM, CipherSuiteXChaCha20Poly1305:
+		return true
+	default:
+		return false
+	}
+}
+
+// newSegmentCipher returns the segmentCipher for the given cipher suite. The AEAD based cipher
+// suites only use the encryption key, while the hmacKey is only used by the AES-256-CTR with
+// HMAC-SHA512 cipher suite.
+func newSegmentCipher(suite CipherSuite, encKey, hmacKey, iv, header []byte, segmentSize int) (segmentCipher, error) {
+	switch suite {
+	case CipherSuiteAES256CTRHMACSHA512:
+		return newCTRHMACCipher(encKey, hmacKey, iv, header, segmentSize)
+	case CipherSuiteAES256GCM:
+		block, err := aes.NewCipher(encKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create AES block cipher: %w", err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create AES-GCM cipher: %w", err)
+		}
+		return newAEADCipher(aead, iv, header), nil
+	case CipherSuiteXChaCha20Poly1305:
+		aead, err := chacha20poly1305.NewX(encKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create XChaCha20-Poly1305 cipher: %w", err)
+		}
+		return newAEADCipher(aead, iv, header), nil
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedCipherSuite, byte(suite))
+	}
+}
+
+// aeadCipher implements the segmentCipher interface using an AEAD. The nonce of each segment
+// consists of a prefix taken from the IV, followed by the segment counter and the final flag.
+// The stream header is authenticated as additional data of each segment.
+type aeadCipher struct {
+	aead   cipher.AEAD
+	prefix []byte
+	header []byte
+}
+
+// newAEADCipher returns a new aeadCipher for the given AEAD, IV and stream header.
+func newAEADCipher(aead cipher.AEAD, iv, header []byte) *aeadCipher {
+	return &aeadCipher{
+		aead:   aead,
+		prefix: iv[:aead.NonceSize()-len(segmentNonce(0, false))],
+		header: header,
+	}
+}
+
+// overhead satisfies the segmentCipher interface for the aeadCipher type.
+func (c *aeadCipher) overhead() int {
+	return c.aead.Overhead()
+}
+
+// seal satisfies the segmentCipher interface for the aeadCipher type.
+func (c *aeadCipher) seal(dst, plaintext []byte, counter uint64, final bool) []byte {
+	return c.aead.Seal(dst, c.nonce(counter, final), plaintext, c.header)
+}
+
+// open satisfies the segmentCipher interface for the aeadCipher type.
+func (c *aeadCipher) open(dst, ciphertext []byte, counter uint64, final bool) ([]byte, error) {
+	if len(ciphertext) < c.aead.Overhead() {
+		return nil, ErrMissingData
+	}
+	plaintext, err := c.aead.Open(dst, c.nonce(counter, final), ciphertext, c.header)
+	if err != nil {
+		return nil, ErrFailedAuthentication
+	}
+	return plaintext, nil
+}
+
+// nonce returns the nonce for the segment with the given counter.
+func (c *aeadCipher) nonce(counter uint64, final bool) []byte {
+	nonce := make([]byte, 0, c.aead.NonceSize())
+	nonce = append(nonce, c.prefix...)
+	return append(nonce, segmentNonce(counter, final)...)
+}
@@ -0,0 +1,147 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestNewEncrypterWithCipherSuite(t *testing.T) {
+	suites := []CipherSuite{CipherSuiteAES256CTRHMACSHA512, CipherSuiteAES256GCM, CipherSuiteXChaCha20Poly1305}
+	plaintext := make([]byte, 2*defaultSegmentSize+42)
+	if _, err := io.ReadFull(rand.Reader, plaintext); err != nil {
+		t.Fatalf("failed to generate plaintext: %s", err)
+	}
+	for _, suite := range suites {
+		t.Run("encrypt/decrypt round trip with "+suite.String(), func(t *testing.T) {
+			encrypter, err := NewEncrypterWithCipherSuite(bytes.NewReader(plaintext), testPassword, suite)
+			if err != nil {
+				t.Fatalf("failed to create encrypter: %s", err)
+			}
+			ciphertext, err := io.ReadAll(encrypter)
+			if err != nil {
+				t.Fatalf("failed to encrypt plaintext: %s", err)
+			}
+			if CipherSuite(ciphertext[len(headerMagic)+1]) != suite {
+				t.Errorf("expected cipher suite in header to be %s, got %s", suite,
+					CipherSuite(ciphertext[len(headerMagic)+1]))
+			}
+
+			decrypter, err := NewDecrypter(bytes.NewReader(ciphertext), testPassword)
+			if err != nil {
+				t.Fatalf("failed to create decrypter: %s", err)
+			}
+			decrypted, err := io.ReadAll(decrypter)
+			if err != nil {
+				t.Fatalf("failed to decrypt ciphertext: %s", err)
+			}
+			if !bytes.Equal(plaintext, decrypted) {
+				t.Error("plaintext and decrypted data do not match")
+			}
+
+			tampered := bytes.Clone(ciphertext)
+			tampered[len(tampered)-1] ^= 0xff
+			decrypter, err = NewDecrypter(bytes.NewReader(tampered), testPassword)
+			if err != nil {
+				t.Fatalf("failed to create decrypter: %s", err)
+			}
+			if _, err = io.ReadAll(decrypter); !errors.Is(err, ErrFailedAuthentication) {
+				t.Errorf("expected error to be %s, got %s", ErrFailedAuthentication, err)
+			}
+		})
+	}
+	t.Run("encrypter creation with unsupported cipher suite should fail", func(t *testing.T) {
+		_, err := NewEncrypterWithCipherSuite(bytes.NewReader(plaintext), testPassword, CipherSuite(0))
+		if !errors.Is(err, ErrUnsupportedCipherSuite) {
+			t.Errorf("expected error to be %s, got %s", ErrUnsupportedCipherSuite, err)
+		}
+	})
+	t.Run("encrypter creation with nil passphrase should fail", func(t *testing.T) {
+		_, err := NewEncrypterWithCipherSuite(bytes.NewReader(plaintext), nil, CipherSuiteAES256GCM)
+		if !errors.Is(err, ErrPassPhraseEmpty) {
+			t.Errorf("expected error to be %s, got %s", ErrPassPhraseEmpty, err)
+		}
+	})
+	t.Run("decryption with unsupported cipher suite should fail", func(t *testing.T) {
+		encrypter, err := NewEncrypterWithCipherSuite(bytes.NewReader(plaintext), testPassword,
+			CipherSuiteAES256GCM)
+		if err != nil {
+			t.Fatalf("failed to create encrypter: %s", err)
+		}
+		ciphertext, err := io.ReadAll(encrypter)
+		if err != nil {
+			t.Fatalf("failed to encrypt plaintext: %s", err)
+		}
+		ciphertext[len(headerMagic)+1] = 0xff
+		if _, err = NewDecrypter(bytes.NewReader(ciphertext), testPassword); !errors.Is(err, ErrUnsupportedCipherSuite) {
+			t.Errorf("expected error to be %s, got %s", ErrUnsupportedCipherSuite, err)
+		}
+	})
+	t.Run("format version 1 without cipher suite is still decrypted", func(t *testing.T) {
+		hdr := &header{
+			version:     formatVersion1,
+			kdf:         keyDerivationArgon2ID,
+			params:      argon2IDKDF(testSettings),
+			salt:        make([]byte, saltSize),
+			iv:          make([]byte, blockSize),
+			segmentSize: defaultSegmentSize,
+		}
+		hdr.marshal()
+		aesKey, hmacKey := DeriveKeys(testPassword, hdr.salt, testSettings)
+		segCipher, err := newCTRHMACCipher(aesKey, hmacKey, hdr.iv, hdr.raw, defaultSegmentSize)
+		if err != nil {
+			t.Fatalf("failed to create segment cipher: %s", err)
+		}
+		ciphertext, err := io.ReadAll(newStreamEncrypter(bytes.NewReader(plaintext), segCipher, hdr.raw,
+			defaultSegmentSize, 1))
+		if err != nil {
+			t.Fatalf("failed to encrypt plaintext: %s", err)
+		}
+
+		parsed, _, _, err := readParameters(bufio.NewReader(bytes.NewReader(ciphertext)),
+			passwordKeys(testPassword), DefaultDecryptPolicy())
+		if err != nil {
+			t.Fatalf("failed to read parameters: %s", err)
+		}
+		if parsed.suite != CipherSuiteAES256CTRHMACSHA512 {
+			t.Errorf("expected cipher suite to be %s, got %s", CipherSuiteAES256CTRHMACSHA512, parsed.suite)
+		}
+		decrypter, err := NewDecrypter(bytes.NewReader(ciphertext), testPassword)
+		if err != nil {
+			t.Fatalf("failed to create decrypter: %s", err)
+		}
+		decrypted, err := io.ReadAll(decrypter)
+		if err != nil {
+			t.Fatalf("failed to decrypt ciphertext: %s", err)
+		}
+		if !bytes.Equal(plaintext, decrypted) {
+			t.Error("plaintext and decrypted data do not match")
+		}
+	})
+}
+
+func TestCipherSuite_String(t *testing.T) {
+	tests := []struct {
+		suite CipherSuite
+		want  string
+	}{
+		{CipherSuiteAES256CTRHMACSHA512, "AES-256-CTR-HMAC-SHA512"},
+		{CipherSuiteAES256GCM, "AES-256-GCM"},
+		{CipherSuiteXChaCha20Poly1305, "XChaCha20-Poly1305"},
+		{CipherSuite(0), "unknown cipher suite (0)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tt.suite.String(); got != tt.want {
+				t.Errorf("expected cipher suite string to be %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
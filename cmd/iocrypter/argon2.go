@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"math"
+
+	"github.com/wneessen/iocrypter"
+)
+
+// errArgon2Flags indicates that only some of the Argon2 flags are set or that their values are out of
+// range.
+var errArgon2Flags = errors.New("-argon2-memory, -argon2-time and -argon2-threads have to be set together, " +
+	"with memory and time below 2^32 and threads between 1 and 255")
+
+// argon2Flags holds the flags that configure the Argon2 settings of a password key slot, as printed by
+// the calibrate command.
+type argon2Flags struct {
+	memory  *uint
+	time    *uint
+	threads *uint
+}
+
+// addArgon2Flags defines the Argon2 flags on flags.
+func addArgon2Flags(flags *flag.FlagSet) *argon2Flags {
+	return &argon2Flags{
+		memory:  flags.Uint("argon2-memory", 0, "Argon2 memory in KiB, as printed by the calibrate command"),
+		time:    flags.Uint("argon2-time", 0, "Argon2 passes, as printed by the calibrate command"),
+		threads: flags.Uint("argon2-threads", 0, "Argon2 threads, as printed by the calibrate command"),
+	}
+}
+
+// options returns the Option for the Argon2 flags, or no Option if none of them is set, in which
+// case the default Argon2 settings are used.
+func (a *argon2Flags) options() ([]iocrypter.Option, error) {
+	if *a.memory == 0 && *a.time == 0 && *a.threads == 0 {
+		return nil, nil
+	}
+	if *a.memory == 0 || *a.memory > math.MaxUint32 || *a.time == 0 || *a.time > math.MaxUint32 ||
+		*a.threads == 0 || *a.threads > math.MaxUint8 {
+		return nil, errArgon2Flags
+	}
+	return []iocrypter.Option{iocrypter.WithArgon2(uint32(*a.memory), uint32(*a.time), uint8(*a.threads))}, nil
+}
@@ -0,0 +1,58 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/wneessen/iocrypter"
+)
+
+// runCalibrate runs the calibrate command, which benchmarks the Argon2 key derivation on the current
+// machine and prints the settings that meet the target duration.
+func (c *cli) runCalibrate(args []string) int {
+	flags := c.newFlagSet("calibrate")
+	target := flags.Duration("t", time.Second, "target duration of a single key derivation")
+	maxMemory := flags.Uint("m", 1024, "maximum memory of the key derivation in MiB")
+	flags.Usage = func() {
+		_, _ = fmt.Fprintln(flags.Output(), "usage: iocrypter calibrate [-t <duration>] [-m <MiB>]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitSuccess
+		}
+		return exitUsage
+	}
+	if flags.NArg() != 0 || *maxMemory > math.MaxUint32/1024 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	settings, err := iocrypter.Calibrate(*target, uint32(*maxMemory)*1024)
+	if err != nil {
+		_, _ = fmt.Fprintf(c.stderr, "failed to calibrate Argon2 settings: %s\n", err)
+		return exitUsage
+	}
+
+	// Measure the calibrated settings, so that the actual duration is reported
+	salt := make([]byte, settings.SaltLength)
+	startTime := time.Now()
+	_, _ = iocrypter.DeriveKeys([]byte("iocrypter calibration"), salt, settings)
+	elapsed := time.Since(startTime)
+
+	_, _ = fmt.Fprintf(c.stdout, "Memory:  %d KiB\nTime:    %d\nThreads: %d\n", settings.Memory, settings.Time,
+		settings.Threads)
+	_, _ = fmt.Fprintf(c.stdout, "Key derivation takes %s (target: %s)\n", elapsed.Round(time.Millisecond),
+		target.String())
+	_, _ = fmt.Fprintf(c.stdout, "\nUse the settings with:\n  iocrypter encrypt -argon2-memory %d -argon2-time %d "+
+		"-argon2-threads %d\n  iocrypter.WithArgon2(%d, %d, %d)\n", settings.Memory, settings.Time, settings.Threads,
+		settings.Memory, settings.Time, settings.Threads)
+	return exitSuccess
+}
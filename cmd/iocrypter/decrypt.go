@@ -0,0 +1,148 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"runtime"
+	"time"
+
+	"github.com/wneessen/iocrypter"
+)
+
+// runDecrypt runs the decrypt command, which decrypts the input with the password and writes the
+// plaintext to the output. Input and output default to stdin and stdout. It returns exitUnauthentic
+// if the input is not authentic. With -r, the files of the input directory are decrypted recursively.
+func (c *cli) runDecrypt(args []string) int {
+	flags := c.newFlagSet("decrypt")
+	inFile := flags.String("i", stdioPath, "path to encrypted input file, or - for stdin")
+	outFile := flags.String("o", stdioPath, "path to output file, or - for stdout")
+	password := addPasswordFlags(flags, "password", "p", "password")
+	insecure := flags.Bool("insecure-password", false, "allow passing the password as argument")
+	concurrency := flags.Int("c", 1, "number of segments that are decrypted in parallel")
+	quiet := flags.Bool("q", false, "do not print status messages")
+	force := flags.Bool("force", false, "overwrite the output file if it exists")
+	recursive := flags.Bool("r", false, "decrypt the files of the input directory recursively")
+	suffix := flags.String("suffix", ".enc",
+		"suffix that is removed from the file names with -r, files without it are skipped")
+	workers := flags.Int("j", runtime.NumCPU(), "number of files that are decrypted in parallel with -r")
+	flags.Usage = func() {
+		_, _ = fmt.Fprintln(flags.Output(),
+			"usage: iocrypter decrypt [password options] [-i <input file>] [-o <output file>] [-c <workers>] [-q] [-force]")
+		_, _ = fmt.Fprintln(flags.Output(), "       iocrypter decrypt [password options] -r -i <input directory> "+
+			"[-o <output directory>] [-suffix <suffix>] [-j <workers>] [-c <workers>] [-q] [-force]")
+		_, _ = fmt.Fprintln(flags.Output(), "With -r and without -o, the output files are written next to the input files.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitSuccess
+		}
+		return exitUsage
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return exitUsage
+	}
+	if err := password.validate(*insecure); err != nil {
+		_, _ = fmt.Fprintln(c.stderr, err)
+		return exitUsage
+	}
+	outDir := ""
+	if *outFile != stdioPath {
+		outDir = *outFile
+	}
+	if *recursive {
+		if err := checkTree(*inFile, outDir, *suffix); err != nil {
+			_, _ = fmt.Fprintf(c.stderr, "invalid input or output directory: %s\n", err)
+			return exitUsage
+		}
+	} else if err := checkOutput(*inFile, *outFile, *force); err != nil {
+		_, _ = fmt.Fprintln(c.stderr, err)
+		return exitFailure
+	}
+	pass, err := password.read(false)
+	if err != nil {
+		_, _ = fmt.Fprintln(c.stderr, err)
+		return exitFailure
+	}
+
+	if *recursive {
+		return c.processTree(treeConfig{
+			verb:   "decrypted",
+			inDir:  *inFile,
+			outDir: outDir,
+			rename: trimSuffix(*suffix),
+			process: func(inPath, outPath string, info fs.FileInfo) error {
+				return c.decryptFile(inPath, outPath, pass, info, *force, iocrypter.WithConcurrency(*concurrency))
+			},
+			exitCode: func(err error) int {
+				if isUnauthentic(err) {
+					return exitUnauthentic
+				}
+				return exitFailure
+			},
+			workers: *workers,
+			force:   *force,
+			quiet:   *quiet,
+		})
+	}
+
+	startTime := time.Now()
+	err = c.decryptFile(*inFile, *outFile, pass, nil, *force, iocrypter.WithConcurrency(*concurrency))
+	if err != nil {
+		_, _ = fmt.Fprintf(c.stderr, "failed to decrypt %s: %s\n", displayName(*inFile, "stdin"), err)
+		if isUnauthentic(err) {
+			return exitUnauthentic
+		}
+		return exitFailure
+	}
+	if !*quiet {
+		_, _ = fmt.Fprintf(c.stderr, "File %s successfully decrypted to: %s (Time: %s)\n",
+			displayName(*inFile, "stdin"), displayName(*outFile, "stdout"), time.Since(startTime).String())
+	}
+	return exitSuccess
+}
+
+// decryptFile decrypts the input at inPath with the password and writes the plaintext to outPath. If
+// attrs is not nil, its permissions and modification time are applied to the output file. An existing
+// output file is only replaced if force is set.
+func (c *cli) decryptFile(inPath, outPath string, password []byte, attrs fs.FileInfo, force bool,
+	opts ...iocrypter.Option,
+) (err error) {
+	input, err := c.openInput(inPath)
+	if err != nil {
+		return fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer func() {
+		_ = input.Close()
+	}()
+	output, err := c.createOutput(outPath, attrs, force)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			output.abort()
+		}
+	}()
+
+	opts = append(opts, iocrypter.WithUnnamedTempFile(), iocrypter.WithEncryptedSpool())
+	decrypter, err := iocrypter.NewDecrypter(input, password, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to create decrypter: %w", err)
+	}
+	defer func() {
+		_ = decrypter.Close()
+	}()
+	if _, err = io.Copy(output, decrypter); err != nil {
+		return fmt.Errorf("failed to decrypt data: %w", err)
+	}
+	return output.commit()
+}
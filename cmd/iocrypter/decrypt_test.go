@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecrypt(t *testing.T) {
+	plaintext := []byte("This is the plaintext")
+	path := encryptTestFile(t, t.TempDir(), "plain.enc", plaintext)
+	ciphertext := readTestFile(t, path)
+
+	t.Run("decrypt with wrong password should fail", func(t *testing.T) {
+		t.Setenv(testPasswordEnv, "wrong password")
+		code, stdout, stderr := runCLI(t, ciphertext, "decrypt", "-password-env", testPasswordEnv)
+		if code != exitUnauthentic {
+			t.Errorf("expected exit code to be %d, got %d", exitUnauthentic, code)
+		}
+		if len(stdout) != 0 {
+			t.Errorf("expected no plaintext on stdout, got %d bytes", len(stdout))
+		}
+		if !strings.Contains(stderr, "failed to decrypt stdin") {
+			t.Errorf("expected error on stderr, got %q", stderr)
+		}
+	})
+	t.Run("decrypt tampered ciphertext should fail", func(t *testing.T) {
+		tampered := append([]byte(nil), ciphertext...)
+		tampered[len(tampered)-1] ^= 0xff
+		code, _, _ := runCLI(t, tampered, "decrypt", "-password-env", testPasswordEnv)
+		if code != exitUnauthentic {
+			t.Errorf("expected exit code to be %d, got %d", exitUnauthentic, code)
+		}
+	})
+	t.Run("decrypt data that is not encrypted should fail", func(t *testing.T) {
+		code, _, _ := runCLI(t, []byte("not encrypted"), "decrypt", "-password-env", testPasswordEnv)
+		if code == exitSuccess {
+			t.Error("expected decryption of unencrypted data to fail")
+		}
+	})
+}
@@ -0,0 +1,146 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"runtime"
+	"time"
+
+	"github.com/wneessen/iocrypter"
+)
+
+// runEncrypt runs the encrypt command, which encrypts the input with the password and writes the
+// ciphertext to the output. Input and output default to stdin and stdout. With -r, the files of the
+// input directory are encrypted recursively.
+func (c *cli) runEncrypt(args []string) int {
+	flags := c.newFlagSet("encrypt")
+	inFile := flags.String("i", stdioPath, "path to input file, or - for stdin")
+	outFile := flags.String("o", stdioPath, "path to output file, or - for stdout")
+	password := addPasswordFlags(flags, "password", "p", "password")
+	insecure := flags.Bool("insecure-password", false, "allow passing the password as argument")
+	argon2 := addArgon2Flags(flags)
+	concurrency := flags.Int("c", 1, "number of segments that are encrypted in parallel")
+	quiet := flags.Bool("q", false, "do not print status messages")
+	force := flags.Bool("force", false, "overwrite the output file if it exists")
+	recursive := flags.Bool("r", false, "encrypt the files of the input directory recursively")
+	suffix := flags.String("suffix", ".enc", "suffix that is appended to the encrypted file names with -r")
+	workers := flags.Int("j", runtime.NumCPU(), "number of files that are encrypted in parallel with -r")
+	flags.Usage = func() {
+		_, _ = fmt.Fprintln(flags.Output(), "usage: iocrypter encrypt [password options] [argon2 options] "+
+			"[-i <input file>] [-o <output file>] [-c <workers>] [-q] [-force]")
+		_, _ = fmt.Fprintln(flags.Output(), "       iocrypter encrypt [password options] [argon2 options] "+
+			"-r -i <input directory> [-o <output directory>] [-suffix <suffix>] [-j <workers>] [-c <workers>] "+
+			"[-q] [-force]")
+		_, _ = fmt.Fprintln(flags.Output(), "With -r and without -o, the output files are written next to the input files.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitSuccess
+		}
+		return exitUsage
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return exitUsage
+	}
+	if err := password.validate(*insecure); err != nil {
+		_, _ = fmt.Fprintln(c.stderr, err)
+		return exitUsage
+	}
+	opts, err := argon2.options()
+	if err != nil {
+		_, _ = fmt.Fprintln(c.stderr, err)
+		return exitUsage
+	}
+	opts = append(opts, iocrypter.WithConcurrency(*concurrency))
+	outDir := ""
+	if *outFile != stdioPath {
+		outDir = *outFile
+	}
+	if *recursive {
+		if err := checkTree(*inFile, outDir, *suffix); err != nil {
+			_, _ = fmt.Fprintf(c.stderr, "invalid input or output directory: %s\n", err)
+			return exitUsage
+		}
+	} else if err := checkOutput(*inFile, *outFile, *force); err != nil {
+		_, _ = fmt.Fprintln(c.stderr, err)
+		return exitFailure
+	}
+	pass, err := password.read(true)
+	if err != nil {
+		_, _ = fmt.Fprintln(c.stderr, err)
+		return exitFailure
+	}
+
+	if *recursive {
+		return c.processTree(treeConfig{
+			verb:   "encrypted",
+			inDir:  *inFile,
+			outDir: outDir,
+			rename: appendSuffix(*suffix),
+			process: func(inPath, outPath string, info fs.FileInfo) error {
+				return c.encryptFile(inPath, outPath, pass, info, *force, opts...)
+			},
+			exitCode: func(error) int {
+				return exitFailure
+			},
+			workers: *workers,
+			force:   *force,
+			quiet:   *quiet,
+		})
+	}
+
+	startTime := time.Now()
+	if err = c.encryptFile(*inFile, *outFile, pass, nil, *force, opts...); err != nil {
+		_, _ = fmt.Fprintf(c.stderr, "failed to encrypt %s: %s\n", displayName(*inFile, "stdin"), err)
+		return exitFailure
+	}
+	if !*quiet {
+		_, _ = fmt.Fprintf(c.stderr, "File %s successfully encrypted to: %s (Time: %s)\n",
+			displayName(*inFile, "stdin"), displayName(*outFile, "stdout"), time.Since(startTime).String())
+	}
+	return exitSuccess
+}
+
+// encryptFile encrypts the input at inPath with the password and writes the ciphertext to outPath. If
+// attrs is not nil, its permissions and modification time are applied to the output file. An existing
+// output file is only replaced if force is set.
+func (c *cli) encryptFile(inPath, outPath string, password []byte, attrs fs.FileInfo, force bool,
+	opts ...iocrypter.Option,
+) (err error) {
+	input, err := c.openInput(inPath)
+	if err != nil {
+		return fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer func() {
+		_ = input.Close()
+	}()
+	output, err := c.createOutput(outPath, attrs, force)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			output.abort()
+		}
+	}()
+
+	// Wrap the file key in a password key slot, so that the password can be changed with the rekey command
+	encrypter, err := iocrypter.NewEncrypterWithKeySlots(input,
+		[]iocrypter.KeySlot{iocrypter.PasswordKeySlot(password)}, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to create encrypter: %w", err)
+	}
+	if _, err = io.Copy(output, encrypter); err != nil {
+		return fmt.Errorf("failed to encrypt data: %w", err)
+	}
+	return output.commit()
+}
@@ -0,0 +1,97 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncrypt(t *testing.T) {
+	plaintext := make([]byte, 200*1024)
+	if _, err := rand.Read(plaintext); err != nil {
+		t.Fatalf("failed to generate plaintext: %s", err)
+	}
+	setTestPassword(t)
+
+	t.Run("encrypt and decrypt through stdin and stdout", func(t *testing.T) {
+		args := append([]string{"encrypt", "-password-env", testPasswordEnv}, testArgon2...)
+		code, ciphertext, stderr := runCLI(t, plaintext, args...)
+		if code != exitSuccess {
+			t.Fatalf("expected exit code to be %d, got %d: %s", exitSuccess, code, stderr)
+		}
+		if bytes.Contains(ciphertext, plaintext[:64]) {
+			t.Error("expected stdout to hold the ciphertext")
+		}
+		if !strings.Contains(stderr, "File stdin successfully encrypted to: stdout") {
+			t.Errorf("expected status message on stderr, got %q", stderr)
+		}
+		code, decrypted, stderr := runCLI(t, ciphertext, "decrypt", "-password-env", testPasswordEnv, "-q")
+		if code != exitSuccess {
+			t.Fatalf("expected exit code to be %d, got %d: %s", exitSuccess, code, stderr)
+		}
+		if !bytes.Equal(plaintext, decrypted) {
+			t.Error("expected decrypted data to match the plaintext")
+		}
+		if stderr != "" {
+			t.Errorf("expected no status message with -q, got %q", stderr)
+		}
+	})
+	t.Run("encrypt and decrypt files", func(t *testing.T) {
+		dir := t.TempDir()
+		input := writeTestFile(t, dir, "plain.bin", plaintext)
+		encrypted := filepath.Join(dir, "plain.bin.enc")
+		decrypted := filepath.Join(dir, "decrypted.bin")
+		args := append([]string{"encrypt", "-password-env", testPasswordEnv, "-i", input, "-o", encrypted,
+			"-c", "4"}, testArgon2...)
+		if code, stdout, stderr := runCLI(t, nil, args...); code != exitSuccess || len(stdout) != 0 {
+			t.Fatalf("expected exit code %d and no output on stdout, got %d and %d bytes: %s", exitSuccess, code,
+				len(stdout), stderr)
+		}
+		code, _, stderr := runCLI(t, nil, "decrypt", "-password-env", testPasswordEnv, "-i", encrypted, "-o",
+			decrypted)
+		if code != exitSuccess {
+			t.Fatalf("expected exit code to be %d, got %d: %s", exitSuccess, code, stderr)
+		}
+		if !bytes.Equal(plaintext, readTestFile(t, decrypted)) {
+			t.Error("expected decrypted file to match the plaintext")
+		}
+	})
+	t.Run("encrypt with - as input and output", func(t *testing.T) {
+		args := append([]string{"encrypt", "-password-env", testPasswordEnv, "-i", "-", "-o", "-", "-q"},
+			testArgon2...)
+		code, ciphertext, _ := runCLI(t, plaintext, args...)
+		if code != exitSuccess || len(ciphertext) <= len(plaintext) {
+			t.Fatalf("expected ciphertext on stdout, got exit code %d and %d bytes", code, len(ciphertext))
+		}
+	})
+	t.Run("encrypt with positional argument should fail", func(t *testing.T) {
+		if code, _, _ := runCLI(t, nil, "encrypt", "-password-env", testPasswordEnv, "file"); code != exitUsage {
+			t.Errorf("expected exit code to be %d, got %d", exitUsage, code)
+		}
+	})
+	t.Run("encrypt with incomplete Argon2 flags should fail", func(t *testing.T) {
+		code, _, stderr := runCLI(t, plaintext, "encrypt", "-password-env", testPasswordEnv, "-argon2-time", "2")
+		if code != exitUsage {
+			t.Errorf("expected exit code to be %d, got %d", exitUsage, code)
+		}
+		if !strings.Contains(stderr, errArgon2Flags.Error()) {
+			t.Errorf("expected error to be %s, got %q", errArgon2Flags, stderr)
+		}
+	})
+	t.Run("encrypt missing input file should fail", func(t *testing.T) {
+		input := filepath.Join(t.TempDir(), "missing")
+		code, _, stderr := runCLI(t, nil, "encrypt", "-password-env", testPasswordEnv, "-i", input)
+		if code != exitFailure {
+			t.Errorf("expected exit code to be %d, got %d", exitFailure, code)
+		}
+		if !strings.Contains(stderr, "failed to open input file") {
+			t.Errorf("expected error on stderr, got %q", stderr)
+		}
+	})
+}
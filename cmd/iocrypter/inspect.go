@@ -0,0 +1,254 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	wa "github.com/wneessen/argon2"
+
+	"github.com/wneessen/iocrypter"
+)
+
+// inspectResult is the header of an encrypted file as printed by the inspect command.
+type inspectResult struct {
+	File          string          `json:"file"`
+	Version       uint8           `json:"version"`
+	CipherSuite   string          `json:"cipher_suite"`
+	KeyDerivation string          `json:"key_derivation"`
+	Argon2        *argon2Settings `json:"argon2,omitempty"`
+	Scrypt        *scryptSettings `json:"scrypt,omitempty"`
+	PBKDF2        *pbkdf2Settings `json:"pbkdf2,omitempty"`
+	Salt          string          `json:"salt"`
+	IV            string          `json:"iv"`
+	SegmentSize   uint32          `json:"segment_size,omitempty"`
+	KeySlots      []keySlotResult `json:"key_slots,omitempty"`
+	HeaderLength  int             `json:"header_length"`
+}
+
+// argon2Settings holds the Argon2 settings as printed by the inspect command.
+type argon2Settings struct {
+	Memory     uint64 `json:"memory_kib"`
+	Time       uint64 `json:"time"`
+	Threads    uint64 `json:"threads"`
+	SaltLength uint64 `json:"salt_length"`
+	KeyLength  uint64 `json:"key_length"`
+}
+
+// scryptSettings holds the scrypt settings as printed by the inspect command.
+type scryptSettings struct {
+	LogN       uint64 `json:"log_n"`
+	R          uint64 `json:"r"`
+	P          uint64 `json:"p"`
+	SaltLength uint64 `json:"salt_length"`
+	KeyLength  uint64 `json:"key_length"`
+}
+
+// pbkdf2Settings holds the PBKDF2 settings as printed by the inspect command.
+type pbkdf2Settings struct {
+	Iterations uint64 `json:"iterations"`
+	SaltLength uint64 `json:"salt_length"`
+	KeyLength  uint64 `json:"key_length"`
+}
+
+// keySlotResult is a key slot as printed by the inspect command.
+type keySlotResult struct {
+	Type          string          `json:"type"`
+	KeyDerivation string          `json:"key_derivation,omitempty"`
+	Argon2        *argon2Settings `json:"argon2,omitempty"`
+	Scrypt        *scryptSettings `json:"scrypt,omitempty"`
+	PBKDF2        *pbkdf2Settings `json:"pbkdf2,omitempty"`
+	Salt          string          `json:"salt,omitempty"`
+}
+
+// runInspect runs the inspect command, which prints the header parameters of the given files as text
+// or, with -json, as one JSON object per line.
+func (c *cli) runInspect(args []string) int {
+	flags := c.newFlagSet("inspect")
+	asJSON := flags.Bool("json", false, "print the headers as JSON, one object per line")
+	flags.Usage = func() {
+		_, _ = fmt.Fprintln(flags.Output(), "usage: iocrypter inspect [-json] <file>...")
+		_, _ = fmt.Fprintln(flags.Output(), "A file of - inspects stdin.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitSuccess
+		}
+		return exitUsage
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	status := exitSuccess
+	encoder := json.NewEncoder(c.stdout)
+	for i, path := range flags.Args() {
+		result, err := c.inspectFile(path)
+		if err != nil {
+			_, _ = fmt.Fprintf(c.stderr, "failed to inspect %s: %s\n", path, err)
+			status = exitFailure
+			continue
+		}
+		if *asJSON {
+			err = encoder.Encode(result)
+		} else {
+			if i > 0 {
+				_, _ = fmt.Fprintln(c.stdout)
+			}
+			err = printInspectResult(c.stdout, result)
+		}
+		if err != nil {
+			_, _ = fmt.Fprintf(c.stderr, "failed to print header of %s: %s\n", path, err)
+			return exitFailure
+		}
+	}
+	return status
+}
+
+// inspectFile parses the header of the given file, or of stdin if the path is "-".
+func (c *cli) inspectFile(path string) (*inspectResult, error) {
+	file, err := c.openInput(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	hdr, err := iocrypter.ParseHeader(file)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &inspectResult{
+		File:          path,
+		Version:       hdr.Version,
+		CipherSuite:   hdr.CipherSuite.String(),
+		KeyDerivation: hdr.KeyDerivation,
+		Argon2:        newArgon2Settings(hdr.Settings),
+		Scrypt:        newScryptSettings(hdr.Scrypt),
+		PBKDF2:        newPBKDF2Settings(hdr.PBKDF2),
+		Salt:          hex.EncodeToString(hdr.Salt),
+		IV:            hex.EncodeToString(hdr.IV),
+		SegmentSize:   hdr.SegmentSize,
+		HeaderLength:  hdr.Length,
+	}
+	for _, slot := range hdr.KeySlots {
+		result.KeySlots = append(result.KeySlots, keySlotResult{
+			Type:          slot.Type,
+			KeyDerivation: slot.KeyDerivation,
+			Argon2:        newArgon2Settings(slot.Settings),
+			Scrypt:        newScryptSettings(slot.Scrypt),
+			PBKDF2:        newPBKDF2Settings(slot.PBKDF2),
+			Salt:          hex.EncodeToString(slot.Salt),
+		})
+	}
+	return result, nil
+}
+
+// newArgon2Settings returns the given Argon2 settings for printing, or nil if they are not set.
+func newArgon2Settings(settings wa.Settings) *argon2Settings {
+	if settings.Time == 0 {
+		return nil
+	}
+	return &argon2Settings{
+		Memory:     uint64(settings.Memory),
+		Time:       uint64(settings.Time),
+		Threads:    uint64(settings.Threads),
+		SaltLength: uint64(settings.SaltLength),
+		KeyLength:  uint64(settings.KeyLength),
+	}
+}
+
+// newScryptSettings returns the given scrypt settings for printing, or nil if they are not set.
+func newScryptSettings(settings iocrypter.ScryptSettings) *scryptSettings {
+	if settings.LogN == 0 {
+		return nil
+	}
+	return &scryptSettings{
+		LogN:       uint64(settings.LogN),
+		R:          uint64(settings.R),
+		P:          uint64(settings.P),
+		SaltLength: uint64(settings.SaltLength),
+		KeyLength:  uint64(settings.KeyLength),
+	}
+}
+
+// newPBKDF2Settings returns the given PBKDF2 settings for printing, or nil if they are not set.
+func newPBKDF2Settings(settings iocrypter.PBKDF2Settings) *pbkdf2Settings {
+	if settings.Iterations == 0 {
+		return nil
+	}
+	return &pbkdf2Settings{
+		Iterations: uint64(settings.Iterations),
+		SaltLength: uint64(settings.SaltLength),
+		KeyLength:  uint64(settings.KeyLength),
+	}
+}
+
+// printInspectResult prints the given header as text to w.
+func printInspectResult(w io.Writer, result *inspectResult) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(table, "File:\t%s\n", result.File)
+	_, _ = fmt.Fprintf(table, "Format version:\t%d\n", result.Version)
+	_, _ = fmt.Fprintf(table, "Cipher suite:\t%s\n", result.CipherSuite)
+	_, _ = fmt.Fprintf(table, "Key derivation:\t%s\n", result.KeyDerivation)
+	if result.Argon2 != nil {
+		_, _ = fmt.Fprintf(table, "Argon2:\t%s\n", result.Argon2)
+	}
+	if result.Scrypt != nil {
+		_, _ = fmt.Fprintf(table, "scrypt:\t%s\n", result.Scrypt)
+	}
+	if result.PBKDF2 != nil {
+		_, _ = fmt.Fprintf(table, "PBKDF2:\t%s\n", result.PBKDF2)
+	}
+	_, _ = fmt.Fprintf(table, "Salt:\t%s\n", result.Salt)
+	_, _ = fmt.Fprintf(table, "IV:\t%s\n", result.IV)
+	if result.SegmentSize > 0 {
+		_, _ = fmt.Fprintf(table, "Segment size:\t%d bytes\n", result.SegmentSize)
+	}
+	for i, slot := range result.KeySlots {
+		_, _ = fmt.Fprintf(table, "Key slot %d:\t%s\n", i+1, slot.Type)
+		if slot.KeyDerivation == "" {
+			continue
+		}
+		_, _ = fmt.Fprintf(table, "  Key derivation:\t%s\n", slot.KeyDerivation)
+		switch {
+		case slot.Argon2 != nil:
+			_, _ = fmt.Fprintf(table, "  Argon2:\t%s\n", slot.Argon2)
+		case slot.Scrypt != nil:
+			_, _ = fmt.Fprintf(table, "  scrypt:\t%s\n", slot.Scrypt)
+		case slot.PBKDF2 != nil:
+			_, _ = fmt.Fprintf(table, "  PBKDF2:\t%s\n", slot.PBKDF2)
+		}
+		_, _ = fmt.Fprintf(table, "  Salt:\t%s\n", slot.Salt)
+	}
+	_, _ = fmt.Fprintf(table, "Header length:\t%d bytes\n", result.HeaderLength)
+	return table.Flush()
+}
+
+// String satisfies the fmt.Stringer interface for the argon2Settings type.
+func (s *argon2Settings) String() string {
+	return fmt.Sprintf("memory %d KiB, time %d, threads %d, salt length %d, key length %d", s.Memory, s.Time,
+		s.Threads, s.SaltLength, s.KeyLength)
+}
+
+// String satisfies the fmt.Stringer interface for the scryptSettings type.
+func (s *scryptSettings) String() string {
+	return fmt.Sprintf("N 2^%d, r %d, p %d, salt length %d, key length %d", s.LogN, s.R, s.P, s.SaltLength,
+		s.KeyLength)
+}
+
+// String satisfies the fmt.Stringer interface for the pbkdf2Settings type.
+func (s *pbkdf2Settings) String() string {
+	return fmt.Sprintf("iterations %d, salt length %d, key length %d", s.Iterations, s.SaltLength, s.KeyLength)
+}
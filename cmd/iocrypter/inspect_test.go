@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	dir := t.TempDir()
+	path := encryptTestFile(t, dir, "plain.enc", []byte("This is the plaintext"))
+
+	t.Run("inspect file as text", func(t *testing.T) {
+		code, stdout, stderr := runCLI(t, nil, "inspect", path)
+		if code != exitSuccess {
+			t.Fatalf("expected exit code to be %d, got %d: %s", exitSuccess, code, stderr)
+		}
+		for _, want := range []string{"Key derivation:", "key slots", "Key slot 1:", "password", "Argon2id",
+			"memory 8192 KiB, time 1, threads 1"} {
+			if !strings.Contains(string(stdout), want) {
+				t.Errorf("expected output to contain %q, got %q", want, stdout)
+			}
+		}
+	})
+	t.Run("inspect stdin as JSON", func(t *testing.T) {
+		code, stdout, stderr := runCLI(t, readTestFile(t, path), "inspect", "-json", "-")
+		if code != exitSuccess {
+			t.Fatalf("expected exit code to be %d, got %d: %s", exitSuccess, code, stderr)
+		}
+		var result inspectResult
+		if err := json.Unmarshal(stdout, &result); err != nil {
+			t.Fatalf("failed to decode JSON output: %s", err)
+		}
+		if result.File != "-" || result.KeyDerivation != "key slots" || len(result.KeySlots) != 1 {
+			t.Errorf("unexpected inspect result: %+v", result)
+		}
+		if result.KeySlots[0].Argon2 == nil || result.KeySlots[0].Argon2.Memory != 8192 {
+			t.Errorf("expected Argon2 settings of the key slot, got %+v", result.KeySlots[0])
+		}
+	})
+	t.Run("inspect multiple files with one missing should fail", func(t *testing.T) {
+		missing := filepath.Join(dir, "missing")
+		code, stdout, stderr := runCLI(t, nil, "inspect", "-json", path, missing)
+		if code != exitFailure {
+			t.Errorf("expected exit code to be %d, got %d", exitFailure, code)
+		}
+		if bytes.Count(stdout, []byte("\n")) != 1 {
+			t.Errorf("expected the header of the existing file on stdout, got %q", stdout)
+		}
+		if !strings.Contains(stderr, "failed to inspect "+missing) {
+			t.Errorf("expected error on stderr, got %q", stderr)
+		}
+	})
+	t.Run("inspect without file should fail", func(t *testing.T) {
+		if code, _, _ := runCLI(t, nil, "inspect"); code != exitUsage {
+			t.Errorf("expected exit code to be %d, got %d", exitUsage, code)
+		}
+	})
+}
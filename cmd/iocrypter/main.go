@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+// Command iocrypter is a command line tool for files encrypted with the iocrypter package. The first
+// argument selects the subcommand, e.g. "iocrypter inspect <file>". Paths of "-" select stdin or
+// stdout, so that the tool can be used in shell pipelines, while status messages are printed to
+// stderr. Passwords are prompted for on the terminal or read from a file, a file descriptor or an
+// environment variable. With -r, the encrypt and decrypt subcommands process directory trees.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	// exitSuccess is the exit code if the subcommand succeeded.
+	exitSuccess = 0
+
+	// exitFailure is the exit code if the subcommand failed.
+	exitFailure = 1
+
+	// exitUsage is the exit code if the command line arguments are invalid.
+	exitUsage = 2
+
+	// exitUnauthentic is the exit code if an encrypted file failed authentication, i.e. it is
+	// corrupted, has been tampered with or the password is incorrect.
+	exitUnauthentic = 3
+
+	// exitInterrupted is the exit code if the subcommand was interrupted by a signal.
+	exitInterrupted = 130
+)
+
+// cli holds the standard streams the subcommands read from and write to, so that they can be
+// replaced in tests.
+type cli struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// command is a subcommand of the iocrypter tool.
+type command struct {
+	name        string
+	description string
+	run         func(c *cli, args []string) int
+}
+
+// commands holds the subcommands of the iocrypter tool.
+var commands = []command{
+	{name: "encrypt", description: "encrypt a file or stdin with a password", run: (*cli).runEncrypt},
+	{name: "decrypt", description: "decrypt a file or stdin with a password", run: (*cli).runDecrypt},
+	{name: "rekey", description: "change the password of an encrypted file", run: (*cli).runRekey},
+	{name: "inspect", description: "print the header parameters of encrypted files", run: (*cli).runInspect},
+	{name: "verify", description: "authenticate encrypted files without decrypting them", run: (*cli).runVerify},
+	{name: "calibrate", description: "benchmark the Argon2 settings for a target duration", run: (*cli).runCalibrate},
+}
+
+func main() {
+	removeOutputsOnInterrupt()
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the subcommand selected by the first of the given arguments with the given standard
+// streams and returns the exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr}
+	if len(args) < 1 {
+		c.usage()
+		return exitUsage
+	}
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(c, args[1:])
+		}
+	}
+	_, _ = fmt.Fprintf(c.stderr, "unknown command: %s\n", args[0])
+	c.usage()
+	return exitUsage
+}
+
+// usage prints the list of subcommands to stderr.
+func (c *cli) usage() {
+	_, _ = fmt.Fprint(c.stderr, "usage: iocrypter <command> [arguments]\n\ncommands:\n")
+	for _, cmd := range commands {
+		_, _ = fmt.Fprintf(c.stderr, "  %-10s %s\n", cmd.name, cmd.description)
+	}
+}
+
+// newFlagSet returns a flag.FlagSet for the subcommand with the given name that prints its usage
+// and errors to stderr.
+func (c *cli) newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	return flags
+}
@@ -0,0 +1,111 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	// testPassword is the password the test files are encrypted with.
+	testPassword = "iocrypter test password"
+
+	// testPasswordEnv is the environment variable the test password is passed with.
+	testPasswordEnv = "IOCRYPTER_TEST_PASSWORD"
+)
+
+// testArgon2 holds the Argon2 flags that keep the key derivation in the tests fast.
+var testArgon2 = []string{"-argon2-memory", "8192", "-argon2-time", "1", "-argon2-threads", "1"}
+
+func TestRun(t *testing.T) {
+	t.Run("running without command should fail", func(t *testing.T) {
+		code, _, stderr := runCLI(t, nil)
+		if code != exitUsage {
+			t.Errorf("expected exit code to be %d, got %d", exitUsage, code)
+		}
+		if !strings.Contains(stderr, "usage: iocrypter <command>") {
+			t.Errorf("expected usage on stderr, got %q", stderr)
+		}
+	})
+	t.Run("running unknown command should fail", func(t *testing.T) {
+		code, _, stderr := runCLI(t, nil, "unknown")
+		if code != exitUsage {
+			t.Errorf("expected exit code to be %d, got %d", exitUsage, code)
+		}
+		if !strings.Contains(stderr, "unknown command: unknown") {
+			t.Errorf("expected unknown command on stderr, got %q", stderr)
+		}
+	})
+	t.Run("running command with unknown flag should fail", func(t *testing.T) {
+		if code, _, _ := runCLI(t, nil, "encrypt", "-unknown"); code != exitUsage {
+			t.Errorf("expected exit code to be %d, got %d", exitUsage, code)
+		}
+	})
+	t.Run("running command with -h succeeds", func(t *testing.T) {
+		code, _, stderr := runCLI(t, nil, "decrypt", "-h")
+		if code != exitSuccess {
+			t.Errorf("expected exit code to be %d, got %d", exitSuccess, code)
+		}
+		if !strings.Contains(stderr, "usage: iocrypter decrypt") {
+			t.Errorf("expected usage on stderr, got %q", stderr)
+		}
+	})
+}
+
+// runCLI runs the iocrypter tool with the given stdin and arguments and returns the exit code, the
+// data written to stdout and the messages written to stderr.
+func runCLI(t *testing.T, stdin []byte, args ...string) (int, []byte, string) {
+	t.Helper()
+	stdout, stderr := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	code := run(args, bytes.NewReader(stdin), stdout, stderr)
+	return code, stdout.Bytes(), stderr.String()
+}
+
+// setTestPassword sets the environment variable that holds the test password for the duration of
+// the test.
+func setTestPassword(t *testing.T) {
+	t.Helper()
+	t.Setenv(testPasswordEnv, testPassword)
+}
+
+// writeTestFile writes data to the file with the given name in dir and returns its path.
+func writeTestFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatalf("failed to create directory: %s", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("failed to write test file: %s", err)
+	}
+	return path
+}
+
+// encryptTestFile encrypts plaintext with the test password and writes it to the file with the given
+// name in dir. It returns the path of the encrypted file.
+func encryptTestFile(t *testing.T, dir, name string, plaintext []byte) string {
+	t.Helper()
+	setTestPassword(t)
+	path := filepath.Join(dir, name)
+	args := append([]string{"encrypt", "-password-env", testPasswordEnv, "-o", path, "-q"}, testArgon2...)
+	if code, _, stderr := runCLI(t, plaintext, args...); code != exitSuccess {
+		t.Fatalf("failed to encrypt test file: exit code %d: %s", code, stderr)
+	}
+	return path
+}
+
+// readTestFile returns the content of the file at path.
+func readTestFile(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read test file: %s", err)
+	}
+	return data
+}
@@ -0,0 +1,172 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+var (
+	// errOutputExists indicates that the output file already exists and -force is not set.
+	errOutputExists = errors.New("output file already exists, use -force to overwrite it")
+
+	// errSameFile indicates that the input and the output are the same file.
+	errSameFile = errors.New("input and output are the same file")
+)
+
+// pendingOutputs holds the temporary files of the outputs that are neither committed nor aborted,
+// so that they can be removed if the process is interrupted.
+var pendingOutputs sync.Map
+
+// output is the destination of a subcommand. Data written to it only becomes visible at its path
+// once commit succeeds, if the subcommand fails, abort discards it.
+type output interface {
+	io.Writer
+
+	// commit makes the written data visible at the path of the output.
+	commit() error
+
+	// abort discards the written data.
+	abort()
+}
+
+// stdoutOutput is the output for stdout. Since data written to stdout cannot be taken back, commit
+// and abort do nothing.
+type stdoutOutput struct {
+	io.Writer
+}
+
+// atomicOutput is the output for a file. The data is written to a temporary file in the same
+// directory, which is moved to path once it is committed, so that a failed subcommand never leaves
+// a truncated or empty output file behind. Unless force is set, an existing file at path is never
+// replaced, even if it was created while the data was processed.
+type atomicOutput struct {
+	*os.File
+	path  string
+	attrs fs.FileInfo
+	force bool
+}
+
+// checkOutput validates the output path against the input path before any data is processed. It
+// returns errSameFile if both refer to the same file and errOutputExists if the output file exists
+// and force is not set. Since the output file may still be created in the meantime, the output
+// checks again when it is committed.
+func checkOutput(inPath, outPath string, force bool) error {
+	if outPath == stdioPath {
+		return nil
+	}
+	outInfo, err := os.Stat(outPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check output file: %w", err)
+	}
+	if inPath != stdioPath {
+		if inInfo, err := os.Stat(inPath); err == nil && os.SameFile(inInfo, outInfo) {
+			return errSameFile
+		}
+	}
+	if !force {
+		return errOutputExists
+	}
+	return nil
+}
+
+// createOutput returns the output for the given path, or for stdout if the path is "-". The output
+// file is created with permissions 0600, since it holds either the plaintext or the ciphertext. If
+// attrs is not nil, its permissions and modification time are applied to the output file instead.
+// An existing output file is only replaced if force is set.
+func (c *cli) createOutput(path string, attrs fs.FileInfo, force bool) (output, error) {
+	if path == stdioPath {
+		return stdoutOutput{c.stdout}, nil
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	pendingOutputs.Store(file.Name(), struct{}{})
+	return &atomicOutput{File: file, path: path, attrs: attrs, force: force}, nil
+}
+
+// removePendingOutputs removes the temporary files of all outputs that are neither committed nor
+// aborted.
+func removePendingOutputs() {
+	pendingOutputs.Range(func(name, _ any) bool {
+		_ = os.Remove(name.(string))
+		return true
+	})
+}
+
+// removeOutputsOnInterrupt removes the temporary files of pending outputs once the process receives
+// an interrupt or termination signal, and exits with exitInterrupted.
+func removeOutputsOnInterrupt() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		removePendingOutputs()
+		os.Exit(exitInterrupted)
+	}()
+}
+
+// commit satisfies the output interface for the stdoutOutput type.
+func (stdoutOutput) commit() error {
+	return nil
+}
+
+// abort satisfies the output interface for the stdoutOutput type.
+func (stdoutOutput) abort() {}
+
+// commit satisfies the output interface for the atomicOutput type. The temporary file is synced to
+// disk before it is moved, so that the output file is complete even after a crash. Unless force is
+// set, it is hard linked to the path, which fails with fs.ErrExist instead of replacing a file that
+// was created in the meantime. If commit fails, the output has to be aborted.
+func (o *atomicOutput) commit() error {
+	if err := o.Sync(); err != nil {
+		return fmt.Errorf("failed to sync output file: %w", err)
+	}
+	if err := o.Close(); err != nil {
+		return fmt.Errorf("failed to close output file: %w", err)
+	}
+	if o.attrs != nil {
+		if err := preserveAttributes(o.Name(), o.attrs); err != nil {
+			return fmt.Errorf("failed to preserve attributes of output file: %w", err)
+		}
+	}
+	if o.force {
+		if err := os.Rename(o.Name(), o.path); err != nil {
+			return fmt.Errorf("failed to rename output file: %w", err)
+		}
+		pendingOutputs.Delete(o.Name())
+		return nil
+	}
+	if err := os.Link(o.Name(), o.path); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("%w: %w", errOutputExists, err)
+		}
+		return fmt.Errorf("failed to link output file: %w", err)
+	}
+	if err := os.Remove(o.Name()); err != nil {
+		return fmt.Errorf("failed to remove temporary output file: %w", err)
+	}
+	pendingOutputs.Delete(o.Name())
+	return nil
+}
+
+// abort satisfies the output interface for the atomicOutput type.
+func (o *atomicOutput) abort() {
+	_ = o.Close()
+	_ = os.Remove(o.Name())
+	pendingOutputs.Delete(o.Name())
+}
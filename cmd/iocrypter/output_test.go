@@ -0,0 +1,262 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestCheckOutput(t *testing.T) {
+	dir := t.TempDir()
+	input := writeTestFile(t, dir, "input", []byte("input"))
+	existing := writeTestFile(t, dir, "existing", []byte("existing"))
+	missing := filepath.Join(dir, "missing")
+
+	tests := []struct {
+		name    string
+		inPath  string
+		outPath string
+		force   bool
+		wantErr error
+	}{
+		{"output to stdout", input, stdioPath, false, nil},
+		{"output does not exist", input, missing, false, nil},
+		{"output exists", input, existing, false, errOutputExists},
+		{"output exists with force", input, existing, true, nil},
+		{"output exists with stdin as input", stdioPath, existing, false, errOutputExists},
+		{"input and output are the same file", input, input, false, errSameFile},
+		{"input and output are the same file with force", input, input, true, errSameFile},
+		{"input and output are the same file by another path", input, filepath.Join(dir, ".", "input"),
+			true, errSameFile},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkOutput(tt.inPath, tt.outPath, tt.force)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected error to be %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+	t.Run("input and output are the same file through a symlink", func(t *testing.T) {
+		link := filepath.Join(dir, "link")
+		if err := os.Symlink(input, link); err != nil {
+			t.Skipf("symlinks are not supported: %s", err)
+		}
+		if err := checkOutput(input, link, true); !errors.Is(err, errSameFile) {
+			t.Errorf("expected error to be %s, got %s", errSameFile, err)
+		}
+	})
+}
+
+func TestOutput(t *testing.T) {
+	plaintext := []byte("This is the plaintext")
+	setTestPassword(t)
+
+	t.Run("encrypt refuses to overwrite existing output", func(t *testing.T) {
+		dir := t.TempDir()
+		output := writeTestFile(t, dir, "output.enc", []byte("existing"))
+		args := append([]string{"encrypt", "-password-env", testPasswordEnv, "-o", output}, testArgon2...)
+		code, _, stderr := runCLI(t, plaintext, args...)
+		if code != exitFailure {
+			t.Errorf("expected exit code to be %d, got %d", exitFailure, code)
+		}
+		if !strings.Contains(stderr, errOutputExists.Error()) {
+			t.Errorf("expected error to be %s, got %q", errOutputExists, stderr)
+		}
+		checkOutputUnchanged(t, output, []byte("existing"))
+	})
+	t.Run("encrypt overwrites existing output with force", func(t *testing.T) {
+		dir := t.TempDir()
+		output := writeTestFile(t, dir, "output.enc", []byte("existing"))
+		args := append([]string{"encrypt", "-password-env", testPasswordEnv, "-o", output, "-force"},
+			testArgon2...)
+		if code, _, stderr := runCLI(t, plaintext, args...); code != exitSuccess {
+			t.Fatalf("expected exit code to be %d, got %d: %s", exitSuccess, code, stderr)
+		}
+		code, decrypted, _ := runCLI(t, nil, "decrypt", "-password-env", testPasswordEnv, "-i", output)
+		if code != exitSuccess || !bytes.Equal(plaintext, decrypted) {
+			t.Errorf("expected overwritten output to decrypt to the plaintext, got exit code %d", code)
+		}
+		checkNoTempFiles(t, dir)
+	})
+	t.Run("decrypt refuses to overwrite existing output", func(t *testing.T) {
+		dir := t.TempDir()
+		input := encryptTestFile(t, dir, "input.enc", plaintext)
+		output := writeTestFile(t, dir, "output", []byte("existing"))
+		code, _, _ := runCLI(t, nil, "decrypt", "-password-env", testPasswordEnv, "-i", input, "-o", output)
+		if code != exitFailure {
+			t.Errorf("expected exit code to be %d, got %d", exitFailure, code)
+		}
+		checkOutputUnchanged(t, output, []byte("existing"))
+	})
+	t.Run("rekey refuses to overwrite existing output", func(t *testing.T) {
+		dir := t.TempDir()
+		input := encryptTestFile(t, dir, "input.enc", plaintext)
+		output := writeTestFile(t, dir, "output.enc", []byte("existing"))
+		code, _, _ := runCLI(t, nil, "rekey", "-password-env", testPasswordEnv, "-new-password-env",
+			testPasswordEnv, "-i", input, "-o", output)
+		if code != exitFailure {
+			t.Errorf("expected exit code to be %d, got %d", exitFailure, code)
+		}
+		checkOutputUnchanged(t, output, []byte("existing"))
+	})
+	t.Run("input and output as the same file is rejected", func(t *testing.T) {
+		dir := t.TempDir()
+		input := encryptTestFile(t, dir, "input.enc", plaintext)
+		ciphertext := readTestFile(t, input)
+		commands := [][]string{
+			{"decrypt", "-password-env", testPasswordEnv, "-i", input, "-o", input, "-force"},
+			{"rekey", "-password-env", testPasswordEnv, "-new-password-env", testPasswordEnv, "-i", input, "-o",
+				input, "-force"},
+		}
+		for _, args := range commands {
+			code, _, stderr := runCLI(t, nil, args...)
+			if code != exitFailure {
+				t.Errorf("%s: expected exit code to be %d, got %d", args[0], exitFailure, code)
+			}
+			if !strings.Contains(stderr, errSameFile.Error()) {
+				t.Errorf("%s: expected error to be %s, got %q", args[0], errSameFile, stderr)
+			}
+			checkOutputUnchanged(t, input, ciphertext)
+		}
+	})
+	t.Run("failed encryption removes the temporary file", func(t *testing.T) {
+		dir := t.TempDir()
+		output := writeTestFile(t, dir, "output.enc", []byte("existing"))
+		stdin := io.MultiReader(bytes.NewReader(plaintext), iotest.ErrReader(errors.New("read error")))
+		args := append([]string{"encrypt", "-password-env", testPasswordEnv, "-o", output, "-force"},
+			testArgon2...)
+		stdout, stderr := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+		if code := run(args, stdin, stdout, stderr); code != exitFailure {
+			t.Errorf("expected exit code to be %d, got %d", exitFailure, code)
+		}
+		if !strings.Contains(stderr.String(), "read error") {
+			t.Errorf("expected read error on stderr, got %q", stderr)
+		}
+		checkOutputUnchanged(t, output, []byte("existing"))
+		checkNoTempFiles(t, dir)
+	})
+	t.Run("failed decryption with wrong password removes the temporary file", func(t *testing.T) {
+		dir := t.TempDir()
+		input := encryptTestFile(t, dir, "input.enc", plaintext)
+		output := writeTestFile(t, dir, "output", []byte("existing"))
+		t.Setenv(testPasswordEnv, "wrong password")
+		code, _, _ := runCLI(t, nil, "decrypt", "-password-env", testPasswordEnv, "-i", input, "-o", output,
+			"-force")
+		if code != exitUnauthentic {
+			t.Errorf("expected exit code to be %d, got %d", exitUnauthentic, code)
+		}
+		checkOutputUnchanged(t, output, []byte("existing"))
+		checkNoTempFiles(t, dir)
+	})
+	t.Run("failed decryption of truncated ciphertext removes the temporary file", func(t *testing.T) {
+		dir := t.TempDir()
+		input := encryptTestFile(t, dir, "input.enc", bytes.Repeat(plaintext, 10000))
+		ciphertext := readTestFile(t, input)
+		truncated := writeTestFile(t, dir, "truncated.enc", ciphertext[:len(ciphertext)-100])
+		output := filepath.Join(dir, "output")
+		code, _, _ := runCLI(t, nil, "decrypt", "-password-env", testPasswordEnv, "-i", truncated, "-o", output)
+		if code != exitUnauthentic {
+			t.Errorf("expected exit code to be %d, got %d", exitUnauthentic, code)
+		}
+		if _, err := os.Stat(output); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected no output file after failed decryption, got %v", err)
+		}
+		checkNoTempFiles(t, dir)
+	})
+	t.Run("failed rekey removes the temporary file", func(t *testing.T) {
+		dir := t.TempDir()
+		input := encryptTestFile(t, dir, "input.enc", plaintext)
+		output := writeTestFile(t, dir, "output.enc", []byte("existing"))
+		t.Setenv("IOCRYPTER_TEST_WRONG_PASSWORD", "wrong password")
+		code, _, _ := runCLI(t, nil, "rekey", "-password-env", "IOCRYPTER_TEST_WRONG_PASSWORD",
+			"-new-password-env", testPasswordEnv, "-i", input, "-o", output, "-force")
+		if code != exitFailure {
+			t.Errorf("expected exit code to be %d, got %d", exitFailure, code)
+		}
+		checkOutputUnchanged(t, output, []byte("existing"))
+		checkNoTempFiles(t, dir)
+	})
+}
+
+func TestAtomicOutput(t *testing.T) {
+	c := &cli{stdout: io.Discard, stderr: io.Discard}
+	for _, force := range []bool{false, true} {
+		t.Run(fmt.Sprintf("commit over output file created in the meantime (force: %t)", force), func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "output")
+			output, err := c.createOutput(path, nil, force)
+			if err != nil {
+				t.Fatalf("failed to create output: %s", err)
+			}
+			if _, err = output.Write([]byte("new")); err != nil {
+				t.Fatalf("failed to write output: %s", err)
+			}
+			writeTestFile(t, dir, "output", []byte("existing"))
+			err = output.commit()
+			if err != nil {
+				output.abort()
+			}
+			switch {
+			case force && err != nil:
+				t.Fatalf("failed to commit output: %s", err)
+			case force:
+				checkOutputUnchanged(t, path, []byte("new"))
+			case !errors.Is(err, fs.ErrExist) || !errors.Is(err, errOutputExists):
+				t.Errorf("expected error to be %s, got %s", errOutputExists, err)
+			default:
+				checkOutputUnchanged(t, path, []byte("existing"))
+			}
+			checkNoTempFiles(t, dir)
+		})
+	}
+}
+
+func TestRemovePendingOutputs(t *testing.T) {
+	dir := t.TempDir()
+	c := &cli{stdout: io.Discard, stderr: io.Discard}
+	output, err := c.createOutput(filepath.Join(dir, "output"), nil, false)
+	if err != nil {
+		t.Fatalf("failed to create output: %s", err)
+	}
+	if _, err = output.Write([]byte("partial")); err != nil {
+		t.Fatalf("failed to write output: %s", err)
+	}
+	removePendingOutputs()
+	output.abort()
+	checkNoTempFiles(t, dir)
+	if _, err = os.Stat(filepath.Join(dir, "output")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected no output file after removing pending outputs, got %v", err)
+	}
+}
+
+// checkOutputUnchanged checks that the file at path still holds want.
+func checkOutputUnchanged(t *testing.T, path string, want []byte) {
+	t.Helper()
+	if got := readTestFile(t, path); !bytes.Equal(got, want) {
+		t.Errorf("expected %s to be unchanged, got %d bytes", path, len(got))
+	}
+}
+
+// checkNoTempFiles checks that no temporary output files remain in dir.
+func checkNoTempFiles(t *testing.T, dir string) {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, ".*.tmp"))
+	if err != nil {
+		t.Fatalf("failed to list temporary files: %s", err)
+	}
+	if len(matches) != 0 {
+		t.Errorf("expected no temporary files to remain, got %v", matches)
+	}
+}
@@ -0,0 +1,199 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// terminalPath is the path of the controlling terminal, which the password is prompted on, so that
+// stdin and stdout remain available for the data.
+const terminalPath = "/dev/tty"
+
+var (
+	// errInsecurePassword indicates that the password was passed as command line argument without
+	// forcing it with -insecure-password.
+	errInsecurePassword = errors.New("passing the password as argument exposes it in the shell history and " +
+		"the process list, use a password file, file descriptor, environment variable or the prompt instead, " +
+		"or force it with -insecure-password")
+
+	// errPasswordSources indicates that more than one source for the same password was given.
+	errPasswordSources = errors.New("only one password source can be used")
+
+	// errStdioPasswordFD indicates that the password file descriptor is stdin, stdout or stderr. These
+	// are used for the data and the messages, and would be closed once the password has been read.
+	errStdioPasswordFD = errors.New("password file descriptor must not be stdin, stdout or stderr, use " +
+		"-password-file /dev/stdin if the data is read from a file")
+
+	// errEmptyPassword indicates that the password read from its source is empty.
+	errEmptyPassword = errors.New("password must not be empty")
+
+	// errPasswordMismatch indicates that the confirmation of a prompted password does not match.
+	errPasswordMismatch = errors.New("passwords do not match")
+
+	// errNoTerminal indicates that the password cannot be prompted for, since there is no terminal.
+	errNoTerminal = errors.New("no terminal to prompt for the password, use a password file, file " +
+		"descriptor or environment variable instead")
+)
+
+// passwordFlags holds the flags that select the source of a password. If none of them is set, the
+// password is prompted for on the terminal.
+type passwordFlags struct {
+	name string
+	arg  *string
+	file *string
+	fd   *int
+	env  *string
+}
+
+// addPasswordFlags defines the flags for the password with the given name on flags. The password can
+// be passed as argument with the flag argName, or read from a file, file descriptor or environment
+// variable with the flags prefixed by prefix.
+func addPasswordFlags(flags *flag.FlagSet, name, argName, prefix string) *passwordFlags {
+	return &passwordFlags{
+		name: name,
+		arg:  flags.String(argName, "", name+" (insecure, requires -insecure-password)"),
+		file: flags.String(prefix+"-file", "", "read the "+name+" from the first line of the given file"),
+		fd:   flags.Int(prefix+"-fd", -1, "read the "+name+" from the first line of the given file descriptor"),
+		env:  flags.String(prefix+"-env", "", "read the "+name+" from the given environment variable"),
+	}
+}
+
+// validate checks that at most one source is set for the password, that it is only passed as argument
+// if insecure is set and that the file descriptor is not one of the standard streams.
+func (p *passwordFlags) validate(insecure bool) error {
+	sources := 0
+	for _, set := range []bool{*p.arg != "", *p.file != "", *p.fd >= 0, *p.env != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("%w for the %s", errPasswordSources, p.name)
+	}
+	if *p.arg != "" && !insecure {
+		return errInsecurePassword
+	}
+	if *p.fd >= 0 && *p.fd <= 2 {
+		return fmt.Errorf("%w for the %s", errStdioPasswordFD, p.name)
+	}
+	return nil
+}
+
+// read returns the password from the source that is set, or prompts for it on the terminal. With
+// confirm, a prompted password has to be entered twice.
+func (p *passwordFlags) read(confirm bool) ([]byte, error) {
+	var password []byte
+	var err error
+	switch {
+	case *p.arg != "":
+		password = []byte(*p.arg)
+	case *p.file != "":
+		password, err = readPasswordFile(*p.file)
+	case *p.fd >= 0:
+		password, err = readPasswordFD(*p.fd)
+	case *p.env != "":
+		password = []byte(os.Getenv(*p.env))
+	default:
+		password, err = promptPassword(p.name, confirm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the %s: %w", p.name, err)
+	}
+	if len(password) == 0 {
+		return nil, fmt.Errorf("failed to read the %s: %w", p.name, errEmptyPassword)
+	}
+	return password, nil
+}
+
+// readPasswordFile reads the password from the first line of the file at the given path.
+func readPasswordFile(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	return readPasswordLine(file)
+}
+
+// readPasswordFD reads the password from the first line of the given file descriptor and closes it
+// afterwards, so that it is not closed again once a later file has been opened with the same number.
+func readPasswordFD(fd int) ([]byte, error) {
+	file := os.NewFile(uintptr(fd), "password-fd")
+	if file == nil {
+		return nil, fmt.Errorf("invalid file descriptor %d", fd)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	return readPasswordLine(file)
+}
+
+// readPasswordLine reads the password from the first line of r, without the line ending.
+func readPasswordLine(r io.Reader) ([]byte, error) {
+	line, err := bufio.NewReader(r).ReadBytes('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	return bytes.TrimSuffix(line, []byte("\r")), nil
+}
+
+// promptPassword prompts for the password with the given name on the terminal without echoing it.
+// With confirm, the password has to be entered twice.
+func promptPassword(name string, confirm bool) ([]byte, error) {
+	in, out, closeTerminal, err := openTerminal()
+	if err != nil {
+		return nil, err
+	}
+	defer closeTerminal()
+
+	password, err := readTerminal(in, out, "Enter "+name+": ")
+	if err != nil || !confirm {
+		return password, err
+	}
+	confirmation, err := readTerminal(in, out, "Confirm "+name+": ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(password, confirmation) {
+		return nil, errPasswordMismatch
+	}
+	return password, nil
+}
+
+// openTerminal returns the terminal to prompt on. The controlling terminal is preferred, so that
+// stdin can be used for the data. If it cannot be opened, stdin is used with the prompt printed to
+// stderr, as long as stdin is a terminal.
+func openTerminal() (*os.File, io.Writer, func(), error) {
+	if tty, err := os.OpenFile(terminalPath, os.O_RDWR, 0); err == nil {
+		if term.IsTerminal(int(tty.Fd())) {
+			return tty, tty, func() { _ = tty.Close() }, nil
+		}
+		_ = tty.Close()
+	}
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		return os.Stdin, os.Stderr, func() {}, nil
+	}
+	return nil, nil, nil, errNoTerminal
+}
+
+// readTerminal prints the prompt to out and reads a line from the terminal in without echoing it.
+func readTerminal(in *os.File, out io.Writer, prompt string) ([]byte, error) {
+	_, _ = fmt.Fprint(out, prompt)
+	password, err := term.ReadPassword(int(in.Fd()))
+	_, _ = fmt.Fprintln(out)
+	return password, err
+}
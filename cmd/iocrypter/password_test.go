@@ -0,0 +1,217 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPasswordFlags_validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		insecure bool
+		wantErr  error
+	}{
+		{"no source", nil, false, nil},
+		{"password file", []string{"-password-file", "file"}, false, nil},
+		{"password fd", []string{"-password-fd", "3"}, false, nil},
+		{"password env", []string{"-password-env", "ENV"}, false, nil},
+		{"password argument with insecure", []string{"-p", "secret"}, true, nil},
+		{"password argument without insecure", []string{"-p", "secret"}, false, errInsecurePassword},
+		{"file and env", []string{"-password-file", "file", "-password-env", "ENV"}, false, errPasswordSources},
+		{"argument and fd", []string{"-p", "secret", "-password-fd", "3"}, true, errPasswordSources},
+		{"stdin fd", []string{"-password-fd", "0"}, false, errStdioPasswordFD},
+		{"stderr fd", []string{"-password-fd", "2"}, false, errStdioPasswordFD},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			password := parsePasswordFlags(t, tt.args...)
+			err := password.validate(tt.insecure)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected error to be %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestPasswordFlags_read(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("read password from argument", func(t *testing.T) {
+		password := parsePasswordFlags(t, "-p", testPassword)
+		readTestPassword(t, password, testPassword)
+	})
+	t.Run("read password from file", func(t *testing.T) {
+		path := writeTestFile(t, dir, "password", []byte(testPassword+"\nsecond line\n"))
+		password := parsePasswordFlags(t, "-password-file", path)
+		readTestPassword(t, password, testPassword)
+	})
+	t.Run("read password from file with CRLF line ending", func(t *testing.T) {
+		path := writeTestFile(t, dir, "password-crlf", []byte(testPassword+"\r\n"))
+		password := parsePasswordFlags(t, "-password-file", path)
+		readTestPassword(t, password, testPassword)
+	})
+	t.Run("read password from missing file should fail", func(t *testing.T) {
+		password := parsePasswordFlags(t, "-password-file", filepath.Join(dir, "missing"))
+		if _, err := password.read(false); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected error to be %s, got %s", os.ErrNotExist, err)
+		}
+	})
+	t.Run("read password from empty file should fail", func(t *testing.T) {
+		path := writeTestFile(t, dir, "password-empty", []byte("\n"))
+		password := parsePasswordFlags(t, "-password-file", path)
+		if _, err := password.read(false); !errors.Is(err, errEmptyPassword) {
+			t.Errorf("expected error to be %s, got %s", errEmptyPassword, err)
+		}
+	})
+	t.Run("read password from environment variable", func(t *testing.T) {
+		setTestPassword(t)
+		password := parsePasswordFlags(t, "-password-env", testPasswordEnv)
+		readTestPassword(t, password, testPassword)
+	})
+	t.Run("read password from empty environment variable should fail", func(t *testing.T) {
+		t.Setenv(testPasswordEnv, "")
+		password := parsePasswordFlags(t, "-password-env", testPasswordEnv)
+		if _, err := password.read(false); !errors.Is(err, errEmptyPassword) {
+			t.Errorf("expected error to be %s, got %s", errEmptyPassword, err)
+		}
+	})
+	t.Run("read password from unset environment variable should fail", func(t *testing.T) {
+		password := parsePasswordFlags(t, "-password-env", "IOCRYPTER_TEST_UNSET_PASSWORD")
+		if _, err := password.read(false); !errors.Is(err, errEmptyPassword) {
+			t.Errorf("expected error to be %s, got %s", errEmptyPassword, err)
+		}
+	})
+}
+
+func TestReadPasswordLine(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"without line ending", "secret", "secret"},
+		{"with LF", "secret\n", "secret"},
+		{"with CRLF", "secret\r\n", "secret"},
+		{"with multiple lines", "secret\nother\n", "secret"},
+		{"with trailing spaces", "secret \n", "secret "},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			password, err := readPasswordLine(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("failed to read password line: %s", err)
+			}
+			if !bytes.Equal(password, []byte(tt.want)) {
+				t.Errorf("expected password to be %q, got %q", tt.want, password)
+			}
+		})
+	}
+}
+
+func TestPasswordCommandLine(t *testing.T) {
+	plaintext := []byte("This is the plaintext")
+	dir := t.TempDir()
+	path := encryptTestFile(t, dir, "plain.enc", plaintext)
+
+	t.Run("decrypt with password file", func(t *testing.T) {
+		passwordFile := writeTestFile(t, dir, "password", []byte(testPassword+"\n"))
+		code, decrypted, stderr := runCLI(t, nil, "decrypt", "-i", path, "-password-file", passwordFile)
+		if code != exitSuccess {
+			t.Fatalf("expected exit code to be %d, got %d: %s", exitSuccess, code, stderr)
+		}
+		if !bytes.Equal(plaintext, decrypted) {
+			t.Error("expected decrypted data to match the plaintext")
+		}
+	})
+	t.Run("decrypt with password argument and insecure flag", func(t *testing.T) {
+		code, decrypted, stderr := runCLI(t, nil, "decrypt", "-i", path, "-p", testPassword, "-insecure-password")
+		if code != exitSuccess {
+			t.Fatalf("expected exit code to be %d, got %d: %s", exitSuccess, code, stderr)
+		}
+		if !bytes.Equal(plaintext, decrypted) {
+			t.Error("expected decrypted data to match the plaintext")
+		}
+	})
+	t.Run("decrypt with password argument without insecure flag should fail", func(t *testing.T) {
+		code, _, stderr := runCLI(t, nil, "decrypt", "-i", path, "-p", testPassword)
+		if code != exitUsage {
+			t.Errorf("expected exit code to be %d, got %d", exitUsage, code)
+		}
+		if !strings.Contains(stderr, errInsecurePassword.Error()) {
+			t.Errorf("expected error to be %s, got %q", errInsecurePassword, stderr)
+		}
+	})
+	t.Run("decrypt with multiple password sources should fail", func(t *testing.T) {
+		code, _, stderr := runCLI(t, nil, "decrypt", "-i", path, "-password-env", testPasswordEnv,
+			"-password-file", path)
+		if code != exitUsage {
+			t.Errorf("expected exit code to be %d, got %d", exitUsage, code)
+		}
+		if !strings.Contains(stderr, errPasswordSources.Error()) {
+			t.Errorf("expected error to be %s, got %q", errPasswordSources, stderr)
+		}
+	})
+	t.Run("encrypt with password fd 0 and stdin input should fail", func(t *testing.T) {
+		code, stdout, stderr := runCLI(t, plaintext, "encrypt", "-password-fd", "0", "-i", "-")
+		if code != exitUsage {
+			t.Errorf("expected exit code to be %d, got %d", exitUsage, code)
+		}
+		if !strings.Contains(stderr, errStdioPasswordFD.Error()) {
+			t.Errorf("expected error to be %s, got %q", errStdioPasswordFD, stderr)
+		}
+		if len(stdout) != 0 {
+			t.Errorf("expected no output, got %d bytes", len(stdout))
+		}
+	})
+	t.Run("rekey with multiple new password sources should fail", func(t *testing.T) {
+		code, _, _ := runCLI(t, nil, "rekey", "-i", path, "-password-env", testPasswordEnv,
+			"-new-password-env", testPasswordEnv, "-n", "new password", "-insecure-password")
+		if code != exitUsage {
+			t.Errorf("expected exit code to be %d, got %d", exitUsage, code)
+		}
+	})
+	t.Run("encrypt with empty password should fail", func(t *testing.T) {
+		t.Setenv(testPasswordEnv, "")
+		code, stdout, _ := runCLI(t, plaintext, "encrypt", "-password-env", testPasswordEnv)
+		if code != exitFailure {
+			t.Errorf("expected exit code to be %d, got %d", exitFailure, code)
+		}
+		if len(stdout) != 0 {
+			t.Errorf("expected no output on stdout, got %d bytes", len(stdout))
+		}
+	})
+}
+
+// parsePasswordFlags parses args into the flags of a password and returns them.
+func parsePasswordFlags(t *testing.T, args ...string) *passwordFlags {
+	t.Helper()
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	password := addPasswordFlags(flags, "password", "p", "password")
+	if err := flags.Parse(args); err != nil {
+		t.Fatalf("failed to parse flags: %s", err)
+	}
+	return password
+}
+
+// readTestPassword reads the password from its source and checks that it matches want.
+func readTestPassword(t *testing.T, password *passwordFlags, want string) {
+	t.Helper()
+	got, err := password.read(false)
+	if err != nil {
+		t.Fatalf("failed to read password: %s", err)
+	}
+	if string(got) != want {
+		t.Errorf("expected password to be %q, got %q", want, got)
+	}
+}
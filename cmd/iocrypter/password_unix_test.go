@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+//go:build unix
+
+package main
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestPasswordFlags_readFD(t *testing.T) {
+	t.Run("read password from file descriptor", func(t *testing.T) {
+		fd := passwordPipe(t, testPassword+"\nsecond line\n")
+		password := parsePasswordFlags(t, "-password-fd", strconv.Itoa(fd))
+		readTestPassword(t, password, testPassword)
+	})
+	t.Run("read password from file descriptor closes it", func(t *testing.T) {
+		fd := passwordPipe(t, testPassword)
+		password := parsePasswordFlags(t, "-password-fd", strconv.Itoa(fd))
+		readTestPassword(t, password, testPassword)
+		if _, err := syscall.Read(fd, make([]byte, 1)); err == nil {
+			t.Error("expected file descriptor to be closed after reading the password")
+		}
+	})
+}
+
+// passwordPipe returns the file descriptor of a pipe that holds data. The descriptor is a duplicate
+// that is owned by the caller, since reading the password closes it.
+func passwordPipe(t *testing.T, data string) int {
+	t.Helper()
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %s", err)
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+	if _, err = io.WriteString(writer, data); err != nil {
+		t.Fatalf("failed to write password to pipe: %s", err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatalf("failed to close pipe: %s", err)
+	}
+	fd, err := syscall.Dup(int(reader.Fd()))
+	if err != nil {
+		t.Fatalf("failed to duplicate file descriptor: %s", err)
+	}
+	return fd
+}
@@ -0,0 +1,115 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/wneessen/iocrypter"
+)
+
+// runRekey runs the rekey command, which replaces the password key slot of the input and writes the
+// ciphertext with the new key slot to the output. The output defaults to stdout. Since the input needs
+// to be seekable, it has to be a file.
+func (c *cli) runRekey(args []string) int {
+	flags := c.newFlagSet("rekey")
+	inFile := flags.String("i", "", "path to encrypted input file")
+	outFile := flags.String("o", stdioPath, "path to output file, or - for stdout")
+	oldPassword := addPasswordFlags(flags, "password", "p", "password")
+	newPassword := addPasswordFlags(flags, "new password", "n", "new-password")
+	insecure := flags.Bool("insecure-password", false, "allow passing the passwords as arguments")
+	argon2 := addArgon2Flags(flags)
+	quiet := flags.Bool("q", false, "do not print status messages")
+	force := flags.Bool("force", false, "overwrite the output file if it exists")
+	flags.Usage = func() {
+		_, _ = fmt.Fprintln(flags.Output(),
+			"usage: iocrypter rekey -i <input file> [password options] [new password options] [argon2 options] "+
+				"[-o <output file>] [-q] [-force]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitSuccess
+		}
+		return exitUsage
+	}
+	if *inFile == "" || *inFile == stdioPath || flags.NArg() != 0 {
+		flags.Usage()
+		return exitUsage
+	}
+	for _, password := range []*passwordFlags{oldPassword, newPassword} {
+		if err := password.validate(*insecure); err != nil {
+			_, _ = fmt.Fprintln(c.stderr, err)
+			return exitUsage
+		}
+	}
+	opts, err := argon2.options()
+	if err != nil {
+		_, _ = fmt.Fprintln(c.stderr, err)
+		return exitUsage
+	}
+	if err = checkOutput(*inFile, *outFile, *force); err != nil {
+		_, _ = fmt.Fprintln(c.stderr, err)
+		return exitFailure
+	}
+	oldPass, err := oldPassword.read(false)
+	if err != nil {
+		_, _ = fmt.Fprintln(c.stderr, err)
+		return exitFailure
+	}
+	newPass, err := newPassword.read(true)
+	if err != nil {
+		_, _ = fmt.Fprintln(c.stderr, err)
+		return exitFailure
+	}
+
+	startTime := time.Now()
+	if err = c.rekeyFile(*inFile, *outFile, oldPass, newPass, *force, opts...); err != nil {
+		if errors.Is(err, iocrypter.ErrKeyTypeMismatch) {
+			_, _ = fmt.Fprintf(c.stderr, "failed to rekey %s: the file has no password key slot, so its "+
+				"password cannot be changed. Decrypt it and encrypt it again with \"iocrypter encrypt\" "+
+				"instead.\n", *inFile)
+			return exitFailure
+		}
+		_, _ = fmt.Fprintf(c.stderr, "failed to rekey %s: %s\n", *inFile, err)
+		return exitFailure
+	}
+	if !*quiet {
+		_, _ = fmt.Fprintf(c.stderr, "File %s successfully rekeyed to: %s (Time: %s)\n",
+			*inFile, displayName(*outFile, "stdout"), time.Since(startTime).String())
+	}
+	return exitSuccess
+}
+
+// rekeyFile replaces the password key slot of the ciphertext at inPath and writes it to outPath. An
+// existing output file is only replaced if force is set.
+func (c *cli) rekeyFile(inPath, outPath string, oldPassword, newPassword []byte, force bool,
+	opts ...iocrypter.Option,
+) (err error) {
+	input, err := os.Open(inPath)
+	if err != nil {
+		return fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer func() {
+		_ = input.Close()
+	}()
+	output, err := c.createOutput(outPath, nil, force)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			output.abort()
+		}
+	}()
+	if err = iocrypter.Rekey(input, output, oldPassword, newPassword, opts...); err != nil {
+		return err
+	}
+	return output.commit()
+}
@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wneessen/iocrypter"
+)
+
+func TestRekey(t *testing.T) {
+	plaintext := []byte("This is the plaintext")
+	dir := t.TempDir()
+	path := encryptTestFile(t, dir, "plain.enc", plaintext)
+	t.Setenv("IOCRYPTER_TEST_NEW_PASSWORD", "new password")
+
+	t.Run("rekeyed file is decrypted with the new password", func(t *testing.T) {
+		rekeyed := filepath.Join(dir, "rekeyed.enc")
+		args := append([]string{"rekey", "-i", path, "-o", rekeyed, "-password-env", testPasswordEnv,
+			"-new-password-env", "IOCRYPTER_TEST_NEW_PASSWORD"}, testArgon2...)
+		if code, _, stderr := runCLI(t, nil, args...); code != exitSuccess {
+			t.Fatalf("expected exit code to be %d, got %d: %s", exitSuccess, code, stderr)
+		}
+		code, decrypted, stderr := runCLI(t, nil, "decrypt", "-i", rekeyed, "-password-env",
+			"IOCRYPTER_TEST_NEW_PASSWORD")
+		if code != exitSuccess {
+			t.Fatalf("expected exit code to be %d, got %d: %s", exitSuccess, code, stderr)
+		}
+		if !bytes.Equal(plaintext, decrypted) {
+			t.Error("expected decrypted data to match the plaintext")
+		}
+	})
+	t.Run("rekey to stdout", func(t *testing.T) {
+		code, rekeyed, stderr := runCLI(t, nil, "rekey", "-i", path, "-password-env", testPasswordEnv,
+			"-new-password-env", "IOCRYPTER_TEST_NEW_PASSWORD", "-q")
+		if code != exitSuccess || len(rekeyed) == 0 {
+			t.Fatalf("expected rekeyed ciphertext on stdout, got exit code %d and %d bytes: %s", code,
+				len(rekeyed), stderr)
+		}
+	})
+	t.Run("rekey with wrong password should fail", func(t *testing.T) {
+		code, stdout, _ := runCLI(t, nil, "rekey", "-i", path, "-password-env", "IOCRYPTER_TEST_NEW_PASSWORD",
+			"-new-password-env", testPasswordEnv)
+		if code != exitFailure {
+			t.Errorf("expected exit code to be %d, got %d", exitFailure, code)
+		}
+		if len(stdout) != 0 {
+			t.Errorf("expected no output on stdout, got %d bytes", len(stdout))
+		}
+	})
+	t.Run("rekey from stdin should fail", func(t *testing.T) {
+		code, _, _ := runCLI(t, readTestFile(t, path), "rekey", "-i", "-", "-password-env", testPasswordEnv,
+			"-new-password-env", "IOCRYPTER_TEST_NEW_PASSWORD")
+		if code != exitUsage {
+			t.Errorf("expected exit code to be %d, got %d", exitUsage, code)
+		}
+	})
+	t.Run("rekey without input should fail", func(t *testing.T) {
+		code, _, _ := runCLI(t, nil, "rekey", "-password-env", testPasswordEnv, "-new-password-env",
+			"IOCRYPTER_TEST_NEW_PASSWORD")
+		if code != exitUsage {
+			t.Errorf("expected exit code to be %d, got %d", exitUsage, code)
+		}
+	})
+	t.Run("rekey file without password key slot should fail", func(t *testing.T) {
+		encrypter, err := iocrypter.NewEncrypter(bytes.NewReader(plaintext), []byte(testPassword),
+			iocrypter.WithArgon2(8*1024, 1, 1))
+		if err != nil {
+			t.Fatalf("failed to create encrypter: %s", err)
+		}
+		ciphertext, err := io.ReadAll(encrypter)
+		if err != nil {
+			t.Fatalf("failed to encrypt plaintext: %s", err)
+		}
+		input := writeTestFile(t, dir, "no-key-slots.enc", ciphertext)
+		code, _, stderr := runCLI(t, nil, "rekey", "-i", input, "-password-env", testPasswordEnv,
+			"-new-password-env", "IOCRYPTER_TEST_NEW_PASSWORD")
+		if code != exitFailure {
+			t.Errorf("expected exit code to be %d, got %d", exitFailure, code)
+		}
+		if !strings.Contains(stderr, "has no password key slot") {
+			t.Errorf("expected explanation on stderr, got %q", stderr)
+		}
+	})
+}
@@ -0,0 +1,31 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// stdioPath is the path that selects stdin as input or stdout as output, so that the subcommands can
+// be used in shell pipelines.
+const stdioPath = "-"
+
+// openInput opens the file at the given path for reading, or returns stdin if the path is "-". Closing
+// stdin is a no-op, so that the returned reader can always be closed.
+func (c *cli) openInput(path string) (io.ReadCloser, error) {
+	if path == stdioPath {
+		return io.NopCloser(c.stdin), nil
+	}
+	return os.Open(path)
+}
+
+// displayName returns the name of the given path for status messages.
+func displayName(path string, stdio string) string {
+	if path == stdioPath {
+		return stdio
+	}
+	return path
+}
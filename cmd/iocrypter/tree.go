@@ -0,0 +1,264 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// errNotDirectory indicates that the input of a recursive subcommand is not a directory.
+	errNotDirectory = errors.New("input is not a directory")
+
+	// errNestedOutput indicates that the output directory of a recursive subcommand is located within
+	// its input directory.
+	errNestedOutput = errors.New("output directory must not be located within the input directory")
+
+	// errEmptySuffix indicates that the suffix of a recursive subcommand is empty while the files are
+	// processed in place.
+	errEmptySuffix = errors.New("suffix must not be empty when processing files in place")
+)
+
+// treeConfig configures how a recursive subcommand processes a directory tree.
+type treeConfig struct {
+	// verb describes the processing in status messages, e.g. "encrypted".
+	verb string
+
+	// inDir is the directory tree that is processed.
+	inDir string
+
+	// outDir is the directory the output tree is mirrored to. If it is empty, the output files are
+	// written next to the input files.
+	outDir string
+
+	// rename returns the name of the output file for the given input file name, or false if the
+	// file is skipped.
+	rename func(name string) (string, bool)
+
+	// process processes the file at inPath to outPath, preserving the mode and the modification
+	// time of info.
+	process func(inPath, outPath string, info fs.FileInfo) error
+
+	// exitCode returns the exit code for an error returned by process.
+	exitCode func(err error) int
+
+	// workers is the number of files that are processed in parallel.
+	workers int
+
+	// force allows overwriting existing output files.
+	force bool
+
+	// quiet suppresses the status messages for files that have been processed successfully.
+	quiet bool
+}
+
+// treeFile is a regular file of the processed directory tree. If the file or directory at inPath
+// could not be read while walking the tree, err holds the reason and the entry is reported as failed.
+type treeFile struct {
+	inPath  string
+	outPath string
+	info    fs.FileInfo
+	err     error
+}
+
+// processTree processes all regular files of the directory tree of the given configuration with a pool
+// of workers and prints the result of each file as well as a summary to stderr. Symbolic links and
+// other special files are skipped. Files and directories that cannot be read are reported as failed,
+// while the rest of the tree is still processed. It returns a non-zero exit code if any of them failed.
+func (c *cli) processTree(config treeConfig) int {
+	files, dirs, skipped := walkTree(config)
+
+	var mutex sync.Mutex
+	status, failed := exitSuccess, 0
+	jobs := make(chan treeFile)
+	wg := sync.WaitGroup{}
+	for range max(config.workers, 1) {
+		wg.Go(func() {
+			for file := range jobs {
+				startTime := time.Now()
+				err := file.err
+				if err == nil {
+					err = checkOutput(file.inPath, file.outPath, config.force)
+				}
+				if err == nil {
+					err = config.process(file.inPath, file.outPath, file.info)
+				}
+
+				mutex.Lock()
+				switch {
+				case err != nil:
+					_, _ = fmt.Fprintf(c.stderr, "%s: FAILED: %s\n", file.inPath, err)
+					status = max(status, config.exitCode(err))
+					failed++
+				case !config.quiet:
+					_, _ = fmt.Fprintf(c.stderr, "%s: %s to %s (Time: %s)\n", file.inPath, config.verb,
+						file.outPath, time.Since(startTime).String())
+				}
+				mutex.Unlock()
+			}
+		})
+	}
+	for _, file := range files {
+		jobs <- file
+	}
+	close(jobs)
+	wg.Wait()
+
+	// Creating the output files changed the modification times of the mirrored directories, so their
+	// modes and modification times are applied afterwards, starting with the deepest directory
+	for _, dir := range slices.Backward(dirs) {
+		if err := preserveAttributes(dir.outPath, dir.info); err != nil {
+			_, _ = fmt.Fprintf(c.stderr, "failed to preserve attributes of %s: %s\n", dir.outPath, err)
+			status = max(status, exitFailure)
+		}
+	}
+
+	_, _ = fmt.Fprintf(c.stderr, "%d files %s, %d failed, %d skipped\n", len(files)-failed, config.verb, failed,
+		skipped)
+	return status
+}
+
+// checkTree validates the arguments of a recursive subcommand before any file is processed. If
+// outDir is empty, the files are processed in place, which requires a suffix.
+func checkTree(inDir, outDir, suffix string) error {
+	if inDir == stdioPath {
+		return errNotDirectory
+	}
+	info, err := os.Stat(inDir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errNotDirectory
+	}
+	if outDir == "" {
+		if suffix == "" {
+			return errEmptySuffix
+		}
+		return nil
+	}
+	return checkNestedOutput(inDir, outDir)
+}
+
+// walkTree returns the regular files of the directory tree of the given configuration along with
+// their output paths, and the number of skipped files. If the tree is mirrored, the output
+// directories are created and returned as well. Entries that cannot be read are returned as files
+// with their error set, and the walk continues with the next entry, skipping directories that cannot
+// be read. If the input directory is a symbolic link, the directory it points to is walked. The
+// configuration has to be validated with checkTree.
+func walkTree(config treeConfig) ([]treeFile, []treeFile, int) {
+	// filepath.WalkDir does not follow a symbolic link as root, but would report it as skipped file
+	root, err := filepath.EvalSymlinks(config.inDir)
+	if err != nil {
+		return []treeFile{{inPath: config.inDir, err: err}}, nil, 0
+	}
+
+	var files, dirs []treeFile
+	skipped := 0
+	_ = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		// failed records the entry as failed file and continues the walk without it
+		failed := func(err error) error {
+			files = append(files, treeFile{inPath: path, err: err})
+			if entry != nil && entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if err != nil {
+			return failed(err)
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return failed(err)
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return failed(err)
+		}
+		if entry.IsDir() {
+			if config.outDir == "" {
+				return nil
+			}
+			outPath := filepath.Join(config.outDir, rel)
+			if err = os.MkdirAll(outPath, info.Mode().Perm()|0o700); err != nil {
+				return failed(fmt.Errorf("failed to create output directory: %w", err))
+			}
+			dirs = append(dirs, treeFile{inPath: path, outPath: outPath, info: info})
+			return nil
+		}
+		name, ok := config.rename(entry.Name())
+		if !entry.Type().IsRegular() || !ok {
+			skipped++
+			return nil
+		}
+		outPath := filepath.Join(filepath.Dir(path), name)
+		if config.outDir != "" {
+			outPath = filepath.Join(config.outDir, filepath.Dir(rel), name)
+		}
+		files = append(files, treeFile{inPath: path, outPath: outPath, info: info})
+		return nil
+	})
+	return files, dirs, skipped
+}
+
+// preserveAttributes applies the permissions and the modification time of info to the file at path.
+func preserveAttributes(path string, info fs.FileInfo) error {
+	if err := os.Chmod(path, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(path, time.Time{}, info.ModTime())
+}
+
+// checkNestedOutput returns errNestedOutput if the output directory is the input directory or is
+// located within it, since the output files would be processed again.
+func checkNestedOutput(inDir, outDir string) error {
+	absIn, err := filepath.Abs(inDir)
+	if err != nil {
+		return err
+	}
+	absOut, err := filepath.Abs(outDir)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(absIn, absOut)
+	if err != nil {
+		return err
+	}
+	if rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))) {
+		return errNestedOutput
+	}
+	return nil
+}
+
+// appendSuffix returns a rename function for treeConfig that appends the suffix to the file name.
+// Files that already carry the suffix are skipped, so that they are not processed twice.
+func appendSuffix(suffix string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		if suffix != "" && strings.HasSuffix(name, suffix) {
+			return "", false
+		}
+		return name + suffix, true
+	}
+}
+
+// trimSuffix returns a rename function for treeConfig that removes the suffix from the file name.
+// Files without the suffix are skipped.
+func trimSuffix(suffix string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		if suffix == "" {
+			return name, true
+		}
+		trimmed, ok := strings.CutSuffix(name, suffix)
+		return trimmed, ok && trimmed != ""
+	}
+}
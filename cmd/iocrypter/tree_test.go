@@ -0,0 +1,419 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTree holds the files of the directory tree the recursive tests are run against.
+var testTree = map[string][]byte{
+	"a.txt":              []byte("file a"),
+	"sub/b.txt":          []byte("file b"),
+	"sub/deeper/c.txt":   []byte("file c"),
+	"sub/deeper/d.bin":   bytes.Repeat([]byte("file d"), 10000),
+	"other/e.txt":        []byte("file e"),
+	"other/f.txt.backup": []byte("file f"),
+}
+
+func TestCheckNestedOutput(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		inDir   string
+		outDir  string
+		wantErr error
+	}{
+		{"sibling directory", filepath.Join(dir, "in"), filepath.Join(dir, "out"), nil},
+		{"parent directory", filepath.Join(dir, "in"), dir, nil},
+		{"sibling with common prefix", filepath.Join(dir, "in"), filepath.Join(dir, "input"), nil},
+		{"sibling starting with dots", filepath.Join(dir, "in"), filepath.Join(dir, "..out"), nil},
+		{"same directory", filepath.Join(dir, "in"), filepath.Join(dir, "in"), errNestedOutput},
+		{"same directory by another path", filepath.Join(dir, "in"), filepath.Join(dir, "in", "."),
+			errNestedOutput},
+		{"subdirectory", filepath.Join(dir, "in"), filepath.Join(dir, "in", "out"), errNestedOutput},
+		{"deep subdirectory", filepath.Join(dir, "in"), filepath.Join(dir, "in", "a", "b"), errNestedOutput},
+		{"subdirectory starting with dots", filepath.Join(dir, "in"), filepath.Join(dir, "in", "..out"),
+			errNestedOutput},
+		{"relative subdirectory", ".", "out", errNestedOutput},
+		{"relative sibling", ".", filepath.Join("..", "out"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkNestedOutput(tt.inDir, tt.outDir)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected error to be %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestCheckTree(t *testing.T) {
+	dir := t.TempDir()
+	file := writeTestFile(t, dir, "file", []byte("file"))
+	tests := []struct {
+		name    string
+		inDir   string
+		outDir  string
+		suffix  string
+		wantErr error
+	}{
+		{"in place", dir, "", ".enc", nil},
+		{"mirrored", dir, filepath.Join(t.TempDir(), "out"), "", nil},
+		{"stdin as input", stdioPath, "", ".enc", errNotDirectory},
+		{"file as input", file, "", ".enc", errNotDirectory},
+		{"missing input", filepath.Join(dir, "missing"), "", ".enc", fs.ErrNotExist},
+		{"in place without suffix", dir, "", "", errEmptySuffix},
+		{"nested output", dir, filepath.Join(dir, "out"), ".enc", errNestedOutput},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkTree(tt.inDir, tt.outDir, tt.suffix)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected error to be %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestAppendSuffix(t *testing.T) {
+	tests := []struct {
+		name   string
+		suffix string
+		file   string
+		want   string
+		wantOK bool
+	}{
+		{"append suffix", ".enc", "file.txt", "file.txt.enc", true},
+		{"skip file with suffix", ".enc", "file.txt.enc", "", false},
+		{"skip suffix only", ".enc", ".enc", "", false},
+		{"append suffix to similar name", ".enc", "file.encrypted", "file.encrypted.enc", true},
+		{"empty suffix", "", "file.txt", "file.txt", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := appendSuffix(tt.suffix)(tt.file)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("expected %q, %t, got %q, %t", tt.want, tt.wantOK, got, ok)
+			}
+		})
+	}
+}
+
+func TestTrimSuffix(t *testing.T) {
+	tests := []struct {
+		name   string
+		suffix string
+		file   string
+		want   string
+		wantOK bool
+	}{
+		{"trim suffix", ".enc", "file.txt.enc", "file.txt", true},
+		{"skip file without suffix", ".enc", "file.txt", "file.txt", false},
+		{"skip suffix only", ".enc", ".enc", "", false},
+		{"trim suffix once", ".enc", "file.enc.enc", "file.enc", true},
+		{"empty suffix", "", "file.txt", "file.txt", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := trimSuffix(tt.suffix)(tt.file)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("expected %q, %t, got %q, %t", tt.want, tt.wantOK, got, ok)
+			}
+		})
+	}
+}
+
+func TestWalkTree(t *testing.T) {
+	t.Run("in place layout", func(t *testing.T) {
+		dir := writeTestTree(t)
+		files, dirs, skipped := walkTree(treeConfig{inDir: dir, rename: appendSuffix(".backup")})
+		if len(dirs) != 0 {
+			t.Errorf("expected no directories to be created in place, got %d", len(dirs))
+		}
+		if skipped != 1 {
+			t.Errorf("expected 1 skipped file, got %d", skipped)
+		}
+		if len(files) != len(testTree)-1 {
+			t.Fatalf("expected %d files, got %d", len(testTree)-1, len(files))
+		}
+		for _, file := range files {
+			if file.err != nil {
+				t.Errorf("expected no error for %s, got %s", file.inPath, file.err)
+			}
+			if file.outPath != file.inPath+".backup" {
+				t.Errorf("expected output path next to %s, got %s", file.inPath, file.outPath)
+			}
+		}
+	})
+	t.Run("mirrored layout", func(t *testing.T) {
+		dir := writeTestTree(t)
+		outDir := filepath.Join(t.TempDir(), "out")
+		files, dirs, _ := walkTree(treeConfig{inDir: dir, outDir: outDir, rename: appendSuffix(".enc")})
+		if len(dirs) != 4 {
+			t.Errorf("expected 4 mirrored directories, got %d", len(dirs))
+		}
+		for _, dir := range dirs {
+			if info, err := os.Stat(dir.outPath); err != nil || !info.IsDir() {
+				t.Errorf("expected output directory %s to be created, got %v", dir.outPath, err)
+			}
+		}
+		want := filepath.Join(outDir, "sub", "deeper", "c.txt.enc")
+		if !slices.ContainsFunc(files, func(file treeFile) bool { return file.outPath == want }) {
+			t.Errorf("expected output path %s in mirrored tree", want)
+		}
+	})
+	t.Run("symlinked input directory is walked", func(t *testing.T) {
+		dir := writeTestTree(t)
+		link := filepath.Join(t.TempDir(), "link")
+		if err := os.Symlink(dir, link); err != nil {
+			t.Skipf("creating symbolic links is not supported: %s", err)
+		}
+		files, _, skipped := walkTree(treeConfig{inDir: link, rename: appendSuffix(".backup")})
+		if skipped != 1 {
+			t.Errorf("expected 1 skipped file, got %d", skipped)
+		}
+		if len(files) != len(testTree)-1 {
+			t.Errorf("expected %d files, got %d", len(testTree)-1, len(files))
+		}
+	})
+	t.Run("missing input directory is reported as failed", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "missing")
+		files, _, _ := walkTree(treeConfig{inDir: dir, rename: appendSuffix(".enc")})
+		if len(files) != 1 || !errors.Is(files[0].err, fs.ErrNotExist) {
+			t.Errorf("expected the input directory to be reported as failed, got %+v", files)
+		}
+	})
+	t.Run("output directory that cannot be created is reported as failed", func(t *testing.T) {
+		dir := writeTestTree(t)
+		outDir := t.TempDir()
+		writeTestFile(t, outDir, "sub", []byte("blocks the sub directory"))
+		files, _, _ := walkTree(treeConfig{inDir: dir, outDir: outDir, rename: appendSuffix(".enc")})
+		var failed []string
+		for _, file := range files {
+			if file.err != nil {
+				failed = append(failed, file.inPath)
+			}
+		}
+		if len(failed) != 1 || failed[0] != filepath.Join(dir, "sub") {
+			t.Errorf("expected the sub directory to be reported as failed, got %v", failed)
+		}
+		if len(files) != 4 {
+			t.Errorf("expected the files outside of the sub directory to be walked, got %d entries", len(files))
+		}
+	})
+}
+
+func TestTree(t *testing.T) {
+	setTestPassword(t)
+	encryptArgs := append([]string{"encrypt", "-password-env", testPasswordEnv, "-r", "-q", "-j", "2"},
+		testArgon2...)
+	decryptArgs := []string{"decrypt", "-password-env", testPasswordEnv, "-r", "-q", "-j", "2"}
+
+	t.Run("encrypt and decrypt mirrored tree", func(t *testing.T) {
+		dir := writeTestTree(t)
+		modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		if err := os.Chmod(filepath.Join(dir, "a.txt"), 0o640); err != nil {
+			t.Fatalf("failed to change mode: %s", err)
+		}
+		if err := os.Chtimes(filepath.Join(dir, "a.txt"), time.Time{}, modTime); err != nil {
+			t.Fatalf("failed to change modification time: %s", err)
+		}
+		if err := os.Chtimes(filepath.Join(dir, "sub"), time.Time{}, modTime); err != nil {
+			t.Fatalf("failed to change modification time: %s", err)
+		}
+		encrypted := filepath.Join(t.TempDir(), "encrypted")
+		decrypted := filepath.Join(t.TempDir(), "decrypted")
+		code, _, stderr := runCLI(t, nil, append(encryptArgs, "-i", dir, "-o", encrypted)...)
+		if code != exitSuccess {
+			t.Fatalf("expected exit code to be %d, got %d: %s", exitSuccess, code, stderr)
+		}
+		if !strings.Contains(stderr, "6 files encrypted, 0 failed, 0 skipped") {
+			t.Errorf("expected summary on stderr, got %q", stderr)
+		}
+		code, _, stderr = runCLI(t, nil, append(decryptArgs, "-i", encrypted, "-o", decrypted)...)
+		if code != exitSuccess {
+			t.Fatalf("expected exit code to be %d, got %d: %s", exitSuccess, code, stderr)
+		}
+		for name, data := range testTree {
+			if got := readTestFile(t, filepath.Join(decrypted, name)); !bytes.Equal(got, data) {
+				t.Errorf("expected decrypted %s to match the original", name)
+			}
+		}
+		for _, path := range []string{filepath.Join(encrypted, "a.txt.enc"), filepath.Join(decrypted, "a.txt"),
+			filepath.Join(encrypted, "sub"), filepath.Join(decrypted, "sub")} {
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("failed to stat %s: %s", path, err)
+			}
+			if !info.ModTime().Equal(modTime) {
+				t.Errorf("expected modification time of %s to be %s, got %s", path, modTime, info.ModTime())
+			}
+			if runtime.GOOS != "windows" && !info.IsDir() && info.Mode().Perm() != 0o640 {
+				t.Errorf("expected mode of %s to be %s, got %s", path, fs.FileMode(0o640), info.Mode().Perm())
+			}
+		}
+	})
+	t.Run("encrypt and decrypt tree in place", func(t *testing.T) {
+		dir := writeTestTree(t)
+		code, _, stderr := runCLI(t, nil, append(encryptArgs, "-i", dir, "-suffix", ".backup")...)
+		if code != exitSuccess {
+			t.Fatalf("expected exit code to be %d, got %d: %s", exitSuccess, code, stderr)
+		}
+		if !strings.Contains(stderr, "5 files encrypted, 0 failed, 1 skipped") {
+			t.Errorf("expected summary on stderr, got %q", stderr)
+		}
+		for name := range testTree {
+			if strings.HasSuffix(name, ".backup") {
+				continue
+			}
+			if err := os.Remove(filepath.Join(dir, name)); err != nil {
+				t.Fatalf("failed to remove original file: %s", err)
+			}
+		}
+		code, _, stderr = runCLI(t, nil, append(decryptArgs, "-i", dir, "-suffix", ".backup")...)
+		if code != exitUnauthentic {
+			t.Errorf("expected exit code to be %d, got %d", exitUnauthentic, code)
+		}
+		if !strings.Contains(stderr, "5 files decrypted, 1 failed, 0 skipped") {
+			t.Errorf("expected the unencrypted backup file to fail, got %q", stderr)
+		}
+		for name, data := range testTree {
+			if got := readTestFile(t, filepath.Join(dir, name)); !bytes.Equal(got, data) {
+				t.Errorf("expected decrypted %s to match the original", name)
+			}
+		}
+	})
+	t.Run("encrypt tree through symlinked input directory", func(t *testing.T) {
+		dir := writeTestTree(t)
+		link := filepath.Join(t.TempDir(), "link")
+		if err := os.Symlink(dir, link); err != nil {
+			t.Skipf("creating symbolic links is not supported: %s", err)
+		}
+		encrypted := filepath.Join(t.TempDir(), "encrypted")
+		code, _, stderr := runCLI(t, nil, append(encryptArgs, "-i", link, "-o", encrypted)...)
+		if code != exitSuccess {
+			t.Fatalf("expected exit code to be %d, got %d: %s", exitSuccess, code, stderr)
+		}
+		if !strings.Contains(stderr, "6 files encrypted, 0 failed, 0 skipped") {
+			t.Errorf("expected summary on stderr, got %q", stderr)
+		}
+		if _, err := os.Stat(filepath.Join(encrypted, "sub", "deeper", "c.txt.enc")); err != nil {
+			t.Errorf("expected encrypted file in mirrored tree: %s", err)
+		}
+	})
+	t.Run("existing output files fail without force", func(t *testing.T) {
+		dir := writeTestTree(t)
+		if code, _, stderr := runCLI(t, nil, append(encryptArgs, "-i", dir)...); code != exitSuccess {
+			t.Fatalf("expected exit code to be %d, got %d: %s", exitSuccess, code, stderr)
+		}
+		writeTestFile(t, dir, "new.txt", []byte("new file"))
+		code, _, stderr := runCLI(t, nil, append(encryptArgs, "-i", dir)...)
+		if code != exitFailure {
+			t.Errorf("expected exit code to be %d, got %d", exitFailure, code)
+		}
+		if !strings.Contains(stderr, "1 files encrypted, 6 failed, 6 skipped") {
+			t.Errorf("expected the existing output files to fail, got %q", stderr)
+		}
+		if !strings.Contains(stderr, errOutputExists.Error()) {
+			t.Errorf("expected error to be %s, got %q", errOutputExists, stderr)
+		}
+		code, _, _ = runCLI(t, nil, append(encryptArgs, "-i", dir, "-force")...)
+		if code != exitSuccess {
+			t.Errorf("expected exit code with -force to be %d, got %d", exitSuccess, code)
+		}
+	})
+	t.Run("tampered file fails decryption while the others are decrypted", func(t *testing.T) {
+		dir := writeTestTree(t)
+		encrypted := filepath.Join(t.TempDir(), "encrypted")
+		decrypted := filepath.Join(t.TempDir(), "decrypted")
+		if code, _, stderr := runCLI(t, nil, append(encryptArgs, "-i", dir, "-o", encrypted)...); code != exitSuccess {
+			t.Fatalf("expected exit code to be %d, got %d: %s", exitSuccess, code, stderr)
+		}
+		tamperedPath := filepath.Join(encrypted, "sub", "b.txt.enc")
+		tampered := readTestFile(t, tamperedPath)
+		tampered[len(tampered)-1] ^= 0xff
+		writeTestFile(t, encrypted, filepath.Join("sub", "b.txt.enc"), tampered)
+		code, _, stderr := runCLI(t, nil, append(decryptArgs, "-i", encrypted, "-o", decrypted)...)
+		if code != exitUnauthentic {
+			t.Errorf("expected exit code to be %d, got %d", exitUnauthentic, code)
+		}
+		if !strings.Contains(stderr, tamperedPath+": FAILED") {
+			t.Errorf("expected the tampered file to be reported as failed, got %q", stderr)
+		}
+		if _, err := os.Stat(filepath.Join(decrypted, "sub", "b.txt")); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected no output for the tampered file, got %v", err)
+		}
+		checkNoTempFiles(t, filepath.Join(decrypted, "sub"))
+		if got := readTestFile(t, filepath.Join(decrypted, "sub", "deeper", "c.txt")); !bytes.Equal(got,
+			testTree["sub/deeper/c.txt"]) {
+			t.Error("expected the other files to be decrypted")
+		}
+	})
+	t.Run("unreadable directory fails while the others are encrypted", func(t *testing.T) {
+		if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+			t.Skip("directory permissions are not enforced")
+		}
+		dir := writeTestTree(t)
+		unreadable := filepath.Join(dir, "sub", "deeper")
+		if err := os.Chmod(unreadable, 0o000); err != nil {
+			t.Fatalf("failed to change mode: %s", err)
+		}
+		t.Cleanup(func() {
+			_ = os.Chmod(unreadable, 0o700)
+		})
+		code, _, stderr := runCLI(t, nil, append(encryptArgs, "-i", dir)...)
+		if code != exitFailure {
+			t.Errorf("expected exit code to be %d, got %d", exitFailure, code)
+		}
+		if !strings.Contains(stderr, unreadable+": FAILED") {
+			t.Errorf("expected the unreadable directory to be reported as failed, got %q", stderr)
+		}
+		if !strings.Contains(stderr, "4 files encrypted, 1 failed, 0 skipped") {
+			t.Errorf("expected the other files to be encrypted, got %q", stderr)
+		}
+	})
+	t.Run("output directory that cannot be created fails while the others are encrypted", func(t *testing.T) {
+		dir := writeTestTree(t)
+		outDir := t.TempDir()
+		writeTestFile(t, outDir, "other", []byte("blocks the other directory"))
+		code, _, stderr := runCLI(t, nil, append(encryptArgs, "-i", dir, "-o", outDir)...)
+		if code != exitFailure {
+			t.Errorf("expected exit code to be %d, got %d", exitFailure, code)
+		}
+		if !strings.Contains(stderr, filepath.Join(dir, "other")+": FAILED") {
+			t.Errorf("expected the other directory to be reported as failed, got %q", stderr)
+		}
+		if !strings.Contains(stderr, "4 files encrypted, 1 failed, 0 skipped") {
+			t.Errorf("expected the other files to be encrypted, got %q", stderr)
+		}
+	})
+	t.Run("recursive mode with nested output should fail", func(t *testing.T) {
+		dir := writeTestTree(t)
+		code, _, _ := runCLI(t, nil, append(encryptArgs, "-i", dir, "-o", filepath.Join(dir, "out"))...)
+		if code != exitUsage {
+			t.Errorf("expected exit code to be %d, got %d", exitUsage, code)
+		}
+	})
+}
+
+// writeTestTree writes the files of testTree to a temporary directory and returns its path.
+func writeTestTree(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range testTree {
+		writeTestFile(t, dir, filepath.FromSlash(name), data)
+	}
+	return dir
+}
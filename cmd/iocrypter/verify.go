@@ -0,0 +1,86 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/wneessen/iocrypter"
+)
+
+// runVerify runs the verify command, which authenticates the given files with the password without
+// writing any plaintext. It returns exitUnauthentic if any of the files is not authentic.
+func (c *cli) runVerify(args []string) int {
+	flags := c.newFlagSet("verify")
+	password := addPasswordFlags(flags, "password", "p", "password")
+	insecure := flags.Bool("insecure-password", false, "allow passing the password as argument")
+	concurrency := flags.Int("c", 1, "number of segments that are authenticated in parallel")
+	flags.Usage = func() {
+		_, _ = fmt.Fprintln(flags.Output(), "usage: iocrypter verify [password options] [-c <workers>] <file>...")
+		_, _ = fmt.Fprintln(flags.Output(), "A file of - verifies stdin.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitSuccess
+		}
+		return exitUsage
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+	if err := password.validate(*insecure); err != nil {
+		_, _ = fmt.Fprintln(c.stderr, err)
+		return exitUsage
+	}
+	pass, err := password.read(false)
+	if err != nil {
+		_, _ = fmt.Fprintln(c.stderr, err)
+		return exitFailure
+	}
+
+	status := exitSuccess
+	for _, path := range flags.Args() {
+		startTime := time.Now()
+		err := c.verifyFile(path, pass, iocrypter.WithConcurrency(*concurrency))
+		switch {
+		case err == nil:
+			_, _ = fmt.Fprintf(c.stdout, "%s: OK (Time: %s)\n", path, time.Since(startTime).String())
+		case isUnauthentic(err):
+			_, _ = fmt.Fprintf(c.stdout, "%s: FAILED\n", path)
+			_, _ = fmt.Fprintf(c.stderr, "failed to verify %s: %s\n", path, err)
+			status = max(status, exitUnauthentic)
+		default:
+			_, _ = fmt.Fprintf(c.stderr, "failed to verify %s: %s\n", path, err)
+			status = max(status, exitFailure)
+		}
+	}
+	return status
+}
+
+// verifyFile authenticates the given file, or stdin if the path is "-", with the password.
+func (c *cli) verifyFile(path string, password []byte, opts ...iocrypter.Option) error {
+	file, err := c.openInput(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	return iocrypter.Verify(file, password, opts...)
+}
+
+// isUnauthentic reports whether the given error indicates that the ciphertext is not authentic, as
+// opposed to an error that prevented the verification, like an unreadable file. An incorrect password
+// cannot be told apart from modified ciphertext, so it is reported as not authentic as well.
+func isUnauthentic(err error) bool {
+	return errors.Is(err, iocrypter.ErrFailedAuthentication) || errors.Is(err, iocrypter.ErrMissingData) ||
+		errors.Is(err, iocrypter.ErrNoMatchingKeySlot) || errors.Is(err, io.ErrUnexpectedEOF)
+}
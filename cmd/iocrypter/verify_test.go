@@ -0,0 +1,67 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	path := encryptTestFile(t, dir, "plain.enc", []byte("This is the plaintext"))
+	ciphertext := readTestFile(t, path)
+	tampered := append([]byte(nil), ciphertext...)
+	tampered[len(tampered)-1] ^= 0xff
+	tamperedPath := writeTestFile(t, dir, "tampered.enc", tampered)
+
+	t.Run("verify authentic file", func(t *testing.T) {
+		code, stdout, stderr := runCLI(t, nil, "verify", "-password-env", testPasswordEnv, path)
+		if code != exitSuccess {
+			t.Fatalf("expected exit code to be %d, got %d: %s", exitSuccess, code, stderr)
+		}
+		if !strings.HasPrefix(string(stdout), path+": OK") {
+			t.Errorf("expected OK on stdout, got %q", stdout)
+		}
+	})
+	t.Run("verify stdin", func(t *testing.T) {
+		code, stdout, _ := runCLI(t, ciphertext, "verify", "-password-env", testPasswordEnv, "-")
+		if code != exitSuccess || !strings.HasPrefix(string(stdout), "-: OK") {
+			t.Errorf("expected stdin to be verified, got exit code %d and %q", code, stdout)
+		}
+	})
+	t.Run("verify tampered file should fail", func(t *testing.T) {
+		code, stdout, _ := runCLI(t, nil, "verify", "-password-env", testPasswordEnv, path, tamperedPath)
+		if code != exitUnauthentic {
+			t.Errorf("expected exit code to be %d, got %d", exitUnauthentic, code)
+		}
+		if !strings.Contains(string(stdout), path+": OK") || !strings.Contains(string(stdout),
+			tamperedPath+": FAILED") {
+			t.Errorf("expected a result for each file on stdout, got %q", stdout)
+		}
+	})
+	t.Run("verify with wrong password should fail", func(t *testing.T) {
+		t.Setenv(testPasswordEnv, "wrong password")
+		if code, _, _ := runCLI(t, nil, "verify", "-password-env", testPasswordEnv, path); code != exitUnauthentic {
+			t.Errorf("expected exit code to be %d, got %d", exitUnauthentic, code)
+		}
+	})
+	t.Run("verify missing file should fail", func(t *testing.T) {
+		missing := filepath.Join(dir, "missing")
+		code, _, stderr := runCLI(t, nil, "verify", "-password-env", testPasswordEnv, missing)
+		if code != exitFailure {
+			t.Errorf("expected exit code to be %d, got %d", exitFailure, code)
+		}
+		if !strings.Contains(stderr, "failed to verify "+missing) {
+			t.Errorf("expected error on stderr, got %q", stderr)
+		}
+	})
+	t.Run("verify without file should fail", func(t *testing.T) {
+		if code, _, _ := runCLI(t, nil, "verify", "-password-env", testPasswordEnv); code != exitUsage {
+			t.Errorf("expected exit code to be %d, got %d", exitUsage, code)
+		}
+	})
+}
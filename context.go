@@ -0,0 +1,109 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"context"
+	"io"
+)
+
+// NewEncrypterContext returns an io.Reader like NewEncrypter, that stops with the error of the given
+// context once it is done. The key derivation is bound to the context as well: if the context is done
+// before the keys have been derived, NewEncrypterContext returns its error right away, while the key
+// derivation, which cannot be interrupted, finishes in the background.
+func NewEncrypterContext(ctx context.Context, r io.Reader, pass []byte, opts ...Option) (io.Reader, error) {
+	if len(pass) == 0 {
+		return nil, ErrPassPhraseEmpty
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	o, err := newOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	encrypter, err := newEncrypter(newContextReader(ctx, r), passwordHeader(o.kdf, o.suite),
+		passwordKeys(pass).withContext(ctx), o)
+	if err != nil {
+		return nil, err
+	}
+	return newContextReader(ctx, encrypter), nil
+}
+
+// NewDecrypterContext returns an io.ReadCloser like NewDecrypter, that stops with the error of the given
+// context once it is done. The key derivation and the spooling of ciphertext in the legacy format, which
+// both take place before NewDecrypterContext returns, are aborted with the error of the context as well.
+// The key derivation cannot be interrupted, so it finishes in the background.
+func NewDecrypterContext(ctx context.Context, r io.Reader, pass []byte, opts ...Option) (io.ReadCloser, error) {
+	if len(pass) == 0 {
+		return nil, ErrPassPhraseEmpty
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	o, err := newOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	decrypter, err := newDecrypter(newContextReader(ctx, r), passwordKeys(pass).withContext(ctx), o)
+	if err != nil {
+		return nil, err
+	}
+	return newContextReader(ctx, decrypter), nil
+}
+
+// contextReader is an io.ReadCloser that returns the error of its context, once it is done, instead of
+// reading from the underlying io.Reader.
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+// newContextReader returns a new contextReader for the given context and io.Reader.
+func newContextReader(ctx context.Context, r io.Reader) *contextReader {
+	return &contextReader{ctx: ctx, reader: r}
+}
+
+// Read satisfies the io.Reader interface for the contextReader type.
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.reader.Read(p)
+}
+
+// Close satisfies the io.Closer interface for the contextReader type. It closes the underlying
+// io.Reader, if it implements the io.Closer interface.
+func (c *contextReader) Close() error {
+	if closer, ok := c.reader.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// withContext returns a keyFunc that returns the error of the given context once it is done, without
+// waiting for the keyFunc to return.
+func (k keyFunc) withContext(ctx context.Context) keyFunc {
+	if ctx.Done() == nil {
+		return k
+	}
+	return func(hdr *header) ([]byte, []byte, error) {
+		type derivedKeys struct {
+			encKey, hmacKey []byte
+			err             error
+		}
+		result := make(chan derivedKeys, 1)
+		go func() {
+			encKey, hmacKey, err := k(hdr)
+			result <- derivedKeys{encKey: encKey, hmacKey: hmacKey, err: err}
+		}()
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case keys := <-result:
+			return keys.encKey, keys.hmacKey, keys.err
+		}
+	}
+}
@@ -0,0 +1,184 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestNewEncrypterContext(t *testing.T) {
+	plaintext := make([]byte, 4*defaultSegmentSize)
+
+	t.Run("encryption with context succeeds", func(t *testing.T) {
+		encrypter, err := NewEncrypterContext(context.Background(), bytes.NewReader(plaintext), testPassword)
+		if err != nil {
+			t.Fatalf("failed to create encrypter: %s", err)
+		}
+		ciphertext, err := io.ReadAll(encrypter)
+		if err != nil {
+			t.Fatalf("failed to encrypt plaintext: %s", err)
+		}
+		decrypter, err := NewDecrypter(bytes.NewReader(ciphertext), testPassword)
+		if err != nil {
+			t.Fatalf("failed to create decrypter: %s", err)
+		}
+		decrypted, err := io.ReadAll(decrypter)
+		if err != nil {
+			t.Fatalf("failed to decrypt ciphertext: %s", err)
+		}
+		if !bytes.Equal(plaintext, decrypted) {
+			t.Error("plaintext and decrypted data do not match")
+		}
+	})
+	t.Run("encryption with cancelled context should fail", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := NewEncrypterContext(ctx, bytes.NewReader(plaintext), testPassword)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected error to be %s, got %s", context.Canceled, err)
+		}
+	})
+	t.Run("reading after cancellation should fail", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		encrypter, err := NewEncrypterContext(ctx, bytes.NewReader(plaintext), testPassword)
+		if err != nil {
+			t.Fatalf("failed to create encrypter: %s", err)
+		}
+		if _, err = encrypter.Read(make([]byte, 10)); err != nil {
+			t.Fatalf("failed to read ciphertext: %s", err)
+		}
+		cancel()
+		if _, err = encrypter.Read(make([]byte, 10)); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected error to be %s, got %s", context.Canceled, err)
+		}
+	})
+	t.Run("key derivation is bound to the context", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		defer cancel()
+		release := make(chan struct{})
+		defer close(release)
+		blocking := keyFunc(func(*header) ([]byte, []byte, error) {
+			<-release
+			return nil, nil, nil
+		})
+		if _, _, err := blocking.withContext(ctx)(&header{}); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected error to be %s, got %s", context.DeadlineExceeded, err)
+		}
+	})
+	t.Run("encryption with empty password should fail", func(t *testing.T) {
+		_, err := NewEncrypterContext(context.Background(), bytes.NewReader(plaintext), nil)
+		if !errors.Is(err, ErrPassPhraseEmpty) {
+			t.Errorf("expected error to be %s, got %s", ErrPassPhraseEmpty, err)
+		}
+	})
+	t.Run("encryption with invalid option should fail", func(t *testing.T) {
+		_, err := NewEncrypterContext(context.Background(), bytes.NewReader(plaintext), testPassword,
+			WithConcurrency(0))
+		if !errors.Is(err, ErrInvalidOption) {
+			t.Errorf("expected error to be %s, got %s", ErrInvalidOption, err)
+		}
+	})
+}
+
+func TestNewDecrypterContext(t *testing.T) {
+	plaintext := make([]byte, 4*defaultSegmentSize)
+	ciphertext := encryptBytes(t, plaintext)
+
+	t.Run("decryption with context succeeds", func(t *testing.T) {
+		decrypter, err := NewDecrypterContext(context.Background(), bytes.NewReader(ciphertext), testPassword)
+		if err != nil {
+			t.Fatalf("failed to create decrypter: %s", err)
+		}
+		decrypted, err := io.ReadAll(decrypter)
+		if err != nil {
+			t.Fatalf("failed to decrypt ciphertext: %s", err)
+		}
+		if !bytes.Equal(plaintext, decrypted) {
+			t.Error("plaintext and decrypted data do not match")
+		}
+		if err = decrypter.Close(); err != nil {
+			t.Errorf("failed to close decrypter: %s", err)
+		}
+	})
+	t.Run("decryption with cancelled context should fail", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := NewDecrypterContext(ctx, bytes.NewReader(ciphertext), testPassword)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected error to be %s, got %s", context.Canceled, err)
+		}
+	})
+	t.Run("reading after cancellation should fail", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		decrypter, err := NewDecrypterContext(ctx, bytes.NewReader(ciphertext), testPassword)
+		if err != nil {
+			t.Fatalf("failed to create decrypter: %s", err)
+		}
+		if _, err = decrypter.Read(make([]byte, 10)); err != nil {
+			t.Fatalf("failed to read plaintext: %s", err)
+		}
+		cancel()
+		if _, err = decrypter.Read(make([]byte, 10)); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected error to be %s, got %s", context.Canceled, err)
+		}
+	})
+	t.Run("cancellation aborts spooling of legacy ciphertext", func(t *testing.T) {
+		legacy, err := newLegacyEncrypter(bytes.NewReader(plaintext), testPassword)
+		if err != nil {
+			t.Fatalf("failed to create legacy encrypter: %s", err)
+		}
+		legacyCiphertext, err := io.ReadAll(legacy)
+		if err != nil {
+			t.Fatalf("failed to encrypt plaintext: %s", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		reader := &cancelReader{reader: bytes.NewReader(legacyCiphertext), cancel: cancel, after: defaultSegmentSize}
+		_, err = NewDecrypterContext(ctx, reader, testPassword)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected error to be %s, got %s", context.Canceled, err)
+		}
+		if reader.read >= len(legacyCiphertext) {
+			t.Error("expected spooling to stop before the end of the ciphertext")
+		}
+	})
+	t.Run("decryption with empty password should fail", func(t *testing.T) {
+		_, err := NewDecrypterContext(context.Background(), bytes.NewReader(ciphertext), nil)
+		if !errors.Is(err, ErrPassPhraseEmpty) {
+			t.Errorf("expected error to be %s, got %s", ErrPassPhraseEmpty, err)
+		}
+	})
+	t.Run("decryption with wrong password should fail", func(t *testing.T) {
+		_, err := NewDecrypterContext(context.Background(), bytes.NewReader(ciphertext), []byte("wrong password"))
+		if !errors.Is(err, ErrFailedAuthentication) {
+			t.Errorf("expected error to be %s, got %s", ErrFailedAuthentication, err)
+		}
+	})
+}
+
+// cancelReader is an io.Reader that cancels a context once a given number of bytes has been read.
+type cancelReader struct {
+	reader io.Reader
+	cancel context.CancelFunc
+	after  int
+	read   int
+}
+
+// Read satisfies the io.Reader interface for the cancelReader type.
+func (c *cancelReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.read += n
+	if c.read >= c.after {
+		c.cancel()
+	}
+	return n, err
+}
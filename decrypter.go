@@ -8,52 +8,164 @@ import (
 	"bufio"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ecdh"
 	"crypto/hmac"
 	"errors"
 	"fmt"
 	"io"
-	"os"
-
-	wa "github.com/wneessen/argon2"
 )
 
 // ErrTooLessRounds indicates that the provided number of rounds is smaller than the minimum
 // required threshold.
 var ErrTooLessRounds = errors.New("number of rounds too small")
 
-func NewDecrypter(r io.Reader, password []byte) (io.ReadCloser, error) {
-	aesKey, hmacKey, iv, header, err := readParameters(r, password)
+// NewDecrypter returns an io.ReadCloser that reads ciphertext from r and returns the authenticated
+// plaintext decrypted with a key derived from the given password.
+//
+// Ciphertext in the segmented stream format is decrypted and authenticated segment by segment, so
+// that the plaintext is released incrementally with constant memory usage. The first segment is
+// authenticated before NewDecrypter returns. Ciphertext in the legacy format, which is authenticated
+// by a single trailing HMAC, is spooled and authenticated as a whole before NewDecrypter returns. It
+// is spooled into a temporary file, unless another Spool is configured with WithMemorySpool,
+// WithHybridSpool or WithSpool. The Spool is released when the decrypter is closed, so the caller must
+// always call Close.
+func NewDecrypter(r io.Reader, password []byte, opts ...Option) (io.ReadCloser, error) {
+	if len(password) == 0 {
+		return nil, ErrPassPhraseEmpty
+	}
+	o, err := newOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return newDecrypter(r, passwordKeys(password), o)
+}
+
+// NewDecrypterWithPolicy returns an io.ReadCloser like NewDecrypter, but validates the key derivation
+// parameters read from the header against the given DecryptPolicy instead of the default policy. If
+// the header violates the policy, a PolicyError is returned before any key derivation takes place.
+//
+// It is equivalent to calling NewDecrypter with the WithPolicy Option.
+func NewDecrypterWithPolicy(r io.Reader, password []byte, policy DecryptPolicy) (io.ReadCloser, error) {
+	return NewDecrypter(r, password, WithPolicy(policy))
+}
+
+// NewDecrypterWithKey returns an io.ReadCloser that reads ciphertext from r and returns the
+// authenticated plaintext decrypted with keys derived from the given raw master key using HKDF. The
+// ciphertext must have been created with NewEncrypterWithKey, the master key must be MasterKeySize
+// bytes long.
+func NewDecrypterWithKey(r io.Reader, key []byte, opts ...Option) (io.ReadCloser, error) {
+	if len(key) != MasterKeySize {
+		return nil, ErrInvalidKeySize
+	}
+	o, err := newOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return newDecrypter(r, masterKeys(key), o)
+}
+
+// NewDecrypterWithIdentity returns an io.ReadCloser that reads ciphertext from r and returns the
+// authenticated plaintext. The ciphertext must have been created with NewEncrypterForRecipients and the
+// given X25519 private key must belong to one of its recipients. If none of the key slots in the header
+// can be unwrapped with the identity, ErrNoMatchingKeySlot is returned.
+func NewDecrypterWithIdentity(r io.Reader, identity *ecdh.PrivateKey, opts ...Option) (io.ReadCloser, error) {
+	if identity == nil || identity.Curve() != ecdh.X25519() {
+		return nil, ErrInvalidRecipient
+	}
+	o, err := newOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return newDecrypter(r, identityKeys(identity), o)
+}
+
+// newDecrypter reads the header from r, validates it against the configured DecryptPolicy, derives
+// the keys using the given keyFunc and returns the decrypter for the format version of the ciphertext.
+func newDecrypter(r io.Reader, keys keyFunc, o *options) (io.ReadCloser, error) {
+	buffer := bufio.NewReaderSize(r, chunkSize)
+	hdr, aesKey, hmacKey, err := readParameters(buffer, keys, o.policy)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read encryption parameters: %w", err)
 	}
-	hasher := hmac.New(hashFunc, hmacKey)
-	hasher.Write(header)
+	if hdr.version == formatVersionLegacy {
+		return newLegacyDecrypter(buffer, hdr, aesKey, hmacKey, o)
+	}
+	return newSegmentedDecrypter(buffer, hdr, aesKey, hmacKey, o.concurrency)
+}
 
-	// We need to write the reader contents into a temporary file to authenticate the HMAC
-	tempFile, err := os.CreateTemp("", "iocrypter-*")
+// newSegmentedDecrypter returns a streamDecrypter for the segments that follow the header read
+// from r, after authenticating the first segment. Up to the given number of workers open segments
+// in parallel.
+func newSegmentedDecrypter(r io.Reader, hdr *header, aesKey, hmacKey []byte, workers int) (io.ReadCloser, error) {
+	segCipher, err := newSegmentCipher(hdr.suite, aesKey, hmacKey, hdr.iv, hdr.ad, int(hdr.segmentSize))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+		return nil, err
 	}
-	defer func() {
-		_ = os.RemoveAll(tempFile.Name())
-	}()
 
+	// Authenticate the first segment right away, so that an incorrect password or corrupted
+	// data is reported by the constructor. Errors of subsequent segments that are opened along
+	// with it are returned by Read, once the preceding plaintext has been released
+	decrypter := newStreamDecrypter(r, segCipher, int(hdr.segmentSize), workers)
+	err = decrypter.openSegments()
+	if err != nil && len(decrypter.pending) == 0 {
+		return nil, err
+	}
+	decrypter.err = err
+	return decrypter, nil
+}
+
+// newLegacyDecrypter reads ciphertext in the legacy format from r, which is authenticated by a
+// single HMAC at the end of the ciphertext. The ciphertext is spooled into the configured Spool until
+// the HMAC has been verified, which is closed when the returned decrypter is closed.
+func newLegacyDecrypter(r io.Reader, hdr *header, aesKey, hmacKey []byte, o *options) (io.ReadCloser, error) {
 	block, err := aes.NewCipher(aesKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create AES block cipher: %w", err)
 	}
 
-	decrypter := io.NopCloser(&cipher.StreamReader{
-		R: tempFile,
-		S: cipher.NewCTR(block, iv),
-	})
+	// We need to write the reader contents into a spool to authenticate the HMAC
+	spool, err := o.newSpool()
+	if err != nil {
+		return nil, err
+	}
+	if err = spoolLegacy(r, hdr, hmacKey, spool); err != nil {
+		_ = spool.Close()
+		return nil, err
+	}
+
+	return &legacyDecrypter{
+		reader: &cipher.StreamReader{R: spool, S: cipher.NewCTR(block, hdr.iv)},
+		spool:  spool,
+	}, nil
+}
+
+// spoolLegacy writes the ciphertext in the legacy format read from r to the given spool, verifies
+// the trailing HMAC and rewinds the spool to its start.
+func spoolLegacy(r io.Reader, hdr *header, hmacKey []byte, spool io.ReadWriteSeeker) error {
+	if err := authenticateLegacy(r, hdr, hmacKey, spool); err != nil {
+		return err
+	}
+
+	// Go back to the start of the file
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek to start of file: %w", err)
+	}
+	return nil
+}
+
+// authenticateLegacy reads the ciphertext in the legacy format from r, writes it without the
+// trailing HMAC to w and verifies the HMAC.
+func authenticateLegacy(r io.Reader, hdr *header, hmacKey []byte, w io.Writer) error {
+	hasher := hmac.New(hashFunc, hmacKey)
+	hasher.Write(hdr.raw)
+
 	checksum := make([]byte, hmacSize)
-	writer := io.MultiWriter(hasher, tempFile)
+	writer := io.MultiWriter(hasher, w)
 	buffer := bufio.NewReaderSize(r, chunkSize)
 	for {
 		data, err := buffer.Peek(chunkSize)
 		if err != nil && !errors.Is(err, io.EOF) {
-			return nil, fmt.Errorf("failed to read bytes from reader: %w", err)
+			return fmt.Errorf("failed to read bytes from reader: %w", err)
 		}
 
 		// If we reached the end of the file, we read the rest of the buffered
@@ -62,65 +174,42 @@ func NewDecrypter(r io.Reader, password []byte) (io.ReadCloser, error) {
 		if errors.Is(err, io.EOF) {
 			rest := buffer.Buffered()
 			if rest < hmacSize {
-				return nil, ErrMissingData
+				return ErrMissingData
 			}
 			copy(checksum, data[rest-hmacSize:rest])
 			_, err = io.CopyN(writer, buffer, int64(rest-hmacSize))
 			if err != nil {
-				return nil, fmt.Errorf("failed to rest of buffered bytes: %w", err)
+				return fmt.Errorf("failed to rest of buffered bytes: %w", err)
 			}
 			break
 		}
 
 		_, err = io.CopyN(writer, buffer, int64(chunkSize-hmacSize))
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 
 	// Authenticate the data
 	if !hmac.Equal(checksum, hasher.Sum(nil)) {
-		return nil, ErrFailedAuthentication
+		return ErrFailedAuthentication
 	}
-
-	// Go back to the start of the file
-	if _, err = tempFile.Seek(0, io.SeekStart); err != nil {
-		return nil, fmt.Errorf("failed to seek to start of file: %w", err)
-	}
-
-	return decrypter, nil
+	return nil
 }
 
-// readParameters reads and deserializes the Argon2 settings, salt, IV, and derives keys from the provided
-// reader and password.
-func readParameters(r io.Reader, password []byte) ([]byte, []byte, []byte, []byte, error) {
-	if len(password) == 0 {
-		return nil, nil, nil, nil, ErrPassPhraseEmpty
-	}
-	settingsSerialized := make([]byte, wa.SerializedSettingsLength)
-	if _, err := io.ReadFull(r, settingsSerialized); err != nil {
-		return nil, nil, nil, nil, fmt.Errorf("failed to read Argon2 settings: %w", err)
-	}
-	settings := wa.SettingsFromBytes(settingsSerialized)
-
-	salt := make([]byte, settings.SaltLength)
-	if _, err := io.ReadFull(r, salt); err != nil {
-		return nil, nil, nil, nil, fmt.Errorf("failed to read salt: %w", err)
-	}
-
-	iv := make([]byte, blockSize)
-	if _, err := io.ReadFull(r, iv); err != nil {
-		return nil, nil, nil, nil, fmt.Errorf("failed to read IV: %w", err)
-	}
+// legacyDecrypter is an io.ReadCloser that decrypts the authenticated ciphertext in the legacy
+// format from its spool. Closing it closes and removes the spool.
+type legacyDecrypter struct {
+	reader io.Reader
+	spool  io.Closer
+}
 
-	header := make([]byte, len(settingsSerialized)+len(salt)+len(iv))
-	copy(header, settingsSerialized)
-	copy(header[len(settingsSerialized):], salt)
-	copy(header[len(settingsSerialized)+len(salt):], iv)
-	if settings.Time < 1 {
-		return nil, nil, nil, nil, ErrTooLessRounds
-	}
-	aesKey, hmacKey := DeriveKeys(password, salt, settings)
+// Read satisfies the io.Reader interface for the legacyDecrypter type.
+func (l *legacyDecrypter) Read(p []byte) (int, error) {
+	return l.reader.Read(p)
+}
 
-	return aesKey, hmacKey, iv, header, nil
+// Close satisfies the io.Closer interface for the legacyDecrypter type.
+func (l *legacyDecrypter) Close() error {
+	return l.spool.Close()
 }
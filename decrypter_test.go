@@ -6,10 +6,18 @@ package iocrypter
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"strings"
 	"testing"
+
+	wa "github.com/wneessen/argon2"
 )
 
 func TestNewDecrypter(t *testing.T) {
@@ -151,3 +159,283 @@ func TestNewDecrypter(t *testing.T) {
 		}
 	})
 }
+
+func TestNewDecrypter_segmented(t *testing.T) {
+	sizes := []int{0, 1, defaultSegmentSize - 1, defaultSegmentSize, defaultSegmentSize + 1, 3*defaultSegmentSize + 17}
+	for _, size := range sizes {
+		t.Run(fmt.Sprintf("encrypt/decrypt round trip with %d bytes", size), func(t *testing.T) {
+			plaintext := make([]byte, size)
+			if _, err := io.ReadFull(rand.Reader, plaintext); err != nil {
+				t.Fatalf("failed to generate plaintext: %s", err)
+			}
+			ciphertext := encryptBytes(t, plaintext)
+			decrypter, err := NewDecrypter(bytes.NewReader(ciphertext), testPassword)
+			if err != nil {
+				t.Fatalf("failed to create decrypter: %s", err)
+			}
+			decrypted, err := io.ReadAll(decrypter)
+			if err != nil {
+				t.Fatalf("failed to decrypt ciphertext: %s", err)
+			}
+			if !bytes.Equal(plaintext, decrypted) {
+				t.Errorf("plaintext and decrypted data do not match")
+			}
+			if err = decrypter.Close(); err != nil {
+				t.Errorf("failed to close decrypter: %s", err)
+			}
+		})
+	}
+
+	plaintext := make([]byte, 3*defaultSegmentSize+17)
+	ciphertext := encryptBytes(t, plaintext)
+	headerLen := len(ciphertext) - 4*hmacSize - len(plaintext)
+	sealedSegmentSize := defaultSegmentSize + hmacSize
+
+	t.Run("decryption of truncated stream at segment boundary should fail", func(t *testing.T) {
+		truncated := ciphertext[:headerLen+3*sealedSegmentSize]
+		decrypter, err := NewDecrypter(bytes.NewReader(truncated), testPassword)
+		if err != nil {
+			t.Fatalf("failed to create decrypter: %s", err)
+		}
+		_, err = io.ReadAll(decrypter)
+		if !errors.Is(err, ErrFailedAuthentication) {
+			t.Errorf("expected error to be %s, got %s", ErrFailedAuthentication, err)
+		}
+	})
+	t.Run("decryption of reordered segments should fail", func(t *testing.T) {
+		reordered := make([]byte, 0, len(ciphertext))
+		reordered = append(reordered, ciphertext[:headerLen]...)
+		reordered = append(reordered, ciphertext[headerLen+sealedSegmentSize:headerLen+2*sealedSegmentSize]...)
+		reordered = append(reordered, ciphertext[headerLen:headerLen+sealedSegmentSize]...)
+		reordered = append(reordered, ciphertext[headerLen+2*sealedSegmentSize:]...)
+		_, err := NewDecrypter(bytes.NewReader(reordered), testPassword)
+		if !errors.Is(err, ErrFailedAuthentication) {
+			t.Errorf("expected error to be %s, got %s", ErrFailedAuthentication, err)
+		}
+	})
+	t.Run("decryption of tampered later segment should fail", func(t *testing.T) {
+		tampered := bytes.Clone(ciphertext)
+		tampered[headerLen+2*sealedSegmentSize+1] ^= 0xff
+		decrypter, err := NewDecrypter(bytes.NewReader(tampered), testPassword)
+		if err != nil {
+			t.Fatalf("failed to create decrypter: %s", err)
+		}
+		_, err = io.ReadAll(decrypter)
+		if !errors.Is(err, ErrFailedAuthentication) {
+			t.Errorf("expected error to be %s, got %s", ErrFailedAuthentication, err)
+		}
+	})
+	t.Run("decryption with invalid segment size should fail", func(t *testing.T) {
+		tampered := bytes.Clone(ciphertext)
+		binary.BigEndian.PutUint32(tampered[headerLen-segmentSizeLength:headerLen], maxSegmentSize+1)
+		_, err := NewDecrypter(bytes.NewReader(tampered), testPassword)
+		if !errors.Is(err, ErrInvalidSegmentSize) {
+			t.Errorf("expected error to be %s, got %s", ErrInvalidSegmentSize, err)
+		}
+	})
+	t.Run("decryption with unsupported format version should fail", func(t *testing.T) {
+		tampered := bytes.Clone(ciphertext)
+		tampered[len(headerMagic)] = 0xff
+		_, err := NewDecrypter(bytes.NewReader(tampered), testPassword)
+		if !errors.Is(err, ErrUnsupportedVersion) {
+			t.Errorf("expected error to be %s, got %s", ErrUnsupportedVersion, err)
+		}
+	})
+	t.Run("decryption with missing format version should fail", func(t *testing.T) {
+		_, err := NewDecrypter(bytes.NewReader(headerMagic), testPassword)
+		if err == nil {
+			t.Fatal("expected decryption to fail with missing format version")
+		}
+		expErr := "failed to read format version"
+		if !strings.Contains(err.Error(), expErr) {
+			t.Errorf("expected error to contain %s, got %s", expErr, err)
+		}
+	})
+	t.Run("decryption with missing segment size should fail", func(t *testing.T) {
+		_, err := NewDecrypter(bytes.NewReader(ciphertext[:headerLen-1]), testPassword)
+		if err == nil {
+			t.Fatal("expected decryption to fail with missing segment size")
+		}
+		expErr := "failed to read segment size"
+		if !strings.Contains(err.Error(), expErr) {
+			t.Errorf("expected error to contain %s, got %s", expErr, err)
+		}
+	})
+}
+
+func TestNewDecrypter_legacy(t *testing.T) {
+	plaintext := []byte("This is the plaintext of the legacy format")
+	legacy, err := newLegacyEncrypter(bytes.NewReader(plaintext), testPassword)
+	if err != nil {
+		t.Fatalf("failed to create legacy encrypter: %s", err)
+	}
+	ciphertext, err := io.ReadAll(legacy)
+	if err != nil {
+		t.Fatalf("failed to encrypt plaintext: %s", err)
+	}
+
+	t.Run("legacy ciphertext is still decrypted", func(t *testing.T) {
+		decrypter, err := NewDecrypter(bytes.NewReader(ciphertext), testPassword)
+		if err != nil {
+			t.Fatalf("failed to create decrypter: %s", err)
+		}
+		decrypted, err := io.ReadAll(decrypter)
+		if err != nil {
+			t.Fatalf("failed to decrypt ciphertext: %s", err)
+		}
+		if !bytes.Equal(plaintext, decrypted) {
+			t.Errorf("plaintext and decrypted data do not match, expected %s, got %s", plaintext, decrypted)
+		}
+	})
+	t.Run("tampered legacy ciphertext should fail", func(t *testing.T) {
+		tampered := bytes.Clone(ciphertext)
+		tampered[len(tampered)-hmacSize-1] ^= 0xff
+		_, err := NewDecrypter(bytes.NewReader(tampered), testPassword)
+		if !errors.Is(err, ErrFailedAuthentication) {
+			t.Errorf("expected error to be %s, got %s", ErrFailedAuthentication, err)
+		}
+	})
+}
+
+// encryptBytes encrypts the given plaintext with the test password and returns the ciphertext.
+func encryptBytes(t *testing.T, plaintext []byte) []byte {
+	t.Helper()
+	encrypter, err := NewEncrypter(bytes.NewReader(plaintext), testPassword)
+	if err != nil {
+		t.Fatalf("failed to create encrypter: %s", err)
+	}
+	ciphertext, err := io.ReadAll(encrypter)
+	if err != nil {
+		t.Fatalf("failed to encrypt plaintext: %s", err)
+	}
+	return ciphertext
+}
+
+// newLegacyEncrypter returns an io.Reader that produces ciphertext in the legacy format, which
+// is authenticated by a single trailing HMAC. It is used to test the backwards compatibility
+// of the decrypter.
+func newLegacyEncrypter(r io.Reader, password []byte) (io.Reader, error) {
+	settings := wa.NewSettings(defaultArgon2Memory, defaultArgon2Time, defaultArgon2Threads, saltSize,
+		aesKeySize+hmacSize)
+	settingsSerialized := settings.Serialize()
+	salt := make([]byte, settings.SaltLength)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	aesKey, hmacKey := DeriveKeys(password, salt, settings)
+	iv := make([]byte, blockSize)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, len(settingsSerialized)+len(salt)+len(iv))
+	header = append(header, settingsSerialized...)
+	header = append(header, salt...)
+	header = append(header, iv...)
+
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	streamReader := &cipher.StreamReader{R: r, S: cipher.NewCTR(block, iv)}
+	hmacReadWriter := NewHashReadWriter(hmac.New(hashFunc, hmacKey))
+	return io.MultiReader(io.TeeReader(io.MultiReader(bytes.NewReader(header), streamReader), hmacReadWriter),
+		hmacReadWriter), nil
+}
+
+func TestNewDecrypterWithKey(t *testing.T) {
+	plaintext := make([]byte, defaultSegmentSize+1)
+	if _, err := io.ReadFull(rand.Reader, plaintext); err != nil {
+		t.Fatalf("failed to generate plaintext: %s", err)
+	}
+	encrypter, err := NewEncrypterWithKey(bytes.NewReader(plaintext), testKey)
+	if err != nil {
+		t.Fatalf("failed to create encrypter: %s", err)
+	}
+	ciphertext, err := io.ReadAll(encrypter)
+	if err != nil {
+		t.Fatalf("failed to encrypt plaintext: %s", err)
+	}
+
+	t.Run("normal encrypt/decrypt operation", func(t *testing.T) {
+		decrypter, err := NewDecrypterWithKey(bytes.NewReader(ciphertext), testKey)
+		if err != nil {
+			t.Fatalf("failed to create decrypter: %s", err)
+		}
+		decrypted, err := io.ReadAll(decrypter)
+		if err != nil {
+			t.Fatalf("failed to decrypt ciphertext: %s", err)
+		}
+		if !bytes.Equal(plaintext, decrypted) {
+			t.Error("plaintext and decrypted data do not match")
+		}
+	})
+	t.Run("decryption with invalid key should fail", func(t *testing.T) {
+		invalidKey := bytes.Clone(testKey)
+		invalidKey[0] ^= 0xff
+		_, err := NewDecrypterWithKey(bytes.NewReader(ciphertext), invalidKey)
+		if !errors.Is(err, ErrFailedAuthentication) {
+			t.Errorf("expected error to be %s, got %s", ErrFailedAuthentication, err)
+		}
+	})
+	t.Run("decryption with invalid key size should fail", func(t *testing.T) {
+		_, err := NewDecrypterWithKey(bytes.NewReader(ciphertext), testKey[:10])
+		if !errors.Is(err, ErrInvalidKeySize) {
+			t.Errorf("expected error to be %s, got %s", ErrInvalidKeySize, err)
+		}
+	})
+	t.Run("decryption with password should fail", func(t *testing.T) {
+		_, err := NewDecrypter(bytes.NewReader(ciphertext), testPassword)
+		if !errors.Is(err, ErrKeyTypeMismatch) {
+			t.Errorf("expected error to be %s, got %s", ErrKeyTypeMismatch, err)
+		}
+	})
+	t.Run("decryption of password encrypted ciphertext should fail", func(t *testing.T) {
+		passwordCiphertext := encryptBytes(t, plaintext)
+		_, err := NewDecrypterWithKey(bytes.NewReader(passwordCiphertext), testKey)
+		if !errors.Is(err, ErrKeyTypeMismatch) {
+			t.Errorf("expected error to be %s, got %s", ErrKeyTypeMismatch, err)
+		}
+	})
+	t.Run("decryption with unsupported key derivation should fail", func(t *testing.T) {
+		tampered := bytes.Clone(ciphertext)
+		tampered[len(headerMagic)+2] = 0xff
+		_, err := NewDecrypterWithKey(bytes.NewReader(tampered), testKey)
+		if !errors.Is(err, ErrUnsupportedKeyDerivation) {
+			t.Errorf("expected error to be %s, got %s", ErrUnsupportedKeyDerivation, err)
+		}
+	})
+	t.Run("format version 2 without key derivation is still decrypted", func(t *testing.T) {
+		hdr := &header{
+			version:     formatVersion2,
+			suite:       CipherSuiteAES256GCM,
+			kdf:         keyDerivationArgon2ID,
+			params:      argon2IDKDF(testSettings),
+			salt:        make([]byte, saltSize),
+			iv:          make([]byte, blockSize),
+			segmentSize: defaultSegmentSize,
+		}
+		hdr.marshal()
+		aesKey, hmacKey := DeriveKeys(testPassword, hdr.salt, testSettings)
+		segCipher, err := newSegmentCipher(hdr.suite, aesKey, hmacKey, hdr.iv, hdr.raw, defaultSegmentSize)
+		if err != nil {
+			t.Fatalf("failed to create segment cipher: %s", err)
+		}
+		v2Ciphertext, err := io.ReadAll(newStreamEncrypter(bytes.NewReader(plaintext), segCipher, hdr.raw,
+			defaultSegmentSize, 1))
+		if err != nil {
+			t.Fatalf("failed to encrypt plaintext: %s", err)
+		}
+		decrypter, err := NewDecrypter(bytes.NewReader(v2Ciphertext), testPassword)
+		if err != nil {
+			t.Fatalf("failed to create decrypter: %s", err)
+		}
+		decrypted, err := io.ReadAll(decrypter)
+		if err != nil {
+			t.Fatalf("failed to decrypt ciphertext: %s", err)
+		}
+		if !bytes.Equal(plaintext, decrypted) {
+			t.Error("plaintext and decrypted data do not match")
+		}
+	})
+}
@@ -0,0 +1,193 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// decryptWriter is an io.WriteCloser that accepts ciphertext, authenticates and decrypts it and
+// writes the plaintext to an underlying io.Writer.
+type decryptWriter struct {
+	writer     io.Writer
+	keys       keyFunc
+	options    *options
+	pending    bytes.Buffer
+	cipher     segmentCipher
+	sealedSize int
+	plain      [][]byte
+	counter    uint64
+	legacy     *io.PipeWriter
+	legacyErr  chan error
+	closed     bool
+	err        error
+}
+
+// NewDecryptWriter returns an io.WriteCloser that accepts ciphertext via Write and writes the
+// authenticated plaintext, decrypted with a key derived from the given passphrase, to dst. It accepts
+// the same Options as NewDecrypter.
+//
+// The header is parsed incrementally as the ciphertext is written. Ciphertext in the segmented
+// stream format is forwarded to dst segment by segment, once each segment has been authenticated.
+// Since the final segment can only be identified once all ciphertext has been written, it is
+// authenticated and forwarded by Close, which returns ErrFailedAuthentication if the
+// authentication fails. Ciphertext in the legacy format is spooled like with NewDecrypter and
+// only forwarded to dst by Close, once the trailing HMAC has been verified. Close does not close
+// the underlying io.Writer.
+func NewDecryptWriter(dst io.Writer, pass []byte, opts ...Option) (io.WriteCloser, error) {
+	if len(pass) == 0 {
+		return nil, ErrPassPhraseEmpty
+	}
+	o, err := newOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptWriter{writer: dst, keys: passwordKeys(pass), options: o}, nil
+}
+
+// Write satisfies the io.Writer interface for the decryptWriter type.
+func (d *decryptWriter) Write(p []byte) (int, error) {
+	if d.closed {
+		return 0, ErrWriteAfterClose
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	if d.legacy != nil {
+		return d.legacy.Write(p)
+	}
+
+	d.pending.Write(p)
+	if d.cipher == nil {
+		if d.err = d.readHeader(); d.err != nil {
+			return 0, d.err
+		}
+	}
+	if d.cipher != nil {
+		if d.err = d.writeSegments(); d.err != nil {
+			return 0, d.err
+		}
+	}
+	return len(p), nil
+}
+
+// Close satisfies the io.Closer interface for the decryptWriter type. It authenticates the final
+// segment and writes its plaintext to the underlying writer. It does not close the underlying
+// io.Writer.
+func (d *decryptWriter) Close() error {
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	if d.err != nil {
+		return d.err
+	}
+	if d.legacy != nil {
+		if err := d.legacy.Close(); err != nil {
+			return err
+		}
+		return <-d.legacyErr
+	}
+	if d.cipher == nil {
+		return fmt.Errorf("failed to read encryption parameters: %w", ErrMissingData)
+	}
+	if d.pending.Len() < d.cipher.overhead() {
+		return ErrMissingData
+	}
+	return d.openSegments([][]byte{d.pending.Next(d.pending.Len())}, true)
+}
+
+// readHeader tries to parse the header from the pending ciphertext. If the pending ciphertext does
+// not yet contain the full header, readHeader returns without an error and is retried on the next
+// write.
+func (d *decryptWriter) readHeader() error {
+	hdr, err := readHeader(bufio.NewReader(bytes.NewReader(d.pending.Bytes())), d.options.policy)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read encryption parameters: %w", err)
+	}
+
+	// The legacy format can only be authenticated as a whole, so we hand it off to the regular
+	// decrypter, which spools the ciphertext until the HMAC has been verified
+	if hdr.version == formatVersionLegacy {
+		reader, writer := io.Pipe()
+		d.legacy = writer
+		d.legacyErr = make(chan error, 1)
+		go d.decryptLegacy(reader)
+		_, err = d.pending.WriteTo(d.legacy)
+		return err
+	}
+
+	aesKey, hmacKey, err := d.keys(hdr)
+	if err != nil {
+		return fmt.Errorf("failed to read encryption parameters: %w", err)
+	}
+	d.cipher, err = newSegmentCipher(hdr.suite, aesKey, hmacKey, hdr.iv, hdr.ad, int(hdr.segmentSize))
+	if err != nil {
+		return err
+	}
+	d.sealedSize = int(hdr.segmentSize) + d.cipher.overhead()
+	d.plain = make([][]byte, max(d.options.concurrency, 1))
+	for i := range d.plain {
+		d.plain[i] = make([]byte, 0, hdr.segmentSize)
+	}
+	d.pending.Next(len(hdr.raw))
+	return nil
+}
+
+// decryptLegacy decrypts the legacy format ciphertext read from the given io.PipeReader using
+// the regular decrypter and writes the plaintext to the underlying writer.
+func (d *decryptWriter) decryptLegacy(reader *io.PipeReader) {
+	decrypter, err := newDecrypter(reader, d.keys, d.options)
+	if err == nil {
+		// Closing the decrypter closes and removes its temporary file
+		_, err = io.Copy(d.writer, decrypter)
+		err = errors.Join(err, decrypter.Close())
+	}
+	_ = reader.CloseWithError(err)
+	d.legacyErr <- err
+}
+
+// writeSegments authenticates and decrypts all pending segments that are known not to be the
+// final segment, and writes the plaintext to the underlying writer. Up to one segment per worker
+// is opened in parallel.
+func (d *decryptWriter) writeSegments() error {
+	for d.pending.Len() > d.sealedSize {
+		segments := make([][]byte, min(len(d.plain), (d.pending.Len()-1)/d.sealedSize))
+		for i := range segments {
+			segments[i] = d.pending.Next(d.sealedSize)
+		}
+		if err := d.openSegments(segments, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openSegments authenticates and decrypts the given segments in parallel and writes the plaintext to
+// the underlying writer. If final is set, the last segment is opened as the final segment of the
+// stream. The plaintext of the segments preceding the first segment that fails is still written,
+// since those have been authenticated.
+func (d *decryptWriter) openSegments(segments [][]byte, final bool) error {
+	plain := make([][]byte, len(segments))
+	opened, openErr := parallelize(len(segments), len(d.plain), func(i int) error {
+		var err error
+		plain[i], err = d.cipher.open(d.plain[i][:0], segments[i], d.counter+uint64(i), final && i == len(segments)-1)
+		return err
+	})
+	for _, segment := range plain[:opened] {
+		if _, err := d.writer.Write(segment); err != nil {
+			return fmt.Errorf("failed to write plaintext: %w", err)
+		}
+	}
+	d.counter += uint64(opened)
+	return openErr
+}
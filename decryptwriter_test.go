@@ -0,0 +1,187 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestNewDecryptWriter(t *testing.T) {
+	plaintext := make([]byte, 2*defaultSegmentSize+123)
+	if _, err := io.ReadFull(rand.Reader, plaintext); err != nil {
+		t.Fatalf("failed to generate plaintext: %s", err)
+	}
+	ciphertext := encryptBytes(t, plaintext)
+
+	t.Run("normal decryption in one write", func(t *testing.T) {
+		decrypted, err := decryptWithWriter(ciphertext, testPassword, len(ciphertext))
+		if err != nil {
+			t.Fatalf("failed to decrypt ciphertext: %s", err)
+		}
+		if !bytes.Equal(plaintext, decrypted) {
+			t.Error("plaintext and decrypted data do not match")
+		}
+	})
+	t.Run("normal decryption in small writes", func(t *testing.T) {
+		decrypted, err := decryptWithWriter(ciphertext, testPassword, 3)
+		if err != nil {
+			t.Fatalf("failed to decrypt ciphertext: %s", err)
+		}
+		if !bytes.Equal(plaintext, decrypted) {
+			t.Error("plaintext and decrypted data do not match")
+		}
+	})
+	t.Run("decryption of legacy ciphertext", func(t *testing.T) {
+		legacy, err := newLegacyEncrypter(bytes.NewReader(plaintext), testPassword)
+		if err != nil {
+			t.Fatalf("failed to create legacy encrypter: %s", err)
+		}
+		legacyCiphertext, err := io.ReadAll(legacy)
+		if err != nil {
+			t.Fatalf("failed to encrypt plaintext: %s", err)
+		}
+		decrypted, err := decryptWithWriter(legacyCiphertext, testPassword, 1000)
+		if err != nil {
+			t.Fatalf("failed to decrypt ciphertext: %s", err)
+		}
+		if !bytes.Equal(plaintext, decrypted) {
+			t.Error("plaintext and decrypted data do not match")
+		}
+
+		legacyCiphertext[len(legacyCiphertext)-1] ^= 0xff
+		if _, err = decryptWithWriter(legacyCiphertext, testPassword, 1000); !errors.Is(err, ErrFailedAuthentication) {
+			t.Errorf("expected error to be %s, got %s", ErrFailedAuthentication, err)
+		}
+	})
+	t.Run("decryption of legacy ciphertext removes the temporary file", func(t *testing.T) {
+		legacy, err := newLegacyEncrypter(bytes.NewReader(plaintext), testPassword)
+		if err != nil {
+			t.Fatalf("failed to create legacy encrypter: %s", err)
+		}
+		legacyCiphertext, err := io.ReadAll(legacy)
+		if err != nil {
+			t.Fatalf("failed to encrypt plaintext: %s", err)
+		}
+		tampered := bytes.Clone(legacyCiphertext)
+		tampered[len(tampered)-1] ^= 0xff
+		for _, data := range [][]byte{legacyCiphertext, tampered} {
+			dir := t.TempDir()
+			writer, err := NewDecryptWriter(io.Discard, testPassword, WithTempDir(dir))
+			if err != nil {
+				t.Fatalf("failed to create decrypt writer: %s", err)
+			}
+			if _, err = writer.Write(data); err != nil {
+				t.Fatalf("failed to write ciphertext: %s", err)
+			}
+			_ = writer.Close()
+			assertEmptyDir(t, dir)
+		}
+	})
+	t.Run("decryption with invalid passphrase should fail", func(t *testing.T) {
+		short := encryptBytes(t, []byte("short plaintext"))
+		_, err := decryptWithWriter(short, []byte("invalid passphrase"), len(short))
+		if !errors.Is(err, ErrFailedAuthentication) {
+			t.Errorf("expected error to be %s, got %s", ErrFailedAuthentication, err)
+		}
+	})
+	t.Run("decryption of tampered final segment should fail on close", func(t *testing.T) {
+		tampered := bytes.Clone(ciphertext)
+		tampered[len(tampered)-1] ^= 0xff
+		buffer := bytes.NewBuffer(nil)
+		writer, err := NewDecryptWriter(buffer, testPassword)
+		if err != nil {
+			t.Fatalf("failed to create decrypt writer: %s", err)
+		}
+		if _, err = writer.Write(tampered); err != nil {
+			t.Fatalf("failed to write ciphertext: %s", err)
+		}
+		if buffer.Len() != 2*defaultSegmentSize {
+			t.Errorf("expected %d bytes of authenticated plaintext, got %d", 2*defaultSegmentSize, buffer.Len())
+		}
+		if err = writer.Close(); !errors.Is(err, ErrFailedAuthentication) {
+			t.Errorf("expected error to be %s, got %s", ErrFailedAuthentication, err)
+		}
+	})
+	t.Run("decryption of incomplete header should fail on close", func(t *testing.T) {
+		_, err := decryptWithWriter(ciphertext[:len(headerMagic)+3], testPassword, 10)
+		if !errors.Is(err, ErrMissingData) {
+			t.Errorf("expected error to be %s, got %s", ErrMissingData, err)
+		}
+	})
+	t.Run("decryption of missing final segment should fail on close", func(t *testing.T) {
+		short := encryptBytes(t, nil)
+		_, err := decryptWithWriter(short[:len(short)-1], testPassword, len(short))
+		if !errors.Is(err, ErrMissingData) {
+			t.Errorf("expected error to be %s, got %s", ErrMissingData, err)
+		}
+	})
+	t.Run("decryption with unsupported format version should fail", func(t *testing.T) {
+		tampered := bytes.Clone(ciphertext)
+		tampered[len(headerMagic)] = 0xff
+		_, err := decryptWithWriter(tampered, testPassword, 10)
+		if !errors.Is(err, ErrUnsupportedVersion) {
+			t.Errorf("expected error to be %s, got %s", ErrUnsupportedVersion, err)
+		}
+	})
+	t.Run("decrypt writer creation with nil passphrase should fail", func(t *testing.T) {
+		_, err := NewDecryptWriter(bytes.NewBuffer(nil), nil)
+		if !errors.Is(err, ErrPassPhraseEmpty) {
+			t.Errorf("expected error to be %s, got %s", ErrPassPhraseEmpty, err)
+		}
+	})
+	t.Run("write after close should fail", func(t *testing.T) {
+		writer, err := NewDecryptWriter(bytes.NewBuffer(nil), testPassword)
+		if err != nil {
+			t.Fatalf("failed to create decrypt writer: %s", err)
+		}
+		_ = writer.Close()
+		if err = writer.Close(); err != nil {
+			t.Errorf("expected second close to succeed, got %s", err)
+		}
+		if _, err = writer.Write(ciphertext); !errors.Is(err, ErrWriteAfterClose) {
+			t.Errorf("expected error to be %s, got %s", ErrWriteAfterClose, err)
+		}
+	})
+	t.Run("decrypt writer fails writing to broken writer", func(t *testing.T) {
+		writer, err := NewDecryptWriter(&failReadWriter{}, testPassword)
+		if err != nil {
+			t.Fatalf("failed to create decrypt writer: %s", err)
+		}
+		if _, err = writer.Write(ciphertext); err == nil {
+			t.Error("expected write to fail with broken writer")
+		}
+		if _, err = writer.Write(ciphertext); err == nil {
+			t.Error("expected subsequent write to fail with broken writer")
+		}
+		if err = writer.Close(); err == nil {
+			t.Error("expected close to fail with broken writer")
+		}
+	})
+}
+
+// decryptWithWriter decrypts the given ciphertext with a decrypt writer, writing it in chunks of
+// the given size, and returns the plaintext.
+func decryptWithWriter(ciphertext, password []byte, chunk int) ([]byte, error) {
+	buffer := bytes.NewBuffer(nil)
+	writer, err := NewDecryptWriter(buffer, password)
+	if err != nil {
+		return nil, err
+	}
+	for len(ciphertext) > 0 {
+		n := min(len(ciphertext), chunk)
+		if _, err = writer.Write(ciphertext[:n]); err != nil {
+			return nil, err
+		}
+		ciphertext = ciphertext[n:]
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
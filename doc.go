@@ -6,7 +6,71 @@
 // as a io.Reader interface. It allows the en- and decryption with authentication of
 // arbitrary data from a given io.Reader.
 //
-// It derives a secure key for the AES-256 encryption using Argon2ID. Encryption
-// parameters like the Argon2 settings, the salt and the IV are stored in the beginning
-// of the ciphertext, making it convenient for byte stream encryption.
+// The encrypters and decrypters are configured with functional options, like WithArgon2,
+// WithCipherSuite, WithChunkSize or WithPolicy.
+//
+// # Format
+//
+// Except for the legacy format, the ciphertext starts with the magic string "IOCRYPT",
+// followed by a single byte holding the format version, which determines the layout of the
+// remaining header. The header stores the encryption parameters like the cipher suite, the
+// key derivation and its settings, the salt and the IV, making it convenient for byte
+// stream encryption.
+//
+// The plaintext is split into segments that are authenticated independently. Each segment
+// is bound to its position in the stream and flagged if it is the final segment, so that
+// reordering or truncation of the ciphertext is detected and the decrypter can release
+// authenticated plaintext incrementally with constant memory usage. Besides the default
+// AES-256-CTR with HMAC-SHA512, the segments can be encrypted with AES-256-GCM or
+// XChaCha20-Poly1305, selected with WithCipherSuite.
+//
+// Ciphertext in the legacy format, which is authenticated by a single trailing HMAC, can
+// still be decrypted. It is spooled until it has been authenticated, by default into a
+// temporary file. WithMemorySpool, WithHybridSpool and WithSpool select another Spool, and
+// WithEncryptedSpool encrypts the spool with an ephemeral key and authenticates it again
+// when it is read back. The Spool is released when the decrypter is closed, so callers must
+// always close it.
+//
+// # Key derivation
+//
+// The keys are derived from the password with Argon2id by default. WithArgon2i, WithScrypt
+// and WithPBKDF2 select Argon2i, scrypt or PBKDF2-HMAC-SHA256 instead. Calibrate benchmarks
+// Argon2 on the current machine and returns settings for WithArgon2 that meet a target
+// duration. NewEncrypterWithKey and NewDecrypterWithKey use a raw 32 byte master key
+// instead of a password, from which the keys are derived using HKDF with a random salt for
+// each stream.
+//
+// # Key slots
+//
+// NewEncrypterWithKeySlots encrypts with a random file key that is wrapped separately for
+// any mix of passwords and X25519 public keys, given as PasswordKeySlot and X25519KeySlot.
+// NewDecrypter tries the password key slots and NewDecrypterWithIdentity the X25519 key
+// slots. NewEncrypterForRecipients only uses X25519 key slots, so that the encrypter never
+// holds a secret that is able to decrypt the ciphertext. The key slots are authenticated by
+// a header MAC that is keyed from the file key.
+//
+// Since the payload is encrypted with the file key, Rekey changes the password of a
+// password key slot by rewriting only the header, and RekeyInPlace overwrites the header of
+// a file without copying the payload. Ciphertext created by NewEncrypter has no key slots
+// and cannot be rekeyed.
+//
+// # Decrypt policy
+//
+// Since the key derivation parameters are read from the not yet authenticated header, they
+// are validated against a DecryptPolicy before any memory is allocated or any key
+// derivation takes place, so that decrypting untrusted data cannot be abused for memory or
+// CPU exhaustion.
+//
+// # Streaming and random access
+//
+// NewEncryptWriter and NewDecryptWriter provide the en- and decryption as an io.WriteCloser
+// for push-based pipelines. NewDecrypterAt provides random access to the plaintext through
+// the io.ReaderAt and io.ReadSeeker interfaces and only authenticates and decrypts the
+// segments touched by a read. NewEncrypterContext and NewDecrypterContext abort the
+// streams, the key derivation and the spooling once their context is done. WithConcurrency
+// processes the segments with a pool of workers in parallel, while preserving their order.
+//
+// ParseHeader returns the parameters stored in the header of a ciphertext without requiring
+// a password. Verify authenticates a ciphertext without releasing any plaintext and without
+// spooling it.
 package iocrypter
@@ -0,0 +1,182 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// spoolBlockSize is the size of the blocks in which an encryptedSpool encrypts and authenticates the
+// data written to it.
+const spoolBlockSize = 64 * 1024
+
+// ErrSpoolNotSequential indicates that an encrypted Spool has been accessed in another way than
+// writing it sequentially, seeking back to its start and reading it sequentially.
+var ErrSpoolNotSequential = errors.New("encrypted spool only supports sequential access")
+
+// encryptedSpool is a Spool that encrypts and authenticates the data written to an underlying Spool
+// in blocks, with an ephemeral key that only exists in memory. Every block is authenticated when it
+// is read back, so that modifications of the underlying storage between the authentication of the
+// ciphertext and its decryption are detected.
+//
+// It only supports writing the data sequentially, seeking back to its start and reading the data
+// sequentially, which is how the decrypter uses its Spool.
+type encryptedSpool struct {
+	spool       Spool
+	cipher      segmentCipher
+	plain       []byte
+	sealed      []byte
+	counter     uint64
+	blocks      uint64
+	finalLength int
+	position    int
+	reading     bool
+	err         error
+}
+
+// newEncryptedSpool returns a new encryptedSpool that stores its data in the given Spool, encrypted
+// with a key read from the given source of randomness.
+func newEncryptedSpool(spool Spool, random io.Reader) (*encryptedSpool, error) {
+	key := make([]byte, aesKeySize)
+	if _, err := io.ReadFull(random, key); err != nil {
+		return nil, fmt.Errorf("failed to generate random spool key: %w", err)
+	}
+	iv := make([]byte, blockSize)
+	if _, err := io.ReadFull(random, iv); err != nil {
+		return nil, fmt.Errorf("failed to generate random spool iv: %w", err)
+	}
+	blockCipher, err := newSegmentCipher(CipherSuiteAES256GCM, key, nil, iv, nil, spoolBlockSize)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedSpool{
+		spool:  spool,
+		cipher: blockCipher,
+		plain:  make([]byte, 0, spoolBlockSize),
+		sealed: make([]byte, 0, spoolBlockSize+blockCipher.overhead()),
+	}, nil
+}
+
+// Write satisfies the io.Writer interface for the encryptedSpool type. A buffered block is only
+// sealed once more data is written, since only then it is known not to be the final block.
+func (e *encryptedSpool) Write(p []byte) (int, error) {
+	if e.cipher == nil {
+		return 0, ErrSpoolClosed
+	}
+	if e.reading {
+		return 0, fmt.Errorf("%w: writing after seeking is not supported", ErrSpoolNotSequential)
+	}
+	written := 0
+	for len(p) > 0 {
+		if len(e.plain) == cap(e.plain) {
+			if err := e.writeBlock(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.plain[len(e.plain):cap(e.plain)], p)
+		e.plain = e.plain[:len(e.plain)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Seek satisfies the io.Seeker interface for the encryptedSpool type. Only seeking to the start of
+// the Spool is supported. The first seek seals the buffered data as the final block.
+func (e *encryptedSpool) Seek(offset int64, whence int) (int64, error) {
+	if e.cipher == nil {
+		return 0, ErrSpoolClosed
+	}
+	if offset != 0 || whence != io.SeekStart {
+		return 0, fmt.Errorf("%w: seeking is only supported to the start", ErrSpoolNotSequential)
+	}
+	if !e.reading {
+		if err := e.writeBlock(true); err != nil {
+			return 0, err
+		}
+		e.blocks = e.counter
+		e.reading = true
+	}
+	if _, err := e.spool.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	e.counter = 0
+	e.plain = e.plain[:0]
+	e.position = 0
+	e.err = nil
+	return 0, nil
+}
+
+// Read satisfies the io.Reader interface for the encryptedSpool type. Each block is authenticated
+// before any of its data is returned.
+func (e *encryptedSpool) Read(p []byte) (int, error) {
+	if e.cipher == nil {
+		return 0, ErrSpoolClosed
+	}
+	if !e.reading {
+		return 0, fmt.Errorf("%w: reading before seeking to the start is not supported", ErrSpoolNotSequential)
+	}
+	if e.err != nil {
+		return 0, e.err
+	}
+	for e.position == len(e.plain) {
+		if e.counter == e.blocks {
+			return 0, io.EOF
+		}
+		if e.err = e.readBlock(); e.err != nil {
+			return 0, e.err
+		}
+	}
+	n := copy(p, e.plain[e.position:])
+	e.position += n
+	return n, nil
+}
+
+// Close satisfies the io.Closer interface for the encryptedSpool type. It releases the key and closes
+// the underlying Spool.
+func (e *encryptedSpool) Close() error {
+	e.cipher = nil
+	e.plain = nil
+	return e.spool.Close()
+}
+
+// writeBlock seals the buffered data as a block and writes it to the underlying Spool.
+func (e *encryptedSpool) writeBlock(final bool) error {
+	e.sealed = e.cipher.seal(e.sealed[:0], e.plain, e.counter, final)
+	if _, err := e.spool.Write(e.sealed); err != nil {
+		return fmt.Errorf("failed to write to spool: %w", err)
+	}
+	if final {
+		e.finalLength = len(e.sealed)
+	}
+	e.plain = e.plain[:0]
+	e.counter++
+	return nil
+}
+
+// readBlock reads the next block from the underlying Spool and authenticates and decrypts it.
+func (e *encryptedSpool) readBlock() error {
+	final := e.counter == e.blocks-1
+	length := cap(e.sealed)
+	if final {
+		length = e.finalLength
+	}
+	if _, err := io.ReadFull(e.spool, e.sealed[:length]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("spool has been truncated: %w", ErrMissingData)
+		}
+		return fmt.Errorf("failed to read from spool: %w", err)
+	}
+	plain, err := e.cipher.open(e.plain[:0], e.sealed[:length], e.counter, final)
+	if err != nil {
+		return fmt.Errorf("spool has been modified: %w", err)
+	}
+	e.plain = plain
+	e.position = 0
+	e.counter++
+	return nil
+}
@@ -0,0 +1,332 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestEncryptedSpool(t *testing.T) {
+	data := make([]byte, 3*spoolBlockSize+42)
+	if _, err := io.ReadFull(rand.Reader, data); err != nil {
+		t.Fatalf("failed to generate data: %s", err)
+	}
+	overhead := newTestEncryptedSpool(t, &memorySpool{}).cipher.overhead()
+
+	for _, size := range []int{0, 1, spoolBlockSize, spoolBlockSize + 1, len(data)} {
+		t.Run(fmt.Sprintf("spooled data of %d bytes is returned", size), func(t *testing.T) {
+			underlying := &memorySpool{}
+			spool := writeTestEncryptedSpool(t, underlying, data[:size])
+			spooled, err := io.ReadAll(spool)
+			if err != nil {
+				t.Fatalf("failed to read from spool: %s", err)
+			}
+			if !bytes.Equal(data[:size], spooled) {
+				t.Error("written and spooled data do not match")
+			}
+			blocks := size/spoolBlockSize + 1
+			if size > 0 && size%spoolBlockSize == 0 {
+				blocks--
+			}
+			if len(underlying.data) != size+blocks*overhead {
+				t.Errorf("expected underlying spool to hold %d bytes, got %d", size+blocks*overhead,
+					len(underlying.data))
+			}
+			// A single byte of plaintext may appear in the ciphertext by chance
+			if size > 1 && bytes.Contains(underlying.data, data[:size]) {
+				t.Error("expected underlying spool to hold encrypted data")
+			}
+		})
+	}
+	t.Run("spooled data can be read repeatedly", func(t *testing.T) {
+		spool := writeTestEncryptedSpool(t, &memorySpool{}, data)
+		if _, err := io.ReadAll(spool); err != nil {
+			t.Fatalf("failed to read from spool: %s", err)
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			t.Fatalf("failed to seek to start of spool: %s", err)
+		}
+		spooled, err := io.ReadAll(spool)
+		if err != nil {
+			t.Fatalf("failed to read from spool: %s", err)
+		}
+		if !bytes.Equal(data, spooled) {
+			t.Error("written and spooled data do not match")
+		}
+	})
+
+	tampering := []struct {
+		name    string
+		tamper  func(data []byte) []byte
+		wantErr error
+	}{
+		{"modified block", func(data []byte) []byte {
+			data[spoolBlockSize+100] ^= 0xff
+			return data
+		}, ErrFailedAuthentication},
+		{"modified final block", func(data []byte) []byte {
+			data[len(data)-1] ^= 0xff
+			return data
+		}, ErrFailedAuthentication},
+		{"reordered blocks", func(data []byte) []byte {
+			sealedSize := spoolBlockSize + overhead
+			reordered := slices.Concat(data[sealedSize:2*sealedSize], data[:sealedSize], data[2*sealedSize:])
+			return reordered
+		}, ErrFailedAuthentication},
+		{"truncated spool", func(data []byte) []byte {
+			return data[:len(data)-100]
+		}, ErrMissingData},
+	}
+	for _, tt := range tampering {
+		t.Run("reading spool with "+tt.name+" should fail", func(t *testing.T) {
+			underlying := &memorySpool{}
+			spool := writeTestEncryptedSpool(t, underlying, data)
+			underlying.data = tt.tamper(bytes.Clone(underlying.data))
+			_, err := io.ReadAll(spool)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected error to be %s, got %s", tt.wantErr, err)
+			}
+			if _, err = spool.Read(make([]byte, 1)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected subsequent read error to be %s, got %s", tt.wantErr, err)
+			}
+		})
+	}
+
+	t.Run("reading before seeking should fail", func(t *testing.T) {
+		spool := newTestEncryptedSpool(t, &memorySpool{})
+		if _, err := spool.Read(make([]byte, 1)); !errors.Is(err, ErrSpoolNotSequential) {
+			t.Errorf("expected error to be %s, got %s", ErrSpoolNotSequential, err)
+		}
+	})
+	t.Run("writing after seeking should fail", func(t *testing.T) {
+		spool := writeTestEncryptedSpool(t, &memorySpool{}, data)
+		if _, err := spool.Write(data); !errors.Is(err, ErrSpoolNotSequential) {
+			t.Errorf("expected error to be %s, got %s", ErrSpoolNotSequential, err)
+		}
+	})
+	t.Run("seeking to other offsets than the start should fail", func(t *testing.T) {
+		spool := writeTestEncryptedSpool(t, &memorySpool{}, data)
+		if _, err := spool.Seek(1, io.SeekStart); !errors.Is(err, ErrSpoolNotSequential) {
+			t.Errorf("expected error to be %s, got %s", ErrSpoolNotSequential, err)
+		}
+		if _, err := spool.Seek(0, io.SeekEnd); !errors.Is(err, ErrSpoolNotSequential) {
+			t.Errorf("expected error to be %s, got %s", ErrSpoolNotSequential, err)
+		}
+	})
+	t.Run("using closed spool should fail", func(t *testing.T) {
+		underlying := &memorySpool{}
+		spool := writeTestEncryptedSpool(t, underlying, data)
+		if err := spool.Close(); err != nil {
+			t.Fatalf("failed to close spool: %s", err)
+		}
+		if !underlying.closed {
+			t.Error("expected underlying spool to be closed")
+		}
+		if _, err := spool.Read(make([]byte, 1)); !errors.Is(err, ErrSpoolClosed) {
+			t.Errorf("expected error to be %s, got %s", ErrSpoolClosed, err)
+		}
+		if _, err := spool.Write(data); !errors.Is(err, ErrSpoolClosed) {
+			t.Errorf("expected error to be %s, got %s", ErrSpoolClosed, err)
+		}
+		if _, err := spool.Seek(0, io.SeekStart); !errors.Is(err, ErrSpoolClosed) {
+			t.Errorf("expected error to be %s, got %s", ErrSpoolClosed, err)
+		}
+	})
+	t.Run("creating spool with broken random reader should fail", func(t *testing.T) {
+		for _, failAfter := range []int{0, aesKeySize} {
+			random := io.MultiReader(bytes.NewReader(make([]byte, failAfter)), &failReadWriter{})
+			if _, err := newEncryptedSpool(&memorySpool{}, random); err == nil {
+				t.Errorf("expected spool creation to fail after %d random bytes", failAfter)
+			}
+		}
+	})
+}
+
+func TestNewDecrypter_EncryptedSpool(t *testing.T) {
+	plaintext := make([]byte, 2*spoolBlockSize+1)
+	legacy, err := newLegacyEncrypter(bytes.NewReader(plaintext), testPassword)
+	if err != nil {
+		t.Fatalf("failed to create legacy encrypter: %s", err)
+	}
+	ciphertext, err := io.ReadAll(legacy)
+	if err != nil {
+		t.Fatalf("failed to encrypt plaintext: %s", err)
+	}
+
+	// Derive the keys only once, so that the decrypters can be created repeatedly
+	aesKey, hmacKey, err := passwordKeys(testPassword)(readTestHeader(t, ciphertext))
+	if err != nil {
+		t.Fatalf("failed to derive keys: %s", err)
+	}
+	keys := keyFunc(func(*header) ([]byte, []byte, error) {
+		return aesKey, hmacKey, nil
+	})
+	decrypt := func(t *testing.T, opts ...Option) (io.ReadCloser, error) {
+		t.Helper()
+		o, err := newOptions(opts...)
+		if err != nil {
+			t.Fatalf("failed to create options: %s", err)
+		}
+		return newDecrypter(bytes.NewReader(ciphertext), keys, o)
+	}
+
+	spools := []struct {
+		name   string
+		option Option
+	}{
+		{"file spool", nil},
+		{"hybrid spool", WithHybridSpool(int64(chunkSize))},
+	}
+	for _, tt := range spools {
+		t.Run("decryption with encrypted "+tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			decrypter, err := decrypt(t, WithTempDir(dir), WithEncryptedSpool(), tt.option)
+			if err != nil {
+				t.Fatalf("failed to create decrypter: %s", err)
+			}
+			spooled := readTestSpoolFile(t, dir)
+			if bytes.Contains(spooled, ciphertext[len(readTestHeader(t, ciphertext).raw):][:chunkSize]) {
+				t.Error("expected spool file to be encrypted")
+			}
+			decrypted, err := io.ReadAll(decrypter)
+			if err != nil {
+				t.Fatalf("failed to decrypt ciphertext: %s", err)
+			}
+			if !bytes.Equal(plaintext, decrypted) {
+				t.Error("plaintext and decrypted data do not match")
+			}
+			if err = decrypter.Close(); err != nil {
+				t.Fatalf("failed to close decrypter: %s", err)
+			}
+			assertEmptyDir(t, dir)
+		})
+		t.Run("tampering with encrypted "+tt.name+" after authentication is detected", func(t *testing.T) {
+			dir := t.TempDir()
+			decrypter, err := decrypt(t, WithTempDir(dir), WithEncryptedSpool(), tt.option)
+			if err != nil {
+				t.Fatalf("failed to create decrypter: %s", err)
+			}
+			defer func() {
+				_ = decrypter.Close()
+			}()
+			tamperTestSpoolFile(t, dir, spoolBlockSize+100)
+			if _, err = io.ReadAll(decrypter); !errors.Is(err, ErrFailedAuthentication) {
+				t.Errorf("expected error to be %s, got %s", ErrFailedAuthentication, err)
+			}
+		})
+	}
+	t.Run("decryption with encrypted custom spool", func(t *testing.T) {
+		underlying := &memorySpool{}
+		decrypter, err := decrypt(t, WithEncryptedSpool(), WithSpool(func() (Spool, error) {
+			return underlying, nil
+		}))
+		if err != nil {
+			t.Fatalf("failed to create decrypter: %s", err)
+		}
+		underlying.data[0] ^= 0xff
+		if _, err = io.ReadAll(decrypter); !errors.Is(err, ErrFailedAuthentication) {
+			t.Errorf("expected error to be %s, got %s", ErrFailedAuthentication, err)
+		}
+	})
+	t.Run("memory spool is not encrypted", func(t *testing.T) {
+		o, err := newOptions(WithEncryptedSpool(), WithMemorySpool())
+		if err != nil {
+			t.Fatalf("failed to create options: %s", err)
+		}
+		spool, err := o.newSpool()
+		if err != nil {
+			t.Fatalf("failed to create spool: %s", err)
+		}
+		if _, ok := spool.(*memorySpool); !ok {
+			t.Errorf("expected spool to be a memory spool, got %T", spool)
+		}
+	})
+	t.Run("decryption with encrypted spool and broken random reader should fail", func(t *testing.T) {
+		dir := t.TempDir()
+		_, err := decrypt(t, WithTempDir(dir), WithEncryptedSpool(), WithRandReader(&failReadWriter{}))
+		if err == nil {
+			t.Error("expected decryption to fail with broken random reader")
+		}
+		assertEmptyDir(t, dir)
+	})
+}
+
+// newTestEncryptedSpool returns a new encryptedSpool that stores its data in the given Spool.
+func newTestEncryptedSpool(t *testing.T, spool Spool) *encryptedSpool {
+	t.Helper()
+	encrypted, err := newEncryptedSpool(spool, rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to create encrypted spool: %s", err)
+	}
+	return encrypted
+}
+
+// writeTestEncryptedSpool writes the given data in uneven chunks to a new encryptedSpool, that
+// stores its data in the given Spool, and seeks back to its start.
+func writeTestEncryptedSpool(t *testing.T, spool Spool, data []byte) *encryptedSpool {
+	t.Helper()
+	encrypted := newTestEncryptedSpool(t, spool)
+	for chunk := range slices.Chunk(data, chunkSize-hmacSize) {
+		if _, err := encrypted.Write(chunk); err != nil {
+			t.Fatalf("failed to write to spool: %s", err)
+		}
+	}
+	if _, err := encrypted.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("failed to seek to start of spool: %s", err)
+	}
+	return encrypted
+}
+
+// testSpoolFile returns the path of the single spool file in the given directory.
+func testSpoolFile(t *testing.T, dir string) string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read temporary directory: %s", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected a single spool file, got %d entries", len(entries))
+	}
+	return filepath.Join(dir, entries[0].Name())
+}
+
+// readTestSpoolFile returns the content of the single spool file in the given directory.
+func readTestSpoolFile(t *testing.T, dir string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(testSpoolFile(t, dir))
+	if err != nil {
+		t.Fatalf("failed to read spool file: %s", err)
+	}
+	return data
+}
+
+// tamperTestSpoolFile flips the byte at the given offset of the single spool file in the given
+// directory.
+func tamperTestSpoolFile(t *testing.T, dir string, offset int64) {
+	t.Helper()
+	file, err := os.OpenFile(testSpoolFile(t, dir), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("failed to open spool file: %s", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	value := make([]byte, 1)
+	if _, err = file.ReadAt(value, offset); err != nil {
+		t.Fatalf("failed to read spool file: %s", err)
+	}
+	value[0] ^= 0xff
+	if _, err = file.WriteAt(value, offset); err != nil {
+		t.Fatalf("failed to write spool file: %s", err)
+	}
+}
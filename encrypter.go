@@ -5,56 +5,150 @@
 package iocrypter
 
 import (
-	"bytes"
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/hmac"
-	"crypto/rand"
+	"crypto/ecdh"
 	"errors"
 	"fmt"
 	"io"
-
-	wa "github.com/wneessen/argon2"
 )
 
 // ErrPassPhraseEmpty is an error indicating that the provided passphrase is empty and must be non-empty.
 var ErrPassPhraseEmpty = errors.New("passphrase must not be empty")
 
-func NewEncrypter(r io.Reader, pass []byte) (io.Reader, error) {
+// NewEncrypter returns an io.Reader that reads plaintext from r and returns the ciphertext encrypted
+// with a key derived from the given passphrase. Without any Option, the default Argon2 settings, cipher
+// suite and segment size are used.
+//
+// Since the keys are derived from the passphrase directly, the passphrase of the ciphertext cannot be
+// changed with Rekey. Use NewEncrypterWithKeySlots with a PasswordKeySlot if that is required.
+func NewEncrypter(r io.Reader, pass []byte, opts ...Option) (io.Reader, error) {
 	if len(pass) == 0 {
 		return nil, ErrPassPhraseEmpty
 	}
-	return NewEncrypterWithSettings(r, pass, defaultArgon2Memory, defaultArgon2Time, defaultArgon2Threads)
+	return newPasswordEncrypter(r, pass, opts...)
 }
 
+// NewEncrypterWithSettings returns an io.Reader that reads plaintext from r and returns the ciphertext
+// encrypted with a key derived from the given password, using the given Argon2 settings. The ciphertext
+// is written in the segmented stream format, in which the plaintext is split into segments of equal
+// size that are authenticated independently.
+//
+// It is equivalent to calling NewEncrypter with the WithArgon2 Option.
 func NewEncrypterWithSettings(r io.Reader, password []byte, memory, time uint32, threads uint8) (io.Reader, error) {
-	settings := wa.NewSettings(memory, time, threads, saltSize, aesKeySize+hmacSize)
-	settingsSerialized := settings.Serialize()
-	salt := make([]byte, settings.SaltLength)
-	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
-		return nil, fmt.Errorf("failed to generate random salt: %w", err)
+	return newPasswordEncrypter(r, password, WithArgon2(memory, time, threads))
+}
+
+// NewEncrypterWithCipherSuite returns an io.Reader that reads plaintext from r and returns the
+// ciphertext encrypted with the given cipher suite and a key derived from the given passphrase, using
+// the default Argon2 settings. The cipher suite is stored in the header of the ciphertext.
+//
+// It is equivalent to calling NewEncrypter with the WithCipherSuite Option.
+func NewEncrypterWithCipherSuite(r io.Reader, pass []byte, suite CipherSuite) (io.Reader, error) {
+	return NewEncrypter(r, pass, WithCipherSuite(suite))
+}
+
+// NewEncrypterWithKey returns an io.Reader that reads plaintext from r and returns the ciphertext
+// encrypted with keys derived from the given raw master key. Instead of a password based key
+// derivation, the keys are derived using HKDF with a random salt for each stream, which makes it
+// suitable to encrypt many objects with a key that is managed by a KMS. The master key must be
+// MasterKeySize bytes long. The ciphertext can only be decrypted with NewDecrypterWithKey.
+func NewEncrypterWithKey(r io.Reader, key []byte, opts ...Option) (io.Reader, error) {
+	if len(key) != MasterKeySize {
+		return nil, ErrInvalidKeySize
 	}
-	aesKey, hmacKey := DeriveKeys(password, salt, settings)
+	o, err := newOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return newEncrypter(r, &header{suite: o.suite, kdf: keyDerivationHKDF}, masterKeys(key), o)
+}
 
-	iv := make([]byte, blockSize)
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
-		return nil, fmt.Errorf("failed to generate random iv: %w", err)
+// NewEncrypterForRecipients returns an io.Reader that reads plaintext from r and returns the ciphertext
+// encrypted with a random file key. The file key is wrapped for each of the given X25519 public keys
+// and stored in the header, so that the ciphertext can be decrypted by any of the recipients using
+// NewDecrypterWithIdentity, while the encrypter never holds a secret that is able to decrypt it. Up to
+// 255 recipients are supported.
+//
+// It is equivalent to calling NewEncrypterWithKeySlots with an X25519KeySlot for each recipient. Since
+// the ciphertext has no password key slot, it cannot be rekeyed with Rekey.
+func NewEncrypterForRecipients(r io.Reader, recipients []*ecdh.PublicKey, opts ...Option) (io.Reader, error) {
+	slots := make([]KeySlot, len(recipients))
+	for i, recipient := range recipients {
+		slots[i] = X25519KeySlot(recipient)
 	}
+	return NewEncrypterWithKeySlots(r, slots, opts...)
+}
 
-	header := make([]byte, len(settingsSerialized)+len(salt)+len(iv))
-	copy(header, settingsSerialized)
-	copy(header[len(settingsSerialized):], salt)
-	copy(header[len(settingsSerialized)+len(salt):], iv)
-	headerReader := bytes.NewReader(header)
+// NewEncrypterWithKeySlots returns an io.Reader that reads plaintext from r and returns the ciphertext
+// encrypted with a random file key. The file key is wrapped separately for each of the given KeySlots,
+// which may mix passwords and X25519 public keys, so that any one of their secrets decrypts the
+// ciphertext. Password key slots are decrypted with NewDecrypter, X25519 key slots with
+// NewDecrypterWithIdentity. Up to 255 key slots are supported.
+func NewEncrypterWithKeySlots(r io.Reader, slots []KeySlot, opts ...Option) (io.Reader, error) {
+	o, err := newOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	hdr, fileKey, err := keySlotHeader(slots, o)
+	if err != nil {
+		return nil, err
+	}
+	return newEncrypter(r, hdr, fileKeys(fileKey), o)
+}
 
-	block, err := aes.NewCipher(aesKey)
+// newPasswordEncrypter returns an io.Reader that reads plaintext from r and returns the ciphertext
+// encrypted with a key derived from the given password, configured by the given Options.
+func newPasswordEncrypter(r io.Reader, password []byte, opts ...Option) (io.Reader, error) {
+	o, err := newOptions(opts...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create AES block cipher: %w", err)
+		return nil, err
 	}
-	streamReader := &cipher.StreamReader{R: r, S: cipher.NewCTR(block, iv)}
+	return newEncrypter(r, passwordHeader(o.kdf, o.suite), passwordKeys(password), o)
+}
 
-	hasher := hmac.New(hashFunc, hmacKey)
-	hmacReadWriter := NewHashReadWriter(hasher)
+// newEncrypter returns an io.Reader that reads plaintext from r and returns the ciphertext in the
+// segmented stream format, encrypted with the cipher suite given in the header and the keys derived
+// with the given keyFunc.
+func newEncrypter(r io.Reader, hdr *header, keys keyFunc, o *options) (io.Reader, error) {
+	segCipher, err := prepareEncryption(hdr, keys, o)
+	if err != nil {
+		return nil, err
+	}
+	return newStreamEncrypter(r, segCipher, hdr.raw, int(hdr.segmentSize), o.concurrency), nil
+}
+
+// passwordHeader returns a header for a stream with keys derived from a password using the given
+// password based key derivation.
+func passwordHeader(params passwordKDF, suite CipherSuite) *header {
+	return &header{suite: suite, kdf: params.kind(), params: params}
+}
 
-	return io.MultiReader(io.TeeReader(io.MultiReader(headerReader, streamReader), hmacReadWriter), hmacReadWriter), nil
+// prepareEncryption completes the given header, which needs to hold the cipher suite and the key
+// derivation method, with a random salt and IV and the configured segment size, and serializes it.
+// It derives the keys using the given keyFunc and returns the segmentCipher for the cipher suite of
+// the header.
+func prepareEncryption(hdr *header, keys keyFunc, o *options) (segmentCipher, error) {
+	if !hdr.suite.supported() {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedCipherSuite, byte(hdr.suite))
+	}
+	saltLength := hkdfSaltSize
+	if hdr.params != nil {
+		saltLength = int(hdr.params.saltLength())
+	}
+	hdr.salt = make([]byte, saltLength)
+	if _, err := io.ReadFull(o.random(), hdr.salt); err != nil {
+		return nil, fmt.Errorf("failed to generate random salt: %w", err)
+	}
+	hdr.iv = make([]byte, blockSize)
+	if _, err := io.ReadFull(o.random(), hdr.iv); err != nil {
+		return nil, fmt.Errorf("failed to generate random iv: %w", err)
+	}
+	hdr.version = currentFormatVersion
+	hdr.segmentSize = o.segmentSize
+	hdr.marshal()
+
+	encKey, hmacKey, err := keys(hdr)
+	if err != nil {
+		return nil, err
+	}
+	return newSegmentCipher(hdr.suite, encKey, hmacKey, hdr.iv, hdr.ad, int(hdr.segmentSize))
 }
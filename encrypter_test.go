@@ -23,6 +23,9 @@ var (
 	// combined AES/HMAC key size.
 	testSettings = wa.NewSettings(defaultArgon2Memory, defaultArgon2Time, defaultArgon2Threads,
 		saltSize, aesKeySize+hmacSize)
+
+	// testKey is a raw master key used for testing purposes.
+	testKey = []byte("0123456789abcdefghijklmnopqrstuv")
 )
 
 func TestNewEncrypter(t *testing.T) {
@@ -60,6 +63,25 @@ func TestNewEncrypterWithSettings(t *testing.T) {
 			t.Fatal("encrypter is nil")
 		}
 	})
+	t.Run("ciphertext starts with magic header and format version", func(t *testing.T) {
+		buffer := bytes.NewBufferString("This is a test")
+		encrypter, err := NewEncrypterWithSettings(buffer, testPassword, defaultArgon2Memory,
+			defaultArgon2Time, defaultArgon2Threads)
+		if err != nil {
+			t.Fatalf("failed to create encrypter: %s", err)
+		}
+		ciphertext, err := io.ReadAll(encrypter)
+		if err != nil {
+			t.Fatalf("failed to encrypt plaintext: %s", err)
+		}
+		if !bytes.HasPrefix(ciphertext, headerMagic) {
+			t.Errorf("expected ciphertext to start with magic header %q", headerMagic)
+		}
+		if ciphertext[len(headerMagic)] != currentFormatVersion {
+			t.Errorf("expected format version to be %d, got %d", currentFormatVersion,
+				ciphertext[len(headerMagic)])
+		}
+	})
 	t.Run("encrypter creation fails with broken random reader", func(t *testing.T) {
 		defaultRandReader := rand.Reader
 		t.Cleanup(func() { rand.Reader = defaultRandReader })
@@ -145,3 +167,39 @@ func (r *failReadWriter) Read(p []byte) (int, error) {
 func (r *failReadWriter) Write([]byte) (int, error) {
 	return 0, errors.New("intentionally failing")
 }
+
+func TestNewEncrypterWithKey(t *testing.T) {
+	t.Run("normal encrypter creation", func(t *testing.T) {
+		encrypter, err := NewEncrypterWithKey(bytes.NewBufferString("This is a test"), testKey)
+		if err != nil {
+			t.Fatalf("failed to create encrypter: %s", err)
+		}
+		ciphertext, err := io.ReadAll(encrypter)
+		if err != nil {
+			t.Fatalf("failed to encrypt plaintext: %s", err)
+		}
+		if kdf := keyDerivation(ciphertext[len(headerMagic)+2]); kdf != keyDerivationHKDF {
+			t.Errorf("expected key derivation in header to be %d, got %d", keyDerivationHKDF, kdf)
+		}
+	})
+	t.Run("encrypter creation with invalid key size should fail", func(t *testing.T) {
+		_, err := NewEncrypterWithKey(bytes.NewBuffer(nil), testKey[:MasterKeySize-1])
+		if !errors.Is(err, ErrInvalidKeySize) {
+			t.Errorf("expected error to be %s, got %s", ErrInvalidKeySize, err)
+		}
+	})
+	t.Run("encrypter creation fails with broken random reader", func(t *testing.T) {
+		defaultRandReader := rand.Reader
+		t.Cleanup(func() { rand.Reader = defaultRandReader })
+		rand.Reader = &failReadWriter{failOnRead: 0}
+
+		_, err := NewEncrypterWithKey(bytes.NewBuffer(nil), testKey)
+		if err == nil {
+			t.Fatal("expected encrypter creation to fail with broken random reader")
+		}
+		expErr := "failed to generate random salt"
+		if !strings.Contains(err.Error(), expErr) {
+			t.Errorf("expected error to contain %s, got %s", expErr, err)
+		}
+	})
+}
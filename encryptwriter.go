@@ -0,0 +1,144 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrWriteAfterClose indicates that writing to a writer is not allowed after it has been closed.
+var ErrWriteAfterClose = errors.New("writing to writer after close is not allowed")
+
+// encryptWriter is an io.WriteCloser that encrypts the plaintext written to it and writes the
+// stream header followed by the sealed segments to an underlying io.Writer. Up to one segment per
+// worker is buffered and sealed in parallel.
+type encryptWriter struct {
+	writer      io.Writer
+	cipher      segmentCipher
+	header      []byte
+	segmentSize int
+	workers     int
+	plain       []byte
+	sealed      [][]byte
+	counter     uint64
+	closed      bool
+	err         error
+}
+
+// NewEncryptWriter returns an io.WriteCloser that encrypts the plaintext written to it with a key
+// derived from the given passphrase and writes the ciphertext to w. It accepts the same Options as
+// NewEncrypter and the ciphertext is identical in format to the one returned by NewEncrypter, so its
+// passphrase cannot be changed with Rekey either.
+//
+// Writes are buffered until a full segment, or with the WithConcurrency Option one segment per
+// worker, is available. The final segment is only written when
+// Close is called, so it is the caller's responsibility to call Close once all plaintext has been
+// written. Close does not close the underlying io.Writer.
+func NewEncryptWriter(w io.Writer, pass []byte, opts ...Option) (io.WriteCloser, error) {
+	if len(pass) == 0 {
+		return nil, ErrPassPhraseEmpty
+	}
+	o, err := newOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	hdr := passwordHeader(o.kdf, o.suite)
+	segCipher, err := prepareEncryption(hdr, passwordKeys(pass), o)
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptWriter(w, segCipher, hdr.raw, int(hdr.segmentSize), o.concurrency), nil
+}
+
+// newEncryptWriter returns a new encryptWriter that will seal the plaintext written to it in segments
+// of segmentSize bytes using the given segmentCipher and write them, prefixed by the header, to w. Up
+// to the given number of workers seal segments in parallel.
+func newEncryptWriter(w io.Writer, segCipher segmentCipher, header []byte, segmentSize, workers int) *encryptWriter {
+	workers = max(workers, 1)
+	writer := &encryptWriter{
+		writer:      w,
+		cipher:      segCipher,
+		header:      header,
+		segmentSize: segmentSize,
+		workers:     workers,
+		plain:       make([]byte, 0, segmentSize*workers),
+		sealed:      make([][]byte, workers),
+	}
+	for i := range workers {
+		writer.sealed[i] = make([]byte, 0, segmentSize+segCipher.overhead())
+	}
+	return writer
+}
+
+// Write satisfies the io.Writer interface for the encryptWriter type. The buffered segments are only
+// sealed once more plaintext is written, since only then the last of them is known not to be the
+// final segment.
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, ErrWriteAfterClose
+	}
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	written := 0
+	for len(p) > 0 {
+		if len(e.plain) == cap(e.plain) {
+			if e.err = e.writeSegments(false); e.err != nil {
+				return written, e.err
+			}
+		}
+		n := copy(e.plain[len(e.plain):cap(e.plain)], p)
+		e.plain = e.plain[:len(e.plain)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close satisfies the io.Closer interface for the encryptWriter type. It seals and writes the
+// buffered segments, including the final segment. It does not close the underlying io.Writer.
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	if e.err != nil {
+		return e.err
+	}
+	return e.writeSegments(true)
+}
+
+// writeSegments seals the buffered plaintext in segments in parallel and writes them to the underlying
+// writer. If final is set, the last segment is sealed as the final segment of the stream. The header
+// is written in front of the first segment.
+func (e *encryptWriter) writeSegments(final bool) error {
+	if e.header != nil {
+		if _, err := e.writer.Write(e.header); err != nil {
+			return fmt.Errorf("failed to write header: %w", err)
+		}
+		e.header = nil
+	}
+
+	// The final segment of an empty plaintext is an empty segment
+	count := max((len(e.plain)+e.segmentSize-1)/e.segmentSize, 1)
+	sealed := make([][]byte, count)
+	_, _ = parallelize(count, e.workers, func(i int) error {
+		start := i * e.segmentSize
+		end := min(start+e.segmentSize, len(e.plain))
+		sealed[i] = e.cipher.seal(e.sealed[i][:0], e.plain[start:end], e.counter+uint64(i), final && i == count-1)
+		return nil
+	})
+	for _, segment := range sealed {
+		if _, err := e.writer.Write(segment); err != nil {
+			return fmt.Errorf("failed to write ciphertext: %w", err)
+		}
+	}
+	e.plain = e.plain[:0]
+	e.counter += uint64(count)
+	return nil
+}
@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestNewEncryptWriter(t *testing.T) {
+	sizes := []int{0, 1, defaultSegmentSize, defaultSegmentSize + 1, 2*defaultSegmentSize + 99}
+	for _, size := range sizes {
+		t.Run(fmt.Sprintf("encrypt/decrypt round trip with %d bytes", size), func(t *testing.T) {
+			plaintext := make([]byte, size)
+			if _, err := io.ReadFull(rand.Reader, plaintext); err != nil {
+				t.Fatalf("failed to generate plaintext: %s", err)
+			}
+			buffer := bytes.NewBuffer(nil)
+			writer, err := NewEncryptWriter(buffer, testPassword)
+			if err != nil {
+				t.Fatalf("failed to create encrypt writer: %s", err)
+			}
+			// Write in uneven chunks to cross segment boundaries within single writes
+			for data := plaintext; len(data) > 0; {
+				n := min(len(data), 4099)
+				if _, err = writer.Write(data[:n]); err != nil {
+					t.Fatalf("failed to write plaintext: %s", err)
+				}
+				data = data[n:]
+			}
+			if err = writer.Close(); err != nil {
+				t.Fatalf("failed to close encrypt writer: %s", err)
+			}
+
+			decrypter, err := NewDecrypter(buffer, testPassword)
+			if err != nil {
+				t.Fatalf("failed to create decrypter: %s", err)
+			}
+			decrypted, err := io.ReadAll(decrypter)
+			if err != nil {
+				t.Fatalf("failed to decrypt ciphertext: %s", err)
+			}
+			if !bytes.Equal(plaintext, decrypted) {
+				t.Error("plaintext and decrypted data do not match")
+			}
+		})
+	}
+	t.Run("encrypt writer creation with nil passphrase should fail", func(t *testing.T) {
+		_, err := NewEncryptWriter(bytes.NewBuffer(nil), nil)
+		if !errors.Is(err, ErrPassPhraseEmpty) {
+			t.Errorf("expected error to be %s, got %s", ErrPassPhraseEmpty, err)
+		}
+	})
+	t.Run("encrypt writer creation fails with broken random reader", func(t *testing.T) {
+		defaultRandReader := rand.Reader
+		t.Cleanup(func() { rand.Reader = defaultRandReader })
+		rand.Reader = &failReadWriter{failOnRead: 0}
+
+		if _, err := NewEncryptWriter(bytes.NewBuffer(nil), testPassword); err == nil {
+			t.Error("expected encrypt writer creation to fail with broken random reader")
+		}
+	})
+	t.Run("write after close should fail", func(t *testing.T) {
+		writer, err := NewEncryptWriter(bytes.NewBuffer(nil), testPassword)
+		if err != nil {
+			t.Fatalf("failed to create encrypt writer: %s", err)
+		}
+		if err = writer.Close(); err != nil {
+			t.Fatalf("failed to close encrypt writer: %s", err)
+		}
+		if err = writer.Close(); err != nil {
+			t.Errorf("expected second close to succeed, got %s", err)
+		}
+		if _, err = writer.Write([]byte("test")); !errors.Is(err, ErrWriteAfterClose) {
+			t.Errorf("expected error to be %s, got %s", ErrWriteAfterClose, err)
+		}
+	})
+	t.Run("encrypt writer fails writing to broken writer", func(t *testing.T) {
+		writer, err := NewEncryptWriter(&failReadWriter{}, testPassword)
+		if err != nil {
+			t.Fatalf("failed to create encrypt writer: %s", err)
+		}
+		if _, err = writer.Write(make([]byte, defaultSegmentSize+1)); err == nil {
+			t.Error("expected write to fail with broken writer")
+		}
+		if _, err = writer.Write([]byte("test")); err == nil {
+			t.Error("expected subsequent write to fail with broken writer")
+		}
+		if err = writer.Close(); err == nil {
+			t.Error("expected close to fail with broken writer")
+		}
+	})
+}
@@ -9,6 +9,6 @@ go 1.25.0
 require (
 	github.com/wneessen/argon2 v0.0.4
 	golang.org/x/crypto v0.54.0
+	golang.org/x/sys v0.47.0
+	golang.org/x/term v0.45.0
 )
-
-require golang.org/x/sys v0.47.0 // indirect
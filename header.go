@@ -0,0 +1,301 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	wa "github.com/wneessen/argon2"
+)
+
+const (
+	// formatVersionLegacy identifies the legacy format, which has no magic header and is
+	// authenticated by a single HMAC at the end of the ciphertext.
+	formatVersionLegacy byte = 0
+
+	// formatVersion1 identifies the segmented stream format using AES-256-CTR and
+	// HMAC-SHA512 for each segment.
+	formatVersion1 byte = 1
+
+	// formatVersion2 identifies the segmented stream format with the cipher suite stored in
+	// the header.
+	formatVersion2 byte = 2
+
+	// formatVersion3 identifies the segmented stream format with the cipher suite and the key
+	// derivation method stored in the header.
+	formatVersion3 byte = 3
+
+	// currentFormatVersion is the format version that is written by the encrypter.
+	currentFormatVersion = formatVersion3
+)
+
+// keyDerivation identifies the method that is used to derive the encryption and HMAC keys of a
+// stream.
+type keyDerivation byte
+
+const (
+	// keyDerivationArgon2ID derives the keys from a password using Argon2id.
+	keyDerivationArgon2ID keyDerivation = iota + 1
+
+	// keyDerivationHKDF derives the keys from a raw master key using HKDF-SHA512.
+	keyDerivationHKDF
+
+	// keyDerivationKeySlots derives the keys from a random file key using HKDF-SHA512. The file
+	// key is stored in the header, wrapped in one or more key slots.
+	keyDerivationKeySlots
+
+	// keyDerivationArgon2I derives the keys from a password using Argon2i.
+	keyDerivationArgon2I
+
+	// keyDerivationScrypt derives the keys from a password using scrypt.
+	keyDerivationScrypt
+
+	// keyDerivationPBKDF2 derives the keys from a password using PBKDF2-HMAC-SHA256.
+	keyDerivationPBKDF2
+)
+
+// headerMagic is the magic string that prefixes every ciphertext, except for ciphertext in the
+// legacy format. It is followed by a single byte holding the format version.
+var headerMagic = []byte("IOCRYPT")
+
+// header holds the encryption parameters that are stored at the beginning of the ciphertext.
+type header struct {
+	version     byte
+	suite       CipherSuite
+	kdf         keyDerivation
+	params      passwordKDF
+	salt        []byte
+	iv          []byte
+	segmentSize uint32
+	slots       []keySlotData
+	mac         []byte
+
+	// raw holds the serialized header as read from or written to the ciphertext.
+	raw []byte
+
+	// ad holds the part of the serialized header that is authenticated together with each
+	// segment of the ciphertext. It equals raw, except for headers with key slots, for which
+	// the key slots and the header MAC are left out, so that the key slots can be rewritten
+	// without re-encrypting the ciphertext. Those are authenticated by the header MAC instead.
+	ad []byte
+}
+
+// marshal serializes the header into its binary representation and stores it in the raw and ad
+// fields of the header.
+func (h *header) marshal() []byte {
+	raw := make([]byte, 0, len(headerMagic)+3+wa.SerializedSettingsLength+len(h.salt)+len(h.iv)+
+		segmentSizeLength)
+	if h.version != formatVersionLegacy {
+		raw = append(raw, headerMagic...)
+		raw = append(raw, h.version)
+	}
+	if h.version >= formatVersion2 {
+		raw = append(raw, byte(h.suite))
+	}
+	if h.version >= formatVersion3 {
+		raw = append(raw, byte(h.kdf))
+	}
+	if h.params != nil {
+		raw = h.params.marshal(raw)
+	}
+	raw = append(raw, h.salt...)
+
+	suffix := h.iv
+	if h.version != formatVersionLegacy {
+		suffix = binary.BigEndian.AppendUint32(bytes.Clone(h.iv), h.segmentSize)
+	}
+	if h.kdf != keyDerivationKeySlots {
+		h.raw = append(raw, suffix...)
+		h.ad = h.raw
+		return h.raw
+	}
+
+	h.ad = append(bytes.Clone(raw), suffix...)
+	raw = append(raw, byte(len(h.slots)))
+	for _, slot := range h.slots {
+		raw = slot.marshal(raw)
+	}
+	raw = append(raw, suffix...)
+	h.raw = append(raw, h.mac...)
+	return h.raw
+}
+
+// readParameters reads the header from the provided reader, validating it against the given
+// DecryptPolicy, and derives the encryption and HMAC keys for it using the given keyFunc.
+func readParameters(r *bufio.Reader, keys keyFunc, policy DecryptPolicy) (*header, []byte, []byte, error) {
+	hdr, err := readHeader(r, policy)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	encKey, hmacKey, err := keys(hdr)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return hdr, encKey, hmacKey, nil
+}
+
+// readHeader reads the header from the provided reader. If the ciphertext starts with the magic
+// header, the remaining header is read according to the format version that follows it. Otherwise,
+// the ciphertext is assumed to be in the legacy format. The parameters are validated against the
+// given DecryptPolicy before any memory is allocated for them.
+func readHeader(r *bufio.Reader, policy DecryptPolicy) (*header, error) {
+	hdr := &header{
+		version: formatVersionLegacy,
+		suite:   CipherSuiteAES256CTRHMACSHA512,
+		kdf:     keyDerivationArgon2ID,
+	}
+	magic, _ := r.Peek(len(headerMagic))
+	if bytes.Equal(magic, headerMagic) {
+		prefix := make([]byte, len(headerMagic)+1)
+		if _, err := io.ReadFull(r, prefix); err != nil {
+			return nil, fmt.Errorf("failed to read format version: %w", err)
+		}
+		hdr.version = prefix[len(headerMagic)]
+		if hdr.version == formatVersionLegacy || hdr.version > currentFormatVersion {
+			return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, hdr.version)
+		}
+	}
+
+	if hdr.version >= formatVersion2 {
+		if err := hdr.readCipherSuite(r); err != nil {
+			return nil, err
+		}
+	}
+	if hdr.version >= formatVersion3 {
+		if err := hdr.readKeyDerivation(r); err != nil {
+			return nil, err
+		}
+	}
+	if err := hdr.readKeyParameters(r, policy); err != nil {
+		return nil, err
+	}
+	if hdr.version != formatVersionLegacy {
+		if err := hdr.readSegmentSize(r, policy); err != nil {
+			return nil, err
+		}
+	}
+	if hdr.kdf == keyDerivationKeySlots {
+		hdr.mac = make([]byte, hmacSize)
+		if _, err := io.ReadFull(r, hdr.mac); err != nil {
+			return nil, fmt.Errorf("failed to read header MAC: %w", err)
+		}
+	}
+	hdr.marshal()
+	return hdr, nil
+}
+
+// readCipherSuite reads the cipher suite from the provided reader.
+func (h *header) readCipherSuite(r io.Reader) error {
+	suite := make([]byte, 1)
+	if _, err := io.ReadFull(r, suite); err != nil {
+		return fmt.Errorf("failed to read cipher suite: %w", err)
+	}
+	h.suite = CipherSuite(suite[0])
+	if !h.suite.supported() {
+		return fmt.Errorf("%w: %d", ErrUnsupportedCipherSuite, suite[0])
+	}
+	return nil
+}
+
+// readKeyDerivation reads the key derivation method from the provided reader.
+func (h *header) readKeyDerivation(r io.Reader) error {
+	kdf := make([]byte, 1)
+	if _, err := io.ReadFull(r, kdf); err != nil {
+		return fmt.Errorf("failed to read key derivation: %w", err)
+	}
+	h.kdf = keyDerivation(kdf[0])
+	switch h.kdf {
+	case keyDerivationArgon2ID, keyDerivationHKDF, keyDerivationKeySlots, keyDerivationArgon2I, keyDerivationScrypt,
+		keyDerivationPBKDF2:
+		return nil
+	default:
+		return fmt.Errorf("%w: %d", ErrUnsupportedKeyDerivation, kdf[0])
+	}
+}
+
+// readKeyParameters reads the parameters of the key derivation and the IV from the provided reader.
+// For password based key derivations these are its settings and the salt, for HKDF only the salt and
+// for key slots the salt, followed by the key slots.
+func (h *header) readKeyParameters(r io.Reader, policy DecryptPolicy) error {
+	if h.kdf == keyDerivationHKDF || h.kdf == keyDerivationKeySlots {
+		h.salt = make([]byte, hkdfSaltSize)
+		if _, err := io.ReadFull(r, h.salt); err != nil {
+			return fmt.Errorf("failed to read salt: %w", err)
+		}
+		if h.kdf == keyDerivationKeySlots {
+			if err := h.readKeySlots(r, policy); err != nil {
+				return err
+			}
+		}
+		return h.readIV(r)
+	}
+
+	params, err := readPasswordKDF(r, h.kdf, policy)
+	if err != nil {
+		return err
+	}
+	h.params = params
+	h.salt = make([]byte, params.saltLength())
+	if _, err := io.ReadFull(r, h.salt); err != nil {
+		return fmt.Errorf("failed to read salt: %w", err)
+	}
+	return h.readIV(r)
+}
+
+// readKeySlots reads the number of key slots, followed by the key slots, from the provided reader.
+// Since every password key slot requires an Argon2 key derivation to be tried, their number is
+// limited by the DecryptPolicy.
+func (h *header) readKeySlots(r io.Reader, policy DecryptPolicy) error {
+	count := make([]byte, 1)
+	if _, err := io.ReadFull(r, count); err != nil {
+		return fmt.Errorf("failed to read number of key slots: %w", err)
+	}
+	if count[0] == 0 {
+		return fmt.Errorf("%w: header holds no key slots", ErrInvalidKeySlot)
+	}
+	h.slots = make([]keySlotData, 0, count[0])
+	passwordSlots := 0
+	for range int(count[0]) {
+		slot, err := readKeySlot(r, policy)
+		if err != nil {
+			return err
+		}
+		if slot.kind.matches(keySlotPassword) {
+			passwordSlots++
+			if passwordSlots > int(policy.MaxPasswordSlots) {
+				return policyError(ErrKeySlotLimitExceeded, uint64(passwordSlots), uint64(policy.MaxPasswordSlots))
+			}
+		}
+		h.slots = append(h.slots, slot)
+	}
+	return nil
+}
+
+// readIV reads the IV from the provided reader.
+func (h *header) readIV(r io.Reader) error {
+	h.iv = make([]byte, blockSize)
+	if _, err := io.ReadFull(r, h.iv); err != nil {
+		return fmt.Errorf("failed to read IV: %w", err)
+	}
+	return nil
+}
+
+// readSegmentSize reads the size of the plaintext segments of the segmented stream format from
+// the provided reader.
+func (h *header) readSegmentSize(r io.Reader, policy DecryptPolicy) error {
+	segmentSizeBytes := make([]byte, segmentSizeLength)
+	if _, err := io.ReadFull(r, segmentSizeBytes); err != nil {
+		return fmt.Errorf("failed to read segment size: %w", err)
+	}
+	h.segmentSize = binary.BigEndian.Uint32(segmentSizeBytes)
+	if h.segmentSize < 1 || h.segmentSize > policy.MaxSegmentSize {
+		return ErrInvalidSegmentSize
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+
+	wa "github.com/wneessen/argon2"
+)
+
+// Header describes the parameters stored in the header of a ciphertext, as returned by ParseHeader.
+// Since the header can only be authenticated with the key, its values are informational and must
+// not be relied upon for security decisions about a ciphertext of unknown origin.
+type Header struct {
+	// Version is the format version of the ciphertext. Version 0 identifies the legacy format.
+	Version uint8
+
+	// CipherSuite is the cipher suite the ciphertext has been encrypted with.
+	CipherSuite CipherSuite
+
+	// KeyDerivation is the name of the method the keys are derived with, i.e. "Argon2id", "Argon2i",
+	// "scrypt" or "PBKDF2-HMAC-SHA256" for passwords, "HKDF-SHA512" for master keys or "key slots" for
+	// a file key wrapped in key slots.
+	KeyDerivation string
+
+	// Settings holds the Argon2 settings if the keys are derived with Argon2id or Argon2i. For key
+	// slots, the settings of each password key slot are found in KeySlots instead.
+	Settings wa.Settings
+
+	// Scrypt holds the scrypt settings if the keys are derived with scrypt.
+	Scrypt ScryptSettings
+
+	// PBKDF2 holds the PBKDF2 settings if the keys are derived with PBKDF2.
+	PBKDF2 PBKDF2Settings
+
+	// Salt is the salt of the key derivation.
+	Salt []byte
+
+	// IV is the initialization vector of the ciphertext.
+	IV []byte
+
+	// SegmentSize is the size of the plaintext segments in bytes. It is 0 for the legacy format.
+	SegmentSize uint32
+
+	// KeySlots describes the key slots, if the file key is wrapped in key slots.
+	KeySlots []HeaderKeySlot
+
+	// Length is the length of the serialized header in bytes, at which the encrypted payload
+	// starts.
+	Length int
+}
+
+// HeaderKeySlot describes a key slot stored in the header of a ciphertext.
+type HeaderKeySlot struct {
+	// Type is the name of the type of the key slot, i.e. "password" or "X25519".
+	Type string
+
+	// KeyDerivation is the name of the password based key derivation of a password key slot.
+	KeyDerivation string
+
+	// Settings holds the Argon2 settings of a password key slot with Argon2id or Argon2i.
+	Settings wa.Settings
+
+	// Scrypt holds the scrypt settings of a password key slot with scrypt.
+	Scrypt ScryptSettings
+
+	// PBKDF2 holds the PBKDF2 settings of a password key slot with PBKDF2.
+	PBKDF2 PBKDF2Settings
+
+	// Salt holds the salt of a password key slot.
+	Salt []byte
+}
+
+// ParseHeader reads the header of a ciphertext from r and returns its parameters, without requiring
+// a password or key. Since r is read through a buffer, it may be read beyond the end of the header.
+//
+// Unlike the decrypters, ParseHeader does not limit the cost of the key derivation, i.e. the memory,
+// time, threads, scrypt parallelization and PBKDF2 iterations, so that ciphertext with parameters
+// outside of the default policy can be audited as well. It still rejects headers that no encrypter
+// creates and that could not be decrypted: a salt length of 0 or above 65535 bytes, a key length
+// below 64 bytes, a segment size of 0 or above 16 MiB, Argon2 settings without passes or threads,
+// scrypt settings without cost, block size or parallelization and PBKDF2 settings without
+// iterations. Ciphertext in the legacy format has no magic string, so any data that does not start with
+// it is parsed as legacy format.
+func ParseHeader(r io.Reader) (*Header, error) {
+	hdr, err := readHeader(bufio.NewReaderSize(r, chunkSize), inspectPolicy())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	parsed := &Header{
+		Version:       hdr.version,
+		CipherSuite:   hdr.suite,
+		KeyDerivation: hdr.kdf.String(),
+		Salt:          hdr.salt,
+		IV:            hdr.iv,
+		SegmentSize:   hdr.segmentSize,
+		Length:        len(hdr.raw),
+	}
+	parsed.Settings, parsed.Scrypt, parsed.PBKDF2 = kdfSettings(hdr.params)
+	for _, slot := range hdr.slots {
+		headerSlot := HeaderKeySlot{Type: slot.kind.String()}
+		if slot.kind.matches(keySlotPassword) {
+			params, salt, _, err := parsePasswordSlot(slot)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read key slot: %w", err)
+			}
+			headerSlot.KeyDerivation = params.kind().String()
+			headerSlot.Settings, headerSlot.Scrypt, headerSlot.PBKDF2 = kdfSettings(params)
+			headerSlot.Salt = bytes.Clone(salt)
+		}
+		parsed.KeySlots = append(parsed.KeySlots, headerSlot)
+	}
+	return parsed, nil
+}
+
+// kdfSettings returns the settings of the given password based key derivation, of which only the one
+// matching its type is set.
+func kdfSettings(params passwordKDF) (wa.Settings, ScryptSettings, PBKDF2Settings) {
+	switch params := params.(type) {
+	case argon2KDF:
+		return params.settings, ScryptSettings{}, PBKDF2Settings{}
+	case ScryptSettings:
+		return wa.Settings{}, params, PBKDF2Settings{}
+	case PBKDF2Settings:
+		return wa.Settings{}, ScryptSettings{}, params
+	default:
+		return wa.Settings{}, ScryptSettings{}, PBKDF2Settings{}
+	}
+}
+
+// inspectPolicy returns the DecryptPolicy that ParseHeader reads the header with. It accepts the
+// full range of key derivation costs that can be stored in a header. The salt length is bounded,
+// since the salt is allocated while reading, and the key length and segment size keep the limits
+// that apply to any decryption.
+func inspectPolicy() DecryptPolicy {
+	return DecryptPolicy{
+		MaxMemory:            math.MaxUint32,
+		MaxTime:              math.MaxUint32,
+		MaxThreads:           math.MaxUint8,
+		MaxScryptParallelism: math.MaxUint32,
+		MaxPBKDF2Iterations:  math.MaxUint32,
+		MinSaltLength:        1,
+		MaxSaltLength:        math.MaxUint16,
+		MinKeyLength:         minKeyLength,
+		MaxKeyLength:         math.MaxUint32,
+		MaxSegmentSize:       maxSegmentSize,
+		MaxPasswordSlots:     math.MaxUint8,
+	}
+}
+
+// String satisfies the fmt.Stringer interface for the keyDerivation type.
+func (k keyDerivation) String() string {
+	switch k {
+	case keyDerivationArgon2ID:
+		return "Argon2id"
+	case keyDerivationHKDF:
+		return "HKDF-SHA512"
+	case keyDerivationKeySlots:
+		return "key slots"
+	case keyDerivationArgon2I:
+		return "Argon2i"
+	case keyDerivationScrypt:
+		return "scrypt"
+	case keyDerivationPBKDF2:
+		return "PBKDF2-HMAC-SHA256"
+	default:
+		return fmt.Sprintf("unknown key derivation (%d)", byte(k))
+	}
+}
+
+// String satisfies the fmt.Stringer interface for the keySlotType type.
+func (k keySlotType) String() string {
+	switch k {
+	case keySlotX25519:
+		return "X25519"
+	case keySlotPassword, keySlotPasswordKDF:
+		return "password"
+	default:
+		return fmt.Sprintf("unknown key slot type (%d)", byte(k))
+	}
+}
@@ -0,0 +1,301 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"io"
+	"math"
+	"testing"
+
+	wa "github.com/wneessen/argon2"
+)
+
+func TestParseHeader(t *testing.T) {
+	plaintext := []byte("plaintext")
+
+	t.Run("parsing password header", func(t *testing.T) {
+		encrypter, err := NewEncrypter(bytes.NewReader(plaintext), testPassword, WithArgon2(8*1024, 2, 3),
+			WithCipherSuite(CipherSuiteAES256GCM), WithChunkSize(1000))
+		if err != nil {
+			t.Fatalf("failed to create encrypter: %s", err)
+		}
+		ciphertext, err := io.ReadAll(encrypter)
+		if err != nil {
+			t.Fatalf("failed to encrypt plaintext: %s", err)
+		}
+		parsed, err := ParseHeader(bytes.NewReader(ciphertext))
+		if err != nil {
+			t.Fatalf("failed to parse header: %s", err)
+		}
+		hdr := readTestHeader(t, ciphertext)
+		if parsed.Version != currentFormatVersion {
+			t.Errorf("expected version to be %d, got %d", currentFormatVersion, parsed.Version)
+		}
+		if parsed.CipherSuite != CipherSuiteAES256GCM {
+			t.Errorf("expected cipher suite to be %s, got %s", CipherSuiteAES256GCM, parsed.CipherSuite)
+		}
+		if parsed.KeyDerivation != "Argon2id" {
+			t.Errorf("expected key derivation to be %q, got %q", "Argon2id", parsed.KeyDerivation)
+		}
+		if parsed.Settings.Memory != 8*1024 || parsed.Settings.Time != 2 || parsed.Settings.Threads != 3 {
+			t.Errorf("expected Argon2 settings to be 8192/2/3, got %d/%d/%d", parsed.Settings.Memory,
+				parsed.Settings.Time, parsed.Settings.Threads)
+		}
+		if !bytes.Equal(parsed.Salt, hdr.salt) {
+			t.Error("expected salt to match the header")
+		}
+		if !bytes.Equal(parsed.IV, hdr.iv) {
+			t.Error("expected IV to match the header")
+		}
+		if parsed.SegmentSize != 1000 {
+			t.Errorf("expected segment size to be %d, got %d", 1000, parsed.SegmentSize)
+		}
+		if parsed.Length != len(hdr.raw) {
+			t.Errorf("expected header length to be %d, got %d", len(hdr.raw), parsed.Length)
+		}
+		if len(parsed.KeySlots) != 0 {
+			t.Errorf("expected no key slots, got %d", len(parsed.KeySlots))
+		}
+	})
+	t.Run("parsing master key header", func(t *testing.T) {
+		key := make([]byte, MasterKeySize)
+		encrypter, err := NewEncrypterWithKey(bytes.NewReader(plaintext), key)
+		if err != nil {
+			t.Fatalf("failed to create encrypter: %s", err)
+		}
+		ciphertext, err := io.ReadAll(encrypter)
+		if err != nil {
+			t.Fatalf("failed to encrypt plaintext: %s", err)
+		}
+		parsed, err := ParseHeader(bytes.NewReader(ciphertext))
+		if err != nil {
+			t.Fatalf("failed to parse header: %s", err)
+		}
+		if parsed.KeyDerivation != "HKDF-SHA512" {
+			t.Errorf("expected key derivation to be %q, got %q", "HKDF-SHA512", parsed.KeyDerivation)
+		}
+		if parsed.Settings.Memory != 0 || parsed.Settings.Time != 0 {
+			t.Errorf("expected no Argon2 settings, got %d/%d", parsed.Settings.Memory, parsed.Settings.Time)
+		}
+	})
+	t.Run("parsing key slot header", func(t *testing.T) {
+		identity := generateIdentity(t)
+		slots := []KeySlot{X25519KeySlot(identity.PublicKey()), PasswordKeySlot(testPassword)}
+		encrypter, err := NewEncrypterWithKeySlots(bytes.NewReader(plaintext), slots, WithArgon2(8*1024, 1, 1))
+		if err != nil {
+			t.Fatalf("failed to create encrypter: %s", err)
+		}
+		ciphertext, err := io.ReadAll(encrypter)
+		if err != nil {
+			t.Fatalf("failed to encrypt plaintext: %s", err)
+		}
+		parsed, err := ParseHeader(bytes.NewReader(ciphertext))
+		if err != nil {
+			t.Fatalf("failed to parse header: %s", err)
+		}
+		if parsed.KeyDerivation != "key slots" {
+			t.Errorf("expected key derivation to be %q, got %q", "key slots", parsed.KeyDerivation)
+		}
+		if len(parsed.KeySlots) != 2 {
+			t.Fatalf("expected 2 key slots, got %d", len(parsed.KeySlots))
+		}
+		if parsed.KeySlots[0].Type != "X25519" || parsed.KeySlots[1].Type != "password" {
+			t.Errorf("expected key slot types to be X25519 and password, got %s and %s", parsed.KeySlots[0].Type,
+				parsed.KeySlots[1].Type)
+		}
+		if parsed.KeySlots[1].KeyDerivation != "Argon2id" {
+			t.Errorf("expected password key slot key derivation to be %q, got %q", "Argon2id",
+				parsed.KeySlots[1].KeyDerivation)
+		}
+		if parsed.KeySlots[1].Settings.Memory != 8*1024 {
+			t.Errorf("expected password key slot memory to be %d, got %d", 8*1024,
+				parsed.KeySlots[1].Settings.Memory)
+		}
+		if len(parsed.KeySlots[1].Salt) != saltSize {
+			t.Errorf("expected password key slot salt length to be %d, got %d", saltSize,
+				len(parsed.KeySlots[1].Salt))
+		}
+		if parsed.Length != len(readTestHeader(t, ciphertext).raw) {
+			t.Errorf("expected header length to be %d, got %d", len(readTestHeader(t, ciphertext).raw),
+				parsed.Length)
+		}
+	})
+	t.Run("parsing scrypt header", func(t *testing.T) {
+		encrypter, err := NewEncrypter(bytes.NewReader(plaintext), testPassword, WithScrypt(10, 8, 1))
+		if err != nil {
+			t.Fatalf("failed to create encrypter: %s", err)
+		}
+		ciphertext, err := io.ReadAll(encrypter)
+		if err != nil {
+			t.Fatalf("failed to encrypt plaintext: %s", err)
+		}
+		parsed, err := ParseHeader(bytes.NewReader(ciphertext))
+		if err != nil {
+			t.Fatalf("failed to parse header: %s", err)
+		}
+		if parsed.KeyDerivation != "scrypt" {
+			t.Errorf("expected key derivation to be %q, got %q", "scrypt", parsed.KeyDerivation)
+		}
+		want := ScryptSettings{LogN: 10, R: 8, P: 1, SaltLength: saltSize, KeyLength: aesKeySize + hmacSize}
+		if parsed.Scrypt != want {
+			t.Errorf("expected scrypt settings to be %+v, got %+v", want, parsed.Scrypt)
+		}
+		if parsed.Settings.Time != 0 || parsed.PBKDF2.Iterations != 0 {
+			t.Errorf("expected no Argon2 or PBKDF2 settings, got %+v and %+v", parsed.Settings, parsed.PBKDF2)
+		}
+	})
+	t.Run("parsing PBKDF2 key slot header", func(t *testing.T) {
+		encrypter, err := NewEncrypterWithKeySlots(bytes.NewReader(plaintext),
+			[]KeySlot{PasswordKeySlot(testPassword)}, WithPBKDF2(1000))
+		if err != nil {
+			t.Fatalf("failed to create encrypter: %s", err)
+		}
+		ciphertext, err := io.ReadAll(encrypter)
+		if err != nil {
+			t.Fatalf("failed to encrypt plaintext: %s", err)
+		}
+		parsed, err := ParseHeader(bytes.NewReader(ciphertext))
+		if err != nil {
+			t.Fatalf("failed to parse header: %s", err)
+		}
+		if len(parsed.KeySlots) != 1 {
+			t.Fatalf("expected 1 key slot, got %d", len(parsed.KeySlots))
+		}
+		slot := parsed.KeySlots[0]
+		if slot.Type != "password" || slot.KeyDerivation != "PBKDF2-HMAC-SHA256" {
+			t.Errorf("expected a password key slot with PBKDF2-HMAC-SHA256, got %s with %s", slot.Type,
+				slot.KeyDerivation)
+		}
+		if slot.PBKDF2.Iterations != 1000 {
+			t.Errorf("expected PBKDF2 iterations to be %d, got %d", 1000, slot.PBKDF2.Iterations)
+		}
+		if len(slot.Salt) != saltSize {
+			t.Errorf("expected password key slot salt length to be %d, got %d", saltSize, len(slot.Salt))
+		}
+	})
+	t.Run("parsing recipient header", func(t *testing.T) {
+		identity := generateIdentity(t)
+		ciphertext := encryptForRecipients(t, plaintext, []*ecdh.PublicKey{identity.PublicKey()})
+		parsed, err := ParseHeader(bytes.NewReader(ciphertext))
+		if err != nil {
+			t.Fatalf("failed to parse header: %s", err)
+		}
+		if len(parsed.KeySlots) != 1 || parsed.KeySlots[0].Salt != nil {
+			t.Errorf("expected a single X25519 key slot without salt, got %+v", parsed.KeySlots)
+		}
+	})
+	t.Run("parsing legacy header", func(t *testing.T) {
+		legacy, err := newLegacyEncrypter(bytes.NewReader(plaintext), testPassword)
+		if err != nil {
+			t.Fatalf("failed to create legacy encrypter: %s", err)
+		}
+		ciphertext, err := io.ReadAll(legacy)
+		if err != nil {
+			t.Fatalf("failed to encrypt plaintext: %s", err)
+		}
+		parsed, err := ParseHeader(bytes.NewReader(ciphertext))
+		if err != nil {
+			t.Fatalf("failed to parse header: %s", err)
+		}
+		if parsed.Version != formatVersionLegacy {
+			t.Errorf("expected version to be %d, got %d", formatVersionLegacy, parsed.Version)
+		}
+		if parsed.SegmentSize != 0 {
+			t.Errorf("expected segment size to be 0, got %d", parsed.SegmentSize)
+		}
+		if parsed.Settings.Memory != defaultArgon2Memory {
+			t.Errorf("expected Argon2 memory to be %d, got %d", defaultArgon2Memory, parsed.Settings.Memory)
+		}
+	})
+	t.Run("parsing header outside of the default policy", func(t *testing.T) {
+		hdr := &header{
+			version:     currentFormatVersion,
+			suite:       defaultCipherSuite,
+			kdf:         keyDerivationArgon2ID,
+			params:      argon2IDKDF(wa.NewSettings(math.MaxUint32, 100, 255, 128, aesKeySize+hmacSize)),
+			salt:        make([]byte, 128),
+			iv:          make([]byte, blockSize),
+			segmentSize: defaultSegmentSize,
+		}
+		if _, err := io.ReadFull(rand.Reader, hdr.salt); err != nil {
+			t.Fatalf("failed to generate salt: %s", err)
+		}
+		raw := hdr.marshal()
+		if _, err := NewDecrypter(bytes.NewReader(raw), testPassword); !errors.Is(err, ErrMemoryLimitExceeded) {
+			t.Fatalf("expected error to be %s, got %s", ErrMemoryLimitExceeded, err)
+		}
+		parsed, err := ParseHeader(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatalf("failed to parse header: %s", err)
+		}
+		if parsed.Settings.Memory != math.MaxUint32 || parsed.Settings.Threads != 255 {
+			t.Errorf("expected Argon2 settings to be parsed, got %d/%d", parsed.Settings.Memory,
+				parsed.Settings.Threads)
+		}
+		if !bytes.Equal(parsed.Salt, hdr.salt) {
+			t.Error("expected salt to match the header")
+		}
+	})
+	invalidTests := []struct {
+		name        string
+		params      passwordKDF
+		segmentSize uint32
+		wantErr     error
+	}{
+		{
+			"zero salt length should fail", argon2IDKDF(wa.NewSettings(8*1024, 1, 1, 0, aesKeySize+hmacSize)),
+			defaultSegmentSize, ErrInvalidSaltLength,
+		},
+		{
+			"short key length should fail", argon2IDKDF(wa.NewSettings(8*1024, 1, 1, saltSize, aesKeySize)),
+			defaultSegmentSize, ErrInvalidKeyLength,
+		},
+		{
+			"zero Argon2 time should fail", argon2IDKDF(wa.NewSettings(8*1024, 0, 1, saltSize, aesKeySize+hmacSize)),
+			defaultSegmentSize, ErrTooLessRounds,
+		},
+		{
+			"zero PBKDF2 iterations should fail", PBKDF2Settings{0, saltSize, aesKeySize + hmacSize},
+			defaultSegmentSize, ErrTooLessRounds,
+		},
+		{
+			"huge segment size should fail", argon2IDKDF(wa.NewSettings(8*1024, 1, 1, saltSize, aesKeySize+hmacSize)),
+			maxSegmentSize + 1, ErrInvalidSegmentSize,
+		},
+	}
+	for _, tt := range invalidTests {
+		t.Run("parsing header with "+tt.name, func(t *testing.T) {
+			hdr := &header{
+				version:     currentFormatVersion,
+				suite:       defaultCipherSuite,
+				kdf:         tt.params.kind(),
+				params:      tt.params,
+				salt:        make([]byte, tt.params.saltLength()),
+				iv:          make([]byte, blockSize),
+				segmentSize: tt.segmentSize,
+			}
+			if _, err := ParseHeader(bytes.NewReader(hdr.marshal())); !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected error to be %s, got %s", tt.wantErr, err)
+			}
+		})
+	}
+	t.Run("parsing truncated header should fail", func(t *testing.T) {
+		ciphertext := encryptBytes(t, plaintext)
+		if _, err := ParseHeader(bytes.NewReader(ciphertext[:20])); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("expected error to be %s, got %s", io.ErrUnexpectedEOF, err)
+		}
+	})
+	t.Run("parsing header with unsupported version should fail", func(t *testing.T) {
+		ciphertext := encryptBytes(t, plaintext)
+		ciphertext[len(headerMagic)] = currentFormatVersion + 1
+		if _, err := ParseHeader(bytes.NewReader(ciphertext)); !errors.Is(err, ErrUnsupportedVersion) {
+			t.Errorf("expected error to be %s, got %s", ErrUnsupportedVersion, err)
+		}
+	})
+}
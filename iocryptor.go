@@ -6,13 +6,22 @@ package iocrypter
 
 import (
 	"crypto/aes"
+	"crypto/hkdf"
 	"crypto/sha512"
 	"errors"
+	"fmt"
 
 	wa "github.com/wneessen/argon2"
 	"golang.org/x/crypto/argon2"
 )
 
+// MasterKeySize is the size in bytes of the raw master key expected by NewEncrypterWithKey and
+// NewDecrypterWithKey.
+const MasterKeySize = 32
+
+// hkdfInfo is the context information used for the HKDF key derivation from a raw master key.
+const hkdfInfo = "iocrypter stream keys"
+
 const (
 	// hmacSize represents the size in bytes of the HMAC output, derived from the
 	// underlying SHA-512 hash function.
@@ -26,6 +35,10 @@ const (
 	// or key derivation operations.
 	saltSize = 32
 
+	// hkdfSaltSize defines the size in bytes of the salt used for the HKDF key derivation from a
+	// raw master key.
+	hkdfSaltSize = 32
+
 	// aesKeySize defines the size in bytes of the key used for AES encryption, ensuring
 	// an adequately secure key length.
 	aesKeySize = 32
@@ -45,6 +58,18 @@ const (
 	// defaultArgon2Time defines the default number of iterations for the Argon2 key
 	// derivation function.
 	defaultArgon2Time = 3
+
+	// defaultSegmentSize defines the default size in bytes of the plaintext of a single
+	// segment in the segmented stream format.
+	defaultSegmentSize = 64 * 1024
+
+	// maxSegmentSize defines the maximum size in bytes of the plaintext of a single segment
+	// that is accepted when decrypting a segmented stream.
+	maxSegmentSize = 16 * 1024 * 1024
+
+	// segmentSizeLength defines the size in bytes of the serialized segment size in the
+	// header of the segmented stream format.
+	segmentSizeLength = 4
 )
 
 var (
@@ -69,11 +94,81 @@ var (
 
 	// ErrWriteAfterRead indicates that writing to a hashReadWriter instance is not allowed after a read operation.
 	ErrWriteAfterRead = errors.New("writing to hashReadWriter after read is not allowed")
+
+	// ErrInvalidSegmentSize indicates that the segment size stored in the header of a segmented
+	// stream is out of the supported range.
+	ErrInvalidSegmentSize = errors.New("invalid segment size")
+
+	// ErrUnsupportedVersion indicates that the format version stored in the header of the ciphertext
+	// is not supported by this version of the package.
+	ErrUnsupportedVersion = errors.New("unsupported format version")
+
+	// ErrUnsupportedCipherSuite indicates that the cipher suite is not supported by this version of
+	// the package.
+	ErrUnsupportedCipherSuite = errors.New("unsupported cipher suite")
+
+	// ErrUnsupportedKeyDerivation indicates that the key derivation method stored in the header of the
+	// ciphertext is not supported by this version of the package.
+	ErrUnsupportedKeyDerivation = errors.New("unsupported key derivation")
+
+	// ErrInvalidKeySize indicates that the provided master key does not have the required size.
+	ErrInvalidKeySize = errors.New("master key must be 32 bytes")
+
+	// ErrKeyTypeMismatch indicates that the ciphertext was encrypted with a different type of secret than
+	// the one provided for decryption, i.e. a password instead of a raw master key or vice versa.
+	ErrKeyTypeMismatch = errors.New("ciphertext was encrypted with a different type of secret")
 )
 
+// defaultSettings returns the Argon2 settings that are used for the key derivation if no settings
+// are specified.
+func defaultSettings() wa.Settings {
+	return wa.NewSettings(defaultArgon2Memory, defaultArgon2Time, defaultArgon2Threads, saltSize,
+		aesKeySize+hmacSize)
+}
+
 // DeriveKeys will use Argon2id to derive a AES-256 and a HMAC key from the
 // given password and salt. It will use the given Argon2Settings for the key derivation.
 func DeriveKeys(password, salt []byte, settings wa.Settings) ([]byte, []byte) {
 	key := argon2.IDKey(password, salt, settings.Time, settings.Memory, settings.Threads, settings.KeyLength)
 	return key[:aesKeySize], key[aesKeySize : hmacKeySize+aesKeySize]
 }
+
+// deriveKeysHKDF will use HKDF-SHA512 to derive an AES-256 and a HMAC key from the given raw master
+// key and salt.
+func deriveKeysHKDF(masterKey, salt []byte) ([]byte, []byte, error) {
+	key, err := hkdf.Key(hashFunc, masterKey, salt, hkdfInfo, aesKeySize+hmacKeySize)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to derive keys: %w", err)
+	}
+	return key[:aesKeySize], key[aesKeySize:], nil
+}
+
+// keyFunc derives the encryption and HMAC keys for the stream described by the given header.
+type keyFunc func(hdr *header) ([]byte, []byte, error)
+
+// passwordKeys returns a keyFunc that derives the keys from the given password using the password
+// based key derivation of the header. For headers with key slots, the file key is unwrapped from the
+// password key slots instead.
+func passwordKeys(password []byte) keyFunc {
+	slotKeys := passwordSlotKeys(password)
+	return func(hdr *header) ([]byte, []byte, error) {
+		switch {
+		case hdr.kdf == keyDerivationKeySlots:
+			return slotKeys(hdr)
+		case hdr.params != nil:
+			return derivePasswordKeys(hdr.params, password, hdr.salt)
+		default:
+			return nil, nil, ErrKeyTypeMismatch
+		}
+	}
+}
+
+// masterKeys returns a keyFunc that derives the keys from the given raw master key using HKDF.
+func masterKeys(masterKey []byte) keyFunc {
+	return func(hdr *header) ([]byte, []byte, error) {
+		if hdr.kdf != keyDerivationHKDF {
+			return nil, nil, ErrKeyTypeMismatch
+		}
+		return deriveKeysHKDF(masterKey, hdr.salt)
+	}
+}
@@ -0,0 +1,287 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+
+	wa "github.com/wneessen/argon2"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// scryptSettingsLength defines the length in bytes of the serialized ScryptSettings.
+	scryptSettingsLength = 17
+
+	// pbkdf2SettingsLength defines the length in bytes of the serialized PBKDF2Settings.
+	pbkdf2SettingsLength = 12
+)
+
+// ErrInvalidKDFSettings indicates that the parameters of a password based key derivation are invalid.
+var ErrInvalidKDFSettings = errors.New("invalid key derivation settings")
+
+// ScryptSettings holds the parameters of the scrypt key derivation, as configured with WithScrypt and
+// stored in the header of the ciphertext.
+type ScryptSettings struct {
+	// LogN is the binary logarithm of the CPU and memory cost parameter N.
+	LogN uint8
+
+	// R is the block size parameter.
+	R uint32
+
+	// P is the parallelization parameter.
+	P uint32
+
+	// SaltLength is the length of the salt in bytes.
+	SaltLength uint32
+
+	// KeyLength is the length of the derived key in bytes.
+	KeyLength uint32
+}
+
+// PBKDF2Settings holds the parameters of the PBKDF2-HMAC-SHA256 key derivation, as configured with
+// WithPBKDF2 and stored in the header of the ciphertext.
+type PBKDF2Settings struct {
+	// Iterations is the number of iterations.
+	Iterations uint32
+
+	// SaltLength is the length of the salt in bytes.
+	SaltLength uint32
+
+	// KeyLength is the length of the derived key in bytes.
+	KeyLength uint32
+}
+
+// passwordKDF is a password based key derivation function along with its parameters, which are
+// serialized into the header of the ciphertext after the key derivation identifier.
+type passwordKDF interface {
+	// kind returns the key derivation identifier that is stored in the header.
+	kind() keyDerivation
+
+	// marshal appends the serialized parameters to dst and returns the extended slice.
+	marshal(dst []byte) []byte
+
+	// saltLength returns the length of the salt in bytes.
+	saltLength() uint32
+
+	// check validates the parameters against the given DecryptPolicy.
+	check(policy DecryptPolicy) error
+
+	// deriveKey derives the key from the given password and salt.
+	deriveKey(password, salt []byte) ([]byte, error)
+}
+
+// argon2KDF implements the passwordKDF interface for Argon2id and Argon2i, which share the
+// serialized wa.Settings as parameters.
+type argon2KDF struct {
+	variant  keyDerivation
+	settings wa.Settings
+}
+
+// argon2IDKDF returns the passwordKDF for Argon2id with the given settings.
+func argon2IDKDF(settings wa.Settings) passwordKDF {
+	return argon2KDF{variant: keyDerivationArgon2ID, settings: settings}
+}
+
+// kind satisfies the passwordKDF interface for the argon2KDF type.
+func (a argon2KDF) kind() keyDerivation {
+	return a.variant
+}
+
+// marshal satisfies the passwordKDF interface for the argon2KDF type.
+func (a argon2KDF) marshal(dst []byte) []byte {
+	return append(dst, a.settings.Serialize()...)
+}
+
+// saltLength satisfies the passwordKDF interface for the argon2KDF type.
+func (a argon2KDF) saltLength() uint32 {
+	return a.settings.SaltLength
+}
+
+// check satisfies the passwordKDF interface for the argon2KDF type.
+func (a argon2KDF) check(policy DecryptPolicy) error {
+	return policy.checkSettings(a.settings)
+}
+
+// deriveKey satisfies the passwordKDF interface for the argon2KDF type.
+func (a argon2KDF) deriveKey(password, salt []byte) ([]byte, error) {
+	s := a.settings
+	if a.variant == keyDerivationArgon2I {
+		return argon2.Key(password, salt, s.Time, s.Memory, s.Threads, s.KeyLength), nil
+	}
+	return argon2.IDKey(password, salt, s.Time, s.Memory, s.Threads, s.KeyLength), nil
+}
+
+// kind satisfies the passwordKDF interface for the ScryptSettings type.
+func (s ScryptSettings) kind() keyDerivation {
+	return keyDerivationScrypt
+}
+
+// marshal satisfies the passwordKDF interface for the ScryptSettings type.
+func (s ScryptSettings) marshal(dst []byte) []byte {
+	dst = append(dst, s.LogN)
+	dst = binary.BigEndian.AppendUint32(dst, s.R)
+	dst = binary.BigEndian.AppendUint32(dst, s.P)
+	dst = binary.BigEndian.AppendUint32(dst, s.SaltLength)
+	return binary.BigEndian.AppendUint32(dst, s.KeyLength)
+}
+
+// saltLength satisfies the passwordKDF interface for the ScryptSettings type.
+func (s ScryptSettings) saltLength() uint32 {
+	return s.SaltLength
+}
+
+// check satisfies the passwordKDF interface for the ScryptSettings type.
+func (s ScryptSettings) check(policy DecryptPolicy) error {
+	return policy.checkScryptSettings(s)
+}
+
+// deriveKey satisfies the passwordKDF interface for the ScryptSettings type.
+func (s ScryptSettings) deriveKey(password, salt []byte) ([]byte, error) {
+	if s.LogN < 1 || s.LogN >= 63 {
+		return nil, fmt.Errorf("%w: scrypt cost of 2^%d", ErrInvalidKDFSettings, s.LogN)
+	}
+	key, err := scrypt.Key(password, salt, 1<<s.LogN, int(s.R), int(s.P), int(s.KeyLength))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidKDFSettings, err)
+	}
+	return key, nil
+}
+
+// memory returns the memory in kibibytes that the scrypt key derivation requires, which is
+// 128 * R * (N + P) + 256 * R bytes for the mixing array, the P blocks and the working buffer. It is
+// rounded up and saturates at math.MaxUint64.
+func (s ScryptSettings) memory() uint64 {
+	if s.LogN >= 64 {
+		return math.MaxUint64
+	}
+	hi, lo := bits.Mul64(uint64(s.R), 1<<s.LogN+uint64(s.P)+2)
+	if hi != 0 {
+		return math.MaxUint64
+	}
+	return lo/8 + min(lo%8, 1)
+}
+
+// kind satisfies the passwordKDF interface for the PBKDF2Settings type.
+func (s PBKDF2Settings) kind() keyDerivation {
+	return keyDerivationPBKDF2
+}
+
+// marshal satisfies the passwordKDF interface for the PBKDF2Settings type.
+func (s PBKDF2Settings) marshal(dst []byte) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, s.Iterations)
+	dst = binary.BigEndian.AppendUint32(dst, s.SaltLength)
+	return binary.BigEndian.AppendUint32(dst, s.KeyLength)
+}
+
+// saltLength satisfies the passwordKDF interface for the PBKDF2Settings type.
+func (s PBKDF2Settings) saltLength() uint32 {
+	return s.SaltLength
+}
+
+// check satisfies the passwordKDF interface for the PBKDF2Settings type.
+func (s PBKDF2Settings) check(policy DecryptPolicy) error {
+	return policy.checkPBKDF2Settings(s)
+}
+
+// deriveKey satisfies the passwordKDF interface for the PBKDF2Settings type.
+func (s PBKDF2Settings) deriveKey(password, salt []byte) ([]byte, error) {
+	key, err := pbkdf2.Key(sha256.New, string(password), salt, int(s.Iterations), int(s.KeyLength))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidKDFSettings, err)
+	}
+	return key, nil
+}
+
+// passwordKDFLength returns the length in bytes of the serialized parameters of the given password
+// based key derivation. For key derivations that are not password based, ErrUnsupportedKeyDerivation
+// is returned.
+func passwordKDFLength(kind keyDerivation) (int, error) {
+	switch kind {
+	case keyDerivationArgon2ID, keyDerivationArgon2I:
+		return wa.SerializedSettingsLength, nil
+	case keyDerivationScrypt:
+		return scryptSettingsLength, nil
+	case keyDerivationPBKDF2:
+		return pbkdf2SettingsLength, nil
+	default:
+		return 0, fmt.Errorf("%w: %d", ErrUnsupportedKeyDerivation, byte(kind))
+	}
+}
+
+// parsePasswordKDF returns the passwordKDF of the given kind with the parameters deserialized from
+// data, which must hold exactly the number of bytes returned by passwordKDFLength.
+func parsePasswordKDF(kind keyDerivation, data []byte) passwordKDF {
+	switch kind {
+	case keyDerivationScrypt:
+		return ScryptSettings{
+			LogN:       data[0],
+			R:          binary.BigEndian.Uint32(data[1:]),
+			P:          binary.BigEndian.Uint32(data[5:]),
+			SaltLength: binary.BigEndian.Uint32(data[9:]),
+			KeyLength:  binary.BigEndian.Uint32(data[13:]),
+		}
+	case keyDerivationPBKDF2:
+		return PBKDF2Settings{
+			Iterations: binary.BigEndian.Uint32(data),
+			SaltLength: binary.BigEndian.Uint32(data[4:]),
+			KeyLength:  binary.BigEndian.Uint32(data[8:]),
+		}
+	default:
+		return argon2KDF{variant: kind, settings: wa.SettingsFromBytes(data)}
+	}
+}
+
+// readPasswordKDF reads the parameters of the given password based key derivation from the provided
+// reader and validates them against the given DecryptPolicy.
+func readPasswordKDF(r io.Reader, kind keyDerivation, policy DecryptPolicy) (passwordKDF, error) {
+	length, err := passwordKDFLength(kind)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, length)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("failed to read %s settings: %w", kind.settingsName(), err)
+	}
+	params := parsePasswordKDF(kind, data)
+	if err = params.check(policy); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+// derivePasswordKeys derives the encryption and HMAC keys from the given password and salt using the
+// given password based key derivation.
+func derivePasswordKeys(params passwordKDF, password, salt []byte) ([]byte, []byte, error) {
+	key, err := params.deriveKey(password, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(key) < aesKeySize+hmacKeySize {
+		return nil, nil, fmt.Errorf("%w: key length of %d bytes", ErrInvalidKDFSettings, len(key))
+	}
+	return key[:aesKeySize], key[aesKeySize : aesKeySize+hmacKeySize], nil
+}
+
+// settingsName returns the name of the key derivation that is used in error messages about its
+// settings.
+func (k keyDerivation) settingsName() string {
+	switch k {
+	case keyDerivationScrypt:
+		return "scrypt"
+	case keyDerivationPBKDF2:
+		return "PBKDF2"
+	default:
+		return "Argon2"
+	}
+}
@@ -0,0 +1,167 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"testing"
+)
+
+func TestPasswordKDF(t *testing.T) {
+	plaintext := []byte("This is the plaintext")
+	kdfs := []struct {
+		name   string
+		option Option
+		kind   keyDerivation
+	}{
+		{"Argon2id", WithArgon2(8*1024, 1, 1), keyDerivationArgon2ID},
+		{"Argon2i", WithArgon2i(8*1024, 1, 1), keyDerivationArgon2I},
+		{"scrypt", WithScrypt(10, 8, 1), keyDerivationScrypt},
+		{"PBKDF2", WithPBKDF2(1000), keyDerivationPBKDF2},
+	}
+	for _, tt := range kdfs {
+		t.Run(tt.name+" is detected on decryption", func(t *testing.T) {
+			encrypter, err := NewEncrypter(bytes.NewReader(plaintext), testPassword, tt.option)
+			if err != nil {
+				t.Fatalf("failed to create encrypter: %s", err)
+			}
+			ciphertext, err := io.ReadAll(encrypter)
+			if err != nil {
+				t.Fatalf("failed to encrypt plaintext: %s", err)
+			}
+			hdr := readTestHeader(t, ciphertext)
+			if hdr.kdf != tt.kind {
+				t.Errorf("expected key derivation to be %s, got %s", tt.kind, hdr.kdf)
+			}
+			decrypter, err := NewDecrypter(bytes.NewReader(ciphertext), testPassword)
+			if err != nil {
+				t.Fatalf("failed to create decrypter: %s", err)
+			}
+			decrypted, err := io.ReadAll(decrypter)
+			if err != nil {
+				t.Fatalf("failed to decrypt ciphertext: %s", err)
+			}
+			if !bytes.Equal(plaintext, decrypted) {
+				t.Errorf("plaintext and decrypted data do not match, expected %s, got %s", plaintext, decrypted)
+			}
+			_, err = NewDecrypter(bytes.NewReader(ciphertext), []byte("invalid passphrase"))
+			if !errors.Is(err, ErrFailedAuthentication) {
+				t.Errorf("expected error to be %s, got %s", ErrFailedAuthentication, err)
+			}
+		})
+		t.Run(tt.name+" is used for password key slots", func(t *testing.T) {
+			encrypter, err := NewEncrypterWithKeySlots(bytes.NewReader(plaintext),
+				[]KeySlot{PasswordKeySlot(testPassword)}, tt.option)
+			if err != nil {
+				t.Fatalf("failed to create encrypter: %s", err)
+			}
+			ciphertext, err := io.ReadAll(encrypter)
+			if err != nil {
+				t.Fatalf("failed to encrypt plaintext: %s", err)
+			}
+			wantSlot := keySlotPasswordKDF
+			if tt.kind == keyDerivationArgon2ID {
+				wantSlot = keySlotPassword
+			}
+			hdr := readTestHeader(t, ciphertext)
+			if hdr.slots[0].kind != wantSlot {
+				t.Errorf("expected key slot type to be %d, got %d", wantSlot, hdr.slots[0].kind)
+			}
+			params, _, _, err := parsePasswordSlot(hdr.slots[0])
+			if err != nil {
+				t.Fatalf("failed to parse key slot: %s", err)
+			}
+			if params.kind() != tt.kind {
+				t.Errorf("expected key slot key derivation to be %s, got %s", tt.kind, params.kind())
+			}
+
+			decrypter, err := NewDecrypter(bytes.NewReader(ciphertext), testPassword)
+			if err != nil {
+				t.Fatalf("failed to create decrypter: %s", err)
+			}
+			decrypted, err := io.ReadAll(decrypter)
+			if err != nil {
+				t.Fatalf("failed to decrypt ciphertext: %s", err)
+			}
+			if !bytes.Equal(plaintext, decrypted) {
+				t.Errorf("plaintext and decrypted data do not match, expected %s, got %s", plaintext, decrypted)
+			}
+			_, err = NewDecrypter(bytes.NewReader(ciphertext), []byte("invalid passphrase"))
+			if !errors.Is(err, ErrNoMatchingKeySlot) {
+				t.Errorf("expected error to be %s, got %s", ErrNoMatchingKeySlot, err)
+			}
+		})
+	}
+
+	t.Run("rekeying changes the key derivation of the key slot", func(t *testing.T) {
+		encrypter, err := NewEncrypterWithKeySlots(bytes.NewReader(plaintext),
+			[]KeySlot{PasswordKeySlot(testPassword)}, WithPBKDF2(1000))
+		if err != nil {
+			t.Fatalf("failed to create encrypter: %s", err)
+		}
+		ciphertext, err := io.ReadAll(encrypter)
+		if err != nil {
+			t.Fatalf("failed to encrypt plaintext: %s", err)
+		}
+		newPass := []byte("new passphrase")
+		rekeyed := bytes.NewBuffer(nil)
+		if err = Rekey(bytes.NewReader(ciphertext), rekeyed, testPassword, newPass, WithScrypt(10, 8, 1)); err != nil {
+			t.Fatalf("failed to rekey ciphertext: %s", err)
+		}
+		params, _, _, err := parsePasswordSlot(readTestHeader(t, rekeyed.Bytes()).slots[0])
+		if err != nil {
+			t.Fatalf("failed to parse key slot: %s", err)
+		}
+		if params.kind() != keyDerivationScrypt {
+			t.Errorf("expected key slot key derivation to be %s, got %s", keyDerivationScrypt, params.kind())
+		}
+		if err = Verify(bytes.NewReader(rekeyed.Bytes()), newPass); err != nil {
+			t.Errorf("failed to verify rekeyed ciphertext: %s", err)
+		}
+	})
+	t.Run("key slot with unsupported key derivation should fail", func(t *testing.T) {
+		encrypter, err := NewEncrypterWithKeySlots(bytes.NewReader(plaintext),
+			[]KeySlot{PasswordKeySlot(testPassword)}, WithPBKDF2(1000))
+		if err != nil {
+			t.Fatalf("failed to create encrypter: %s", err)
+		}
+		ciphertext, err := io.ReadAll(encrypter)
+		if err != nil {
+			t.Fatalf("failed to encrypt plaintext: %s", err)
+		}
+		// The key derivation identifier follows the key slot type and length
+		ciphertext[keySlotsOffset+1+keySlotLengthSize] = byte(keyDerivationHKDF)
+		_, err = NewDecrypter(bytes.NewReader(ciphertext), testPassword)
+		if !errors.Is(err, ErrUnsupportedKeyDerivation) {
+			t.Errorf("expected error to be %s, got %s", ErrUnsupportedKeyDerivation, err)
+		}
+	})
+	t.Run("scrypt with invalid settings should fail", func(t *testing.T) {
+		settings := ScryptSettings{LogN: 10, R: 1 << 20, P: 1 << 20, SaltLength: saltSize, KeyLength: 64}
+		if _, err := settings.deriveKey(testPassword, make([]byte, saltSize)); !errors.Is(err, ErrInvalidKDFSettings) {
+			t.Errorf("expected error to be %s, got %s", ErrInvalidKDFSettings, err)
+		}
+	})
+	t.Run("scrypt memory saturates", func(t *testing.T) {
+		tests := []struct {
+			settings ScryptSettings
+			want     uint64
+		}{
+			{ScryptSettings{LogN: 17, R: 8, P: 1}, 128*1024 + 3},
+			{ScryptSettings{LogN: 1, R: 1 << 22, P: 16}, 10 * 1024 * 1024},
+			{ScryptSettings{LogN: 1, R: 1, P: 1}, 1},
+			{ScryptSettings{LogN: 63, R: 1 << 31}, math.MaxUint64},
+			{ScryptSettings{LogN: 64, R: 1}, math.MaxUint64},
+		}
+		for _, tt := range tests {
+			if got := tt.settings.memory(); got != tt.want {
+				t.Errorf("expected memory of %+v to be %d, got %d", tt.settings, tt.want, got)
+			}
+		}
+	})
+}
@@ -0,0 +1,491 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	wa "github.com/wneessen/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	// fileKeySize defines the size in bytes of the random file key that is wrapped in the key slots.
+	fileKeySize = 32
+
+	// wrappedKeySize defines the size in bytes of a file key that has been wrapped with
+	// ChaCha20-Poly1305.
+	wrappedKeySize = fileKeySize + chacha20poly1305.Overhead
+
+	// x25519KeySize defines the size in bytes of an X25519 public key.
+	x25519KeySize = 32
+
+	// x25519SlotSize defines the size in bytes of an X25519 key slot, which holds the ephemeral
+	// public key and the wrapped file key.
+	x25519SlotSize = x25519KeySize + wrappedKeySize
+
+	// maxKeySlots defines the maximum number of key slots that fit into the header.
+	maxKeySlots = 255
+
+	// keySlotLengthSize defines the size in bytes of the serialized length of a key slot.
+	keySlotLengthSize = 2
+
+	// x25519Info is the context information used for the HKDF derivation of the key that wraps
+	// the file key in an X25519 key slot.
+	x25519Info = "iocrypter X25519 key slot"
+
+	// headerMACInfo is the context information used for the HKDF derivation of the header MAC
+	// key from the file key.
+	headerMACInfo = "iocrypter header MAC"
+)
+
+var (
+	// ErrNoRecipients indicates that no recipient or key slot has been provided to the encrypter.
+	ErrNoRecipients = errors.New("at least one recipient is required")
+
+	// ErrInvalidRecipient indicates that a provided recipient or identity is not a valid X25519 key.
+	ErrInvalidRecipient = errors.New("recipient must be a X25519 key")
+
+	// ErrInvalidKeySlot indicates that a key slot stored in the header of the ciphertext is malformed.
+	ErrInvalidKeySlot = errors.New("invalid key slot")
+
+	// ErrNoMatchingKeySlot indicates that none of the key slots in the header of the ciphertext could
+	// be unwrapped with the provided secret.
+	ErrNoMatchingKeySlot = errors.New("no key slot matches the provided secret")
+)
+
+// keySlotType identifies the type of secret that a key slot is wrapped for.
+type keySlotType byte
+
+const (
+	// keySlotX25519 identifies a key slot wrapped for an X25519 public key.
+	keySlotX25519 keySlotType = iota + 1
+
+	// keySlotPassword identifies a key slot wrapped with a key derived from a password using
+	// Argon2id.
+	keySlotPassword
+
+	// keySlotPasswordKDF identifies a key slot wrapped with a key derived from a password using the
+	// password based key derivation whose identifier is stored in the key slot.
+	keySlotPasswordKDF
+)
+
+// KeySlot describes a secret that the file key of a ciphertext is wrapped for. A ciphertext that
+// is encrypted with NewEncrypterWithKeySlots can be decrypted with any of the secrets of its key
+// slots.
+type KeySlot interface {
+	// wrap wraps the file key for the secret of the KeySlot.
+	wrap(fileKey []byte, o *options) (keySlotData, error)
+}
+
+// passwordKeySlot is a KeySlot for a password.
+type passwordKeySlot []byte
+
+// x25519KeySlot is a KeySlot for an X25519 public key.
+type x25519KeySlot struct {
+	recipient *ecdh.PublicKey
+}
+
+// PasswordKeySlot returns a KeySlot that wraps the file key with a key derived from the given
+// password using Argon2id, with the Argon2 settings configured with WithArgon2. Another password based
+// key derivation can be configured with WithArgon2i, WithScrypt or WithPBKDF2. The ciphertext can be
+// decrypted with the password using NewDecrypter.
+func PasswordKeySlot(password []byte) KeySlot {
+	return passwordKeySlot(password)
+}
+
+// X25519KeySlot returns a KeySlot that wraps the file key for the given X25519 public key. The
+// ciphertext can be decrypted with the corresponding private key using NewDecrypterWithIdentity.
+func X25519KeySlot(recipient *ecdh.PublicKey) KeySlot {
+	return x25519KeySlot{recipient: recipient}
+}
+
+// wrap satisfies the KeySlot interface for the passwordKeySlot type.
+func (p passwordKeySlot) wrap(fileKey []byte, o *options) (keySlotData, error) {
+	if len(p) == 0 {
+		return keySlotData{}, ErrPassPhraseEmpty
+	}
+	salt := make([]byte, o.kdf.saltLength())
+	if _, err := io.ReadFull(o.random(), salt); err != nil {
+		return keySlotData{}, fmt.Errorf("failed to generate random salt: %w", err)
+	}
+	wrapKey, err := passwordWrapKey(p, salt, o.kdf)
+	if err != nil {
+		return keySlotData{}, err
+	}
+	wrapped, err := wrapFileKey(wrapKey, fileKey)
+	if err != nil {
+		return keySlotData{}, err
+	}
+
+	// Argon2id key slots are stored without the key derivation identifier, so that they can be
+	// read by earlier versions
+	slot := keySlotData{kind: keySlotPassword}
+	if o.kdf.kind() != keyDerivationArgon2ID {
+		slot = keySlotData{kind: keySlotPasswordKDF, body: []byte{byte(o.kdf.kind())}}
+	}
+	slot.body = o.kdf.marshal(slot.body)
+	slot.body = append(slot.body, salt...)
+	slot.body = append(slot.body, wrapped...)
+	return slot, nil
+}
+
+// wrap satisfies the KeySlot interface for the x25519KeySlot type.
+func (x x25519KeySlot) wrap(fileKey []byte, o *options) (keySlotData, error) {
+	return wrapX25519(fileKey, x.recipient, o.random())
+}
+
+// matches reports whether a key slot of this type is unwrapped with secrets of the given type. Key slots
+// with a password based key derivation other than Argon2id are unwrapped with passwords as well.
+func (k keySlotType) matches(kind keySlotType) bool {
+	return k == kind || (k == keySlotPasswordKDF && kind == keySlotPassword)
+}
+
+// keySlotData holds the file key wrapped for a single secret. Its body is serialized with its type and
+// length, so that key slots of types unknown to the decrypter can be skipped.
+type keySlotData struct {
+	kind keySlotType
+	body []byte
+}
+
+// marshal appends the serialized key slot to dst and returns the extended slice.
+func (s keySlotData) marshal(dst []byte) []byte {
+	dst = append(dst, byte(s.kind))
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(s.body)))
+	return append(dst, s.body...)
+}
+
+// readKeySlot reads a single key slot from the provided reader. The key derivation settings of password
+// key slots are validated against the given DecryptPolicy before the remaining key slot is read.
+func readKeySlot(r io.Reader, policy DecryptPolicy) (keySlotData, error) {
+	prefix := make([]byte, 1+keySlotLengthSize)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return keySlotData{}, fmt.Errorf("failed to read key slot: %w", err)
+	}
+	slot := keySlotData{kind: keySlotType(prefix[0])}
+	length := int(binary.BigEndian.Uint16(prefix[1:]))
+	slot.body = make([]byte, 0, length)
+
+	switch slot.kind {
+	case keySlotX25519:
+		if length != x25519SlotSize {
+			return keySlotData{}, fmt.Errorf("%w: X25519 key slot of %d bytes", ErrInvalidKeySlot, length)
+		}
+	case keySlotPassword:
+		if length < wa.SerializedSettingsLength {
+			return keySlotData{}, fmt.Errorf("%w: password key slot of %d bytes", ErrInvalidKeySlot, length)
+		}
+		slot.body = slot.body[:wa.SerializedSettingsLength]
+		if _, err := io.ReadFull(r, slot.body); err != nil {
+			return keySlotData{}, fmt.Errorf("failed to read key slot: %w", err)
+		}
+		settings := wa.SettingsFromBytes(slot.body)
+		if err := policy.checkSettings(settings); err != nil {
+			return keySlotData{}, err
+		}
+		if uint64(length) != uint64(wa.SerializedSettingsLength)+uint64(settings.SaltLength)+wrappedKeySize {
+			return keySlotData{}, fmt.Errorf("%w: password key slot of %d bytes", ErrInvalidKeySlot, length)
+		}
+	case keySlotPasswordKDF:
+		if length < 1 {
+			return keySlotData{}, fmt.Errorf("%w: password key slot of %d bytes", ErrInvalidKeySlot, length)
+		}
+		kind := make([]byte, 1)
+		if _, err := io.ReadFull(r, kind); err != nil {
+			return keySlotData{}, fmt.Errorf("failed to read key slot: %w", err)
+		}
+		params, err := readPasswordKDF(r, keyDerivation(kind[0]), policy)
+		if err != nil {
+			return keySlotData{}, err
+		}
+		slot.body = params.marshal(append(slot.body, kind[0]))
+		if uint64(length) != uint64(len(slot.body))+uint64(params.saltLength())+wrappedKeySize {
+			return keySlotData{}, fmt.Errorf("%w: password key slot of %d bytes", ErrInvalidKeySlot, length)
+		}
+	}
+
+	if _, err := io.ReadFull(r, slot.body[len(slot.body):length]); err != nil {
+		return keySlotData{}, fmt.Errorf("failed to read key slot: %w", err)
+	}
+	slot.body = slot.body[:length]
+	return slot, nil
+}
+
+// keySlotHeader returns a header with a random file key wrapped in a key slot for each of the given
+// KeySlots, along with the file key.
+func keySlotHeader(slots []KeySlot, o *options) (*header, []byte, error) {
+	if len(slots) == 0 {
+		return nil, nil, ErrNoRecipients
+	}
+	if len(slots) > maxKeySlots {
+		return nil, nil, fmt.Errorf("%w: at most %d key slots are supported", ErrInvalidKeySlot, maxKeySlots)
+	}
+	fileKey := make([]byte, fileKeySize)
+	if _, err := io.ReadFull(o.random(), fileKey); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate random file key: %w", err)
+	}
+
+	hdr := &header{suite: o.suite, kdf: keyDerivationKeySlots}
+	for _, slot := range slots {
+		if slot == nil {
+			return nil, nil, fmt.Errorf("%w: key slot must not be nil", ErrInvalidKeySlot)
+		}
+		data, err := slot.wrap(fileKey, o)
+		if err != nil {
+			return nil, nil, err
+		}
+		hdr.slots = append(hdr.slots, data)
+	}
+	return hdr, fileKey, nil
+}
+
+// wrapX25519 wraps the file key for the given X25519 recipient. An ephemeral key pair is generated,
+// and the file key is wrapped with a key derived from the shared secret of the ephemeral private key
+// and the recipient. The ephemeral public key is stored in the key slot along with the wrapped key.
+func wrapX25519(fileKey []byte, recipient *ecdh.PublicKey, random io.Reader) (keySlotData, error) {
+	if recipient == nil || recipient.Curve() != ecdh.X25519() {
+		return keySlotData{}, ErrInvalidRecipient
+	}
+	scalar := make([]byte, x25519KeySize)
+	if _, err := io.ReadFull(random, scalar); err != nil {
+		return keySlotData{}, fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+	ephemeral, err := ecdh.X25519().NewPrivateKey(scalar)
+	if err != nil {
+		return keySlotData{}, fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return keySlotData{}, fmt.Errorf("%w: %w", ErrInvalidRecipient, err)
+	}
+	ephemeralPublic := ephemeral.PublicKey().Bytes()
+	wrapKey, err := x25519WrapKey(shared, ephemeralPublic, recipient.Bytes())
+	if err != nil {
+		return keySlotData{}, err
+	}
+	wrapped, err := wrapFileKey(wrapKey, fileKey)
+	if err != nil {
+		return keySlotData{}, err
+	}
+	body := make([]byte, 0, x25519SlotSize)
+	body = append(body, ephemeralPublic...)
+	return keySlotData{kind: keySlotX25519, body: append(body, wrapped...)}, nil
+}
+
+// unwrapX25519 unwraps the file key from the given X25519 key slot using the given identity.
+func unwrapX25519(slot keySlotData, identity *ecdh.PrivateKey) ([]byte, error) {
+	if len(slot.body) != x25519SlotSize {
+		return nil, ErrInvalidKeySlot
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(slot.body[:x25519KeySize])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidKeySlot, err)
+	}
+	shared, err := identity.ECDH(ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidKeySlot, err)
+	}
+	wrapKey, err := x25519WrapKey(shared, ephemeral.Bytes(), identity.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return unwrapFileKey(wrapKey, slot.body[x25519KeySize:])
+}
+
+// unwrapPassword unwraps the file key from the given password key slot using the given password.
+func unwrapPassword(slot keySlotData, password []byte) ([]byte, error) {
+	params, salt, wrapped, err := parsePasswordSlot(slot)
+	if err != nil {
+		return nil, err
+	}
+	wrapKey, err := passwordWrapKey(password, salt, params)
+	if err != nil {
+		return nil, err
+	}
+	return unwrapFileKey(wrapKey, wrapped)
+}
+
+// parsePasswordSlot returns the password based key derivation, the salt and the wrapped file key
+// stored in the given password key slot.
+func parsePasswordSlot(slot keySlotData) (passwordKDF, []byte, []byte, error) {
+	kind, body := keyDerivationArgon2ID, slot.body
+	if slot.kind == keySlotPasswordKDF {
+		if len(body) < 1 {
+			return nil, nil, nil, ErrInvalidKeySlot
+		}
+		kind, body = keyDerivation(body[0]), body[1:]
+	}
+	length, err := passwordKDFLength(kind)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(body) < length {
+		return nil, nil, nil, ErrInvalidKeySlot
+	}
+	params := parsePasswordKDF(kind, body[:length])
+	saltEnd := uint64(length) + uint64(params.saltLength())
+	if uint64(len(body)) != saltEnd+wrappedKeySize {
+		return nil, nil, nil, ErrInvalidKeySlot
+	}
+	return params, body[length:saltEnd], body[saltEnd:], nil
+}
+
+// passwordWrapKey derives the key that wraps the file key in a password key slot from the given
+// password and salt using the given password based key derivation.
+func passwordWrapKey(password, salt []byte, params passwordKDF) ([]byte, error) {
+	key, err := params.deriveKey(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) < chacha20poly1305.KeySize {
+		return nil, fmt.Errorf("%w: key length of %d bytes", ErrInvalidKDFSettings, len(key))
+	}
+	return key[:chacha20poly1305.KeySize], nil
+}
+
+// x25519WrapKey derives the key that wraps the file key in an X25519 key slot from the shared secret,
+// bound to both the ephemeral and the recipient public key.
+func x25519WrapKey(shared, ephemeralPublic, recipientPublic []byte) ([]byte, error) {
+	salt := make([]byte, 0, 2*x25519KeySize)
+	salt = append(salt, ephemeralPublic...)
+	salt = append(salt, recipientPublic...)
+	wrapKey, err := hkdf.Key(hashFunc, shared, salt, x25519Info, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive wrapping key: %w", err)
+	}
+	return wrapKey, nil
+}
+
+// wrapFileKey encrypts and authenticates the file key with the given wrapping key using
+// ChaCha20-Poly1305. Since every wrapping key is only used once, a zero nonce is used.
+func wrapFileKey(wrapKey, fileKey []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(wrapKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ChaCha20-Poly1305 cipher: %w", err)
+	}
+	return aead.Seal(nil, make([]byte, aead.NonceSize()), fileKey, nil), nil
+}
+
+// unwrapFileKey authenticates and decrypts the wrapped file key with the given wrapping key.
+func unwrapFileKey(wrapKey, wrapped []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(wrapKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ChaCha20-Poly1305 cipher: %w", err)
+	}
+	fileKey, err := aead.Open(nil, make([]byte, aead.NonceSize()), wrapped, nil)
+	if err != nil {
+		return nil, ErrNoMatchingKeySlot
+	}
+	return fileKey, nil
+}
+
+// headerMAC returns the HMAC of the serialized header, excluding the header MAC itself, keyed with a
+// key derived from the file key.
+func headerMAC(fileKey []byte, hdr *header) ([]byte, error) {
+	macKey, err := hkdf.Key(hashFunc, fileKey, hdr.salt, headerMACInfo, hmacKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive header MAC key: %w", err)
+	}
+	mac := hmac.New(hashFunc, macKey)
+	mac.Write(hdr.raw[:len(hdr.raw)-hmacSize])
+	return mac.Sum(nil), nil
+}
+
+// sealHeader serializes the header and stores the header MAC computed with the given file key in it.
+func (h *header) sealHeader(fileKey []byte) error {
+	h.mac = make([]byte, hmacSize)
+	h.marshal()
+	mac, err := headerMAC(fileKey, h)
+	if err != nil {
+		return err
+	}
+	copy(h.mac, mac)
+	h.marshal()
+	return nil
+}
+
+// unwrapFileKey tries to unwrap the file key from each key slot of the given type using the unwrap
+// function. Once a key slot has been unwrapped, the header MAC is verified with the file key. It
+// returns the index of the unwrapped key slot along with the file key. If the header holds no key
+// slot of the given type, ErrKeyTypeMismatch is returned.
+func (h *header) unwrapFileKey(kind keySlotType, unwrap func(keySlotData) ([]byte, error)) (int, []byte, error) {
+	if h.kdf != keyDerivationKeySlots {
+		return 0, nil, ErrKeyTypeMismatch
+	}
+	matchingKind := false
+	for i, slot := range h.slots {
+		if !slot.kind.matches(kind) {
+			continue
+		}
+		matchingKind = true
+		fileKey, err := unwrap(slot)
+		if err != nil {
+			continue
+		}
+		mac, err := headerMAC(fileKey, h)
+		if err != nil {
+			return 0, nil, err
+		}
+		if !hmac.Equal(mac, h.mac) {
+			return 0, nil, ErrFailedAuthentication
+		}
+		return i, fileKey, nil
+	}
+	if !matchingKind {
+		return 0, nil, ErrKeyTypeMismatch
+	}
+	return 0, nil, ErrNoMatchingKeySlot
+}
+
+// fileKeys returns a keyFunc for the encrypter that seals the header with the given file key and
+// derives the keys from it using HKDF.
+func fileKeys(fileKey []byte) keyFunc {
+	return func(hdr *header) ([]byte, []byte, error) {
+		if err := hdr.sealHeader(fileKey); err != nil {
+			return nil, nil, err
+		}
+		return deriveKeysHKDF(fileKey, hdr.salt)
+	}
+}
+
+// slotKeys returns a keyFunc for the decrypter that unwraps the file key from the key slots of the
+// given type using the unwrap function and derives the keys from it using HKDF.
+func slotKeys(kind keySlotType, unwrap func(keySlotData) ([]byte, error)) keyFunc {
+	return func(hdr *header) ([]byte, []byte, error) {
+		_, fileKey, err := hdr.unwrapFileKey(kind, unwrap)
+		if err != nil {
+			return nil, nil, err
+		}
+		return deriveKeysHKDF(fileKey, hdr.salt)
+	}
+}
+
+// passwordSlotKeys returns a keyFunc that unwraps the file key from the password key slots using the
+// given password and derives the keys from it.
+func passwordSlotKeys(password []byte) keyFunc {
+	return slotKeys(keySlotPassword, passwordUnwrapper(password))
+}
+
+// passwordUnwrapper returns a function that unwraps the file key from a password key slot using the
+// given password.
+func passwordUnwrapper(password []byte) func(keySlotData) ([]byte, error) {
+	return func(slot keySlotData) ([]byte, error) {
+		return unwrapPassword(slot, password)
+	}
+}
+
+// identityKeys returns a keyFunc that unwraps the file key from the X25519 key slots using the given
+// identity and derives the keys from it.
+func identityKeys(identity *ecdh.PrivateKey) keyFunc {
+	return slotKeys(keySlotX25519, func(slot keySlotData) ([]byte, error) {
+		return unwrapX25519(slot, identity)
+	})
+}
@@ -0,0 +1,330 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+// keySlotsOffset is the offset of the first key slot in a header with key slots.
+var keySlotsOffset = len(headerMagic) + 3 + hkdfSaltSize + 1
+
+func TestNewEncrypterForRecipients(t *testing.T) {
+	plaintext := make([]byte, defaultSegmentSize+1)
+	if _, err := io.ReadFull(rand.Reader, plaintext); err != nil {
+		t.Fatalf("failed to generate plaintext: %s", err)
+	}
+	identities := make([]*ecdh.PrivateKey, 3)
+	recipients := make([]*ecdh.PublicKey, len(identities))
+	for i := range identities {
+		identities[i] = generateIdentity(t)
+		recipients[i] = identities[i].PublicKey()
+	}
+
+	t.Run("every recipient can decrypt", func(t *testing.T) {
+		ciphertext := encryptForRecipients(t, plaintext, recipients)
+		for _, identity := range identities {
+			decrypter, err := NewDecrypterWithIdentity(bytes.NewReader(ciphertext), identity)
+			if err != nil {
+				t.Fatalf("failed to create decrypter: %s", err)
+			}
+			decrypted, err := io.ReadAll(decrypter)
+			if err != nil {
+				t.Fatalf("failed to decrypt ciphertext: %s", err)
+			}
+			if !bytes.Equal(plaintext, decrypted) {
+				t.Error("plaintext and decrypted data do not match")
+			}
+		}
+	})
+	t.Run("encryption with the AEAD cipher suites", func(t *testing.T) {
+		for _, suite := range []CipherSuite{CipherSuiteAES256GCM, CipherSuiteXChaCha20Poly1305} {
+			encrypter, err := NewEncrypterForRecipients(bytes.NewReader(plaintext), recipients[:1],
+				WithCipherSuite(suite))
+			if err != nil {
+				t.Fatalf("failed to create encrypter: %s", err)
+			}
+			decrypter, err := NewDecrypterWithIdentity(encrypter, identities[0])
+			if err != nil {
+				t.Fatalf("failed to create decrypter: %s", err)
+			}
+			decrypted, err := io.ReadAll(decrypter)
+			if err != nil {
+				t.Fatalf("failed to decrypt ciphertext: %s", err)
+			}
+			if !bytes.Equal(plaintext, decrypted) {
+				t.Errorf("plaintext and decrypted data do not match for %s", suite)
+			}
+		}
+	})
+	t.Run("encryption without recipients should fail", func(t *testing.T) {
+		_, err := NewEncrypterForRecipients(bytes.NewReader(plaintext), nil)
+		if !errors.Is(err, ErrNoRecipients) {
+			t.Errorf("expected error to be %s, got %s", ErrNoRecipients, err)
+		}
+	})
+	t.Run("encryption for a non X25519 recipient should fail", func(t *testing.T) {
+		key, err := ecdh.P256().GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("failed to generate P-256 key: %s", err)
+		}
+		_, err = NewEncrypterForRecipients(bytes.NewReader(plaintext), []*ecdh.PublicKey{key.PublicKey()})
+		if !errors.Is(err, ErrInvalidRecipient) {
+			t.Errorf("expected error to be %s, got %s", ErrInvalidRecipient, err)
+		}
+	})
+	t.Run("encryption for too many recipients should fail", func(t *testing.T) {
+		tooMany := make([]*ecdh.PublicKey, maxKeySlots+1)
+		for i := range tooMany {
+			tooMany[i] = recipients[0]
+		}
+		_, err := NewEncrypterForRecipients(bytes.NewReader(plaintext), tooMany)
+		if !errors.Is(err, ErrInvalidKeySlot) {
+			t.Errorf("expected error to be %s, got %s", ErrInvalidKeySlot, err)
+		}
+	})
+	t.Run("encryption with failing random reader should fail", func(t *testing.T) {
+		_, err := NewEncrypterForRecipients(bytes.NewReader(plaintext), recipients,
+			WithRandReader(&failReadWriter{failOnRead: 0}))
+		if err == nil {
+			t.Error("expected encryption to fail with failing random reader")
+		}
+	})
+}
+
+func TestNewDecrypterWithIdentity(t *testing.T) {
+	plaintext := []byte("This is a test")
+	identity := generateIdentity(t)
+	ciphertext := encryptForRecipients(t, plaintext, []*ecdh.PublicKey{identity.PublicKey()})
+
+	t.Run("decryption with a different identity should fail", func(t *testing.T) {
+		_, err := NewDecrypterWithIdentity(bytes.NewReader(ciphertext), generateIdentity(t))
+		if !errors.Is(err, ErrNoMatchingKeySlot) {
+			t.Errorf("expected error to be %s, got %s", ErrNoMatchingKeySlot, err)
+		}
+	})
+	t.Run("decryption with a non X25519 identity should fail", func(t *testing.T) {
+		key, err := ecdh.P256().GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("failed to generate P-256 key: %s", err)
+		}
+		_, err = NewDecrypterWithIdentity(bytes.NewReader(ciphertext), key)
+		if !errors.Is(err, ErrInvalidRecipient) {
+			t.Errorf("expected error to be %s, got %s", ErrInvalidRecipient, err)
+		}
+	})
+	t.Run("decryption with password should fail", func(t *testing.T) {
+		_, err := NewDecrypter(bytes.NewReader(ciphertext), testPassword)
+		if !errors.Is(err, ErrKeyTypeMismatch) {
+			t.Errorf("expected error to be %s, got %s", ErrKeyTypeMismatch, err)
+		}
+	})
+	t.Run("decryption of password encrypted ciphertext should fail", func(t *testing.T) {
+		_, err := NewDecrypterWithIdentity(bytes.NewReader(encryptBytes(t, plaintext)), identity)
+		if !errors.Is(err, ErrKeyTypeMismatch) {
+			t.Errorf("expected error to be %s, got %s", ErrKeyTypeMismatch, err)
+		}
+	})
+	t.Run("decryption with tampered wrapped key should fail", func(t *testing.T) {
+		tampered := bytes.Clone(ciphertext)
+		tampered[keySlotsOffset+1+keySlotLengthSize+x25519KeySize] ^= 0xff
+		_, err := NewDecrypterWithIdentity(bytes.NewReader(tampered), identity)
+		if !errors.Is(err, ErrNoMatchingKeySlot) {
+			t.Errorf("expected error to be %s, got %s", ErrNoMatchingKeySlot, err)
+		}
+	})
+	t.Run("decryption with invalid key slot size should fail", func(t *testing.T) {
+		tampered := bytes.Clone(ciphertext)
+		tampered[keySlotsOffset+2] ^= 0xff
+		_, err := NewDecrypterWithIdentity(bytes.NewReader(tampered), identity)
+		if !errors.Is(err, ErrInvalidKeySlot) {
+			t.Errorf("expected error to be %s, got %s", ErrInvalidKeySlot, err)
+		}
+	})
+	t.Run("decryption without key slots should fail", func(t *testing.T) {
+		tampered := bytes.Clone(ciphertext)
+		tampered[keySlotsOffset-1] = 0
+		_, err := NewDecrypterWithIdentity(bytes.NewReader(tampered), identity)
+		if !errors.Is(err, ErrInvalidKeySlot) {
+			t.Errorf("expected error to be %s, got %s", ErrInvalidKeySlot, err)
+		}
+	})
+	t.Run("decryption with tampered header MAC should fail", func(t *testing.T) {
+		hdr := readTestHeader(t, ciphertext)
+		tampered := bytes.Clone(ciphertext)
+		tampered[len(hdr.raw)-1] ^= 0xff
+		_, err := NewDecrypterWithIdentity(bytes.NewReader(tampered), identity)
+		if !errors.Is(err, ErrFailedAuthentication) {
+			t.Errorf("expected error to be %s, got %s", ErrFailedAuthentication, err)
+		}
+	})
+	t.Run("decryption with truncated header should fail", func(t *testing.T) {
+		_, err := NewDecrypterWithIdentity(bytes.NewReader(ciphertext[:keySlotsOffset+10]), identity)
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("expected error to be %s, got %s", io.ErrUnexpectedEOF, err)
+		}
+	})
+	t.Run("unknown key slot types are skipped", func(t *testing.T) {
+		hdr := readTestHeader(t, ciphertext)
+		payload := ciphertext[len(hdr.raw):]
+		hdr.slots = append([]keySlotData{{kind: 0xff, body: []byte("unknown")}}, hdr.slots...)
+		hdr.marshal()
+		fileKey, err := unwrapX25519(hdr.slots[1], identity)
+		if err != nil {
+			t.Fatalf("failed to unwrap file key: %s", err)
+		}
+		if _, _, err = fileKeys(fileKey)(hdr); err != nil {
+			t.Fatalf("failed to compute header MAC: %s", err)
+		}
+		modified := append(bytes.Clone(hdr.raw), payload...)
+		decrypter, err := NewDecrypterWithIdentity(bytes.NewReader(modified), identity)
+		if err != nil {
+			t.Fatalf("failed to create decrypter: %s", err)
+		}
+		decrypted, err := io.ReadAll(decrypter)
+		if err != nil {
+			t.Fatalf("failed to decrypt ciphertext: %s", err)
+		}
+		if !bytes.Equal(plaintext, decrypted) {
+			t.Error("plaintext and decrypted data do not match")
+		}
+	})
+}
+
+func TestNewEncrypterWithKeySlots(t *testing.T) {
+	plaintext := []byte("This is a test")
+	otherPassword := []byte("another secure password")
+	identity := generateIdentity(t)
+	slots := []KeySlot{
+		PasswordKeySlot(testPassword),
+		X25519KeySlot(identity.PublicKey()),
+		PasswordKeySlot(otherPassword),
+	}
+	encrypter, err := NewEncrypterWithKeySlots(bytes.NewReader(plaintext), slots, WithArgon2(8*1024, 1, 1))
+	if err != nil {
+		t.Fatalf("failed to create encrypter: %s", err)
+	}
+	ciphertext, err := io.ReadAll(encrypter)
+	if err != nil {
+		t.Fatalf("failed to encrypt plaintext: %s", err)
+	}
+
+	t.Run("every password can decrypt", func(t *testing.T) {
+		for _, password := range [][]byte{testPassword, otherPassword} {
+			decrypter, err := NewDecrypter(bytes.NewReader(ciphertext), password)
+			if err != nil {
+				t.Fatalf("failed to create decrypter: %s", err)
+			}
+			decrypted, err := io.ReadAll(decrypter)
+			if err != nil {
+				t.Fatalf("failed to decrypt ciphertext: %s", err)
+			}
+			if !bytes.Equal(plaintext, decrypted) {
+				t.Error("plaintext and decrypted data do not match")
+			}
+		}
+	})
+	t.Run("the identity can decrypt", func(t *testing.T) {
+		decrypter, err := NewDecrypterWithIdentity(bytes.NewReader(ciphertext), identity)
+		if err != nil {
+			t.Fatalf("failed to create decrypter: %s", err)
+		}
+		decrypted, err := io.ReadAll(decrypter)
+		if err != nil {
+			t.Fatalf("failed to decrypt ciphertext: %s", err)
+		}
+		if !bytes.Equal(plaintext, decrypted) {
+			t.Error("plaintext and decrypted data do not match")
+		}
+	})
+	t.Run("the decrypt writer can decrypt with a password", func(t *testing.T) {
+		decrypted, err := decryptWithWriter(ciphertext, otherPassword, 7)
+		if err != nil {
+			t.Fatalf("failed to decrypt ciphertext: %s", err)
+		}
+		if !bytes.Equal(plaintext, decrypted) {
+			t.Error("plaintext and decrypted data do not match")
+		}
+	})
+	t.Run("decryption with wrong password should fail", func(t *testing.T) {
+		_, err := NewDecrypter(bytes.NewReader(ciphertext), []byte("wrong password"))
+		if !errors.Is(err, ErrNoMatchingKeySlot) {
+			t.Errorf("expected error to be %s, got %s", ErrNoMatchingKeySlot, err)
+		}
+	})
+	t.Run("decryption with too many password key slots should fail", func(t *testing.T) {
+		_, err := NewDecrypter(bytes.NewReader(ciphertext), testPassword,
+			WithPolicy(DecryptPolicy{MaxPasswordSlots: 1}))
+		if !errors.Is(err, ErrKeySlotLimitExceeded) {
+			t.Errorf("expected error to be %s, got %s", ErrKeySlotLimitExceeded, err)
+		}
+	})
+	t.Run("password key slot exceeding the policy should fail", func(t *testing.T) {
+		_, err := NewDecrypterWithIdentity(bytes.NewReader(ciphertext), identity,
+			WithPolicy(DecryptPolicy{MaxMemory: 1024}))
+		if !errors.Is(err, ErrMemoryLimitExceeded) {
+			t.Errorf("expected error to be %s, got %s", ErrMemoryLimitExceeded, err)
+		}
+	})
+	t.Run("encryption without key slots should fail", func(t *testing.T) {
+		_, err := NewEncrypterWithKeySlots(bytes.NewReader(plaintext), nil)
+		if !errors.Is(err, ErrNoRecipients) {
+			t.Errorf("expected error to be %s, got %s", ErrNoRecipients, err)
+		}
+	})
+	t.Run("encryption with nil key slot should fail", func(t *testing.T) {
+		_, err := NewEncrypterWithKeySlots(bytes.NewReader(plaintext), []KeySlot{nil})
+		if !errors.Is(err, ErrInvalidKeySlot) {
+			t.Errorf("expected error to be %s, got %s", ErrInvalidKeySlot, err)
+		}
+	})
+	t.Run("encryption with empty password key slot should fail", func(t *testing.T) {
+		_, err := NewEncrypterWithKeySlots(bytes.NewReader(plaintext), []KeySlot{PasswordKeySlot(nil)})
+		if !errors.Is(err, ErrPassPhraseEmpty) {
+			t.Errorf("expected error to be %s, got %s", ErrPassPhraseEmpty, err)
+		}
+	})
+}
+
+// generateIdentity returns a new random X25519 private key.
+func generateIdentity(t *testing.T) *ecdh.PrivateKey {
+	t.Helper()
+	identity, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate X25519 key: %s", err)
+	}
+	return identity
+}
+
+// encryptForRecipients encrypts the given plaintext for the given recipients and returns the
+// ciphertext.
+func encryptForRecipients(t *testing.T, plaintext []byte, recipients []*ecdh.PublicKey) []byte {
+	t.Helper()
+	encrypter, err := NewEncrypterForRecipients(bytes.NewReader(plaintext), recipients)
+	if err != nil {
+		t.Fatalf("failed to create encrypter: %s", err)
+	}
+	ciphertext, err := io.ReadAll(encrypter)
+	if err != nil {
+		t.Fatalf("failed to encrypt plaintext: %s", err)
+	}
+	return ciphertext
+}
+
+// readTestHeader parses the header of the given ciphertext using the default DecryptPolicy.
+func readTestHeader(t *testing.T, ciphertext []byte) *header {
+	t.Helper()
+	hdr, err := readHeader(bufio.NewReader(bytes.NewReader(ciphertext)), DefaultDecryptPolicy())
+	if err != nil {
+		t.Fatalf("failed to read header: %s", err)
+	}
+	return hdr
+}
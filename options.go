@@ -0,0 +1,265 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	wa "github.com/wneessen/argon2"
+)
+
+// ErrInvalidOption indicates that an Option has been given an invalid value.
+var ErrInvalidOption = errors.New("invalid option")
+
+// Option configures an encrypter or decrypter. Options that do not apply to the constructor they
+// are passed to are ignored, e.g. WithArgon2 has no effect on a decrypter, since the Argon2 settings
+// are read from the header of the ciphertext.
+type Option func(*options) error
+
+// options holds the configuration of an encrypter or decrypter.
+type options struct {
+	kdf         passwordKDF
+	suite       CipherSuite
+	segmentSize uint32
+	randReader  io.Reader
+	tempDir     string
+	unnamedTemp bool
+	spool       func(*options) (Spool, error)
+	encrypted   bool
+	policy      DecryptPolicy
+	concurrency int
+}
+
+// newOptions returns the default options with the given Options applied.
+func newOptions(opts ...Option) (*options, error) {
+	o := &options{
+		kdf:         argon2IDKDF(defaultSettings()),
+		suite:       defaultCipherSuite,
+		segmentSize: defaultSegmentSize,
+		policy:      DefaultDecryptPolicy(),
+		concurrency: 1,
+	}
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// random returns the configured source of randomness, or crypto/rand.Reader if none is configured.
+func (o *options) random() io.Reader {
+	if o.randReader != nil {
+		return o.randReader
+	}
+	return rand.Reader
+}
+
+// WithArgon2 configures the encrypter to derive the keys from the password using Argon2id with the
+// given settings, which is the default key derivation. The settings are stored in the header of the
+// ciphertext.
+func WithArgon2(memory, time uint32, threads uint8) Option {
+	return withArgon2(keyDerivationArgon2ID, memory, time, threads)
+}
+
+// WithArgon2i configures the encrypter to derive the keys from the password using Argon2i with the
+// given settings. Argon2id should be preferred, unless Argon2i is required for compatibility. The
+// settings are stored in the header of the ciphertext.
+func WithArgon2i(memory, time uint32, threads uint8) Option {
+	return withArgon2(keyDerivationArgon2I, memory, time, threads)
+}
+
+// withArgon2 returns an Option that configures the given Argon2 variant with the given settings.
+func withArgon2(variant keyDerivation, memory, time uint32, threads uint8) Option {
+	return func(o *options) error {
+		if time < 1 {
+			return fmt.Errorf("%w: %w", ErrInvalidOption, ErrTooLessRounds)
+		}
+		if threads < 1 {
+			return fmt.Errorf("%w: %w", ErrInvalidOption, ErrTooFewThreads)
+		}
+		settings := wa.NewSettings(memory, time, threads, saltSize, aesKeySize+hmacSize)
+		o.kdf = argon2KDF{variant: variant, settings: settings}
+		return nil
+	}
+}
+
+// WithScrypt configures the encrypter to derive the keys from the password using scrypt with the
+// cost N = 2^logN, the block size r and the parallelization p, e.g. 17, 8 and 1. The settings are
+// stored in the header of the ciphertext.
+func WithScrypt(logN uint8, r, p uint32) Option {
+	return func(o *options) error {
+		if logN < 1 || logN >= 63 || r < 1 || p < 1 || uint64(r)*uint64(p) >= 1<<30 {
+			return fmt.Errorf("%w: %w: N = 2^%d, r = %d, p = %d", ErrInvalidOption, ErrInvalidKDFSettings,
+				logN, r, p)
+		}
+		o.kdf = ScryptSettings{LogN: logN, R: r, P: p, SaltLength: saltSize, KeyLength: aesKeySize + hmacSize}
+		return nil
+	}
+}
+
+// WithPBKDF2 configures the encrypter to derive the keys from the password using PBKDF2-HMAC-SHA256
+// with the given number of iterations, e.g. 600000. Since PBKDF2 is not memory-hard, it should only
+// be used if it is mandated, e.g. by FIPS 140 requirements. The settings are stored in the header of
+// the ciphertext.
+func WithPBKDF2(iterations uint32) Option {
+	return func(o *options) error {
+		if iterations < 1 {
+			return fmt.Errorf("%w: %w", ErrInvalidOption, ErrTooLessRounds)
+		}
+		o.kdf = PBKDF2Settings{Iterations: iterations, SaltLength: saltSize, KeyLength: aesKeySize + hmacSize}
+		return nil
+	}
+}
+
+// WithCipherSuite configures the cipher suite that the encrypter uses to encrypt and authenticate
+// the segments. The cipher suite is stored in the header of the ciphertext.
+func WithCipherSuite(suite CipherSuite) Option {
+	return func(o *options) error {
+		if !suite.supported() {
+			return fmt.Errorf("%w: %w: %d", ErrInvalidOption, ErrUnsupportedCipherSuite, byte(suite))
+		}
+		o.suite = suite
+		return nil
+	}
+}
+
+// WithChunkSize configures the size in bytes of the plaintext of a single segment that the encrypter
+// uses. The segment size is stored in the header of the ciphertext. Larger segments reduce the
+// overhead of the authentication tags, while smaller segments reduce the memory usage and latency.
+// The size must be between 1 byte and 16 MiB.
+func WithChunkSize(size uint32) Option {
+	return func(o *options) error {
+		if size < 1 || size > maxSegmentSize {
+			return fmt.Errorf("%w: %w: %d", ErrInvalidOption, ErrInvalidSegmentSize, size)
+		}
+		o.segmentSize = size
+		return nil
+	}
+}
+
+// WithRandReader configures the source of randomness that the encrypter uses to generate salts and
+// IVs. It defaults to crypto/rand.Reader and should only be changed for testing purposes.
+func WithRandReader(r io.Reader) Option {
+	return func(o *options) error {
+		if r == nil {
+			return fmt.Errorf("%w: random reader must not be nil", ErrInvalidOption)
+		}
+		o.randReader = r
+		return nil
+	}
+}
+
+// WithTempDir configures the directory in which the decrypter creates temporary files. It defaults
+// to the directory returned by os.TempDir.
+func WithTempDir(dir string) Option {
+	return func(o *options) error {
+		o.tempDir = dir
+		return nil
+	}
+}
+
+// WithUnnamedTempFile configures the decrypter to create its temporary files with O_TMPFILE, so that
+// they have no name in the filesystem and are released by the kernel even if the process crashes. It
+// falls back to named temporary files on platforms other than Linux and on filesystems that do not
+// support O_TMPFILE.
+func WithUnnamedTempFile() Option {
+	return func(o *options) error {
+		o.unnamedTemp = true
+		return nil
+	}
+}
+
+// WithMemorySpool configures the decrypter to spool ciphertext in the legacy format in memory instead
+// of a temporary file, so that no writable filesystem is required. Since the whole ciphertext is held
+// in memory until it has been authenticated, it should only be used for small payloads.
+func WithMemorySpool() Option {
+	return func(o *options) error {
+		o.spool = func(*options) (Spool, error) {
+			return &memorySpool{}, nil
+		}
+		return nil
+	}
+}
+
+// WithHybridSpool configures the decrypter to spool ciphertext in the legacy format in memory, up to
+// the given threshold in bytes. Once the threshold is exceeded, the spooled ciphertext is moved into a
+// temporary file, which honors the WithTempDir, WithUnnamedTempFile and WithEncryptedSpool Options.
+func WithHybridSpool(threshold int64) Option {
+	return func(o *options) error {
+		if threshold < 0 {
+			return fmt.Errorf("%w: spool threshold must not be negative, got %d", ErrInvalidOption, threshold)
+		}
+		o.spool = func(o *options) (Spool, error) {
+			return &hybridSpool{
+				memory:    &memorySpool{},
+				threshold: threshold,
+				spill:     o.newFileSpool,
+			}, nil
+		}
+		return nil
+	}
+}
+
+// WithSpool configures a custom Spool for the decrypter to spool ciphertext in the legacy format to.
+// The given function is called once per decrypter to create a new Spool. The Spool is encrypted if
+// configured with the WithEncryptedSpool Option.
+func WithSpool(newSpool func() (Spool, error)) Option {
+	return func(o *options) error {
+		if newSpool == nil {
+			return fmt.Errorf("%w: spool function must not be nil", ErrInvalidOption)
+		}
+		o.spool = func(*options) (Spool, error) {
+			spool, err := newSpool()
+			if err != nil {
+				return nil, fmt.Errorf("failed to create spool: %w", err)
+			}
+			return o.protectSpool(spool)
+		}
+		return nil
+	}
+}
+
+// WithEncryptedSpool configures the decrypter to encrypt the ciphertext in the legacy format that it
+// spools into a temporary file or a custom Spool. It is encrypted and authenticated in blocks with
+// AES-256-GCM and an ephemeral key that only exists in memory. Each block is authenticated again when
+// it is read back for decryption, so that tampering with the spool after the ciphertext has been
+// authenticated is detected. Spooling in memory is not affected.
+func WithEncryptedSpool() Option {
+	return func(o *options) error {
+		o.encrypted = true
+		return nil
+	}
+}
+
+// WithPolicy configures the DecryptPolicy that the decrypter validates the header parameters
+// against. Zero value fields of the policy are replaced with the values of DefaultDecryptPolicy.
+func WithPolicy(policy DecryptPolicy) Option {
+	return func(o *options) error {
+		o.policy = policy.withDefaults()
+		return nil
+	}
+}
+
+// WithConcurrency configures the number of segments that the encrypter or decrypter seals or opens in
+// parallel. The output order is preserved and the ciphertext is identical to the one created
+// sequentially. Since one buffer per worker is allocated, the memory usage grows with the number of
+// workers, e.g. runtime.NumCPU(). It defaults to 1, which processes the segments sequentially. It
+// applies to the segmented stream format only.
+func WithConcurrency(workers int) Option {
+	return func(o *options) error {
+		if workers < 1 {
+			return fmt.Errorf("%w: concurrency must be at least 1, got %d", ErrInvalidOption, workers)
+		}
+		o.concurrency = workers
+		return nil
+	}
+}
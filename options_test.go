@@ -0,0 +1,146 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOptions(t *testing.T) {
+	plaintext := []byte("This is the plaintext that is split into many small segments")
+
+	t.Run("WithChunkSize splits plaintext into segments of the given size", func(t *testing.T) {
+		encrypter, err := NewEncrypter(bytes.NewReader(plaintext), testPassword, WithChunkSize(7),
+			WithCipherSuite(CipherSuiteAES256GCM))
+		if err != nil {
+			t.Fatalf("failed to create encrypter: %s", err)
+		}
+		ciphertext, err := io.ReadAll(encrypter)
+		if err != nil {
+			t.Fatalf("failed to encrypt plaintext: %s", err)
+		}
+		segments := (len(plaintext) + 6) / 7
+		overhead := len(ciphertext) - len(plaintext)
+		if overhead < segments*16 {
+			t.Errorf("expected at least %d bytes of overhead for %d segments, got %d", segments*16, segments,
+				overhead)
+		}
+
+		decrypter, err := NewDecrypter(bytes.NewReader(ciphertext), testPassword)
+		if err != nil {
+			t.Fatalf("failed to create decrypter: %s", err)
+		}
+		decrypted, err := io.ReadAll(decrypter)
+		if err != nil {
+			t.Fatalf("failed to decrypt ciphertext: %s", err)
+		}
+		if !bytes.Equal(plaintext, decrypted) {
+			t.Errorf("plaintext and decrypted data do not match, expected %s, got %s", plaintext, decrypted)
+		}
+	})
+	t.Run("WithRandReader makes the ciphertext deterministic", func(t *testing.T) {
+		var ciphertexts [2][]byte
+		for i := range ciphertexts {
+			random := bytes.NewReader(bytes.Repeat([]byte{0x42}, 1024))
+			encrypter, err := NewEncrypterWithKey(bytes.NewReader(plaintext), testKey, WithRandReader(random))
+			if err != nil {
+				t.Fatalf("failed to create encrypter: %s", err)
+			}
+			if ciphertexts[i], err = io.ReadAll(encrypter); err != nil {
+				t.Fatalf("failed to encrypt plaintext: %s", err)
+			}
+		}
+		if !bytes.Equal(ciphertexts[0], ciphertexts[1]) {
+			t.Error("expected ciphertexts with the same random source to be equal")
+		}
+	})
+	t.Run("WithRandReader with broken reader should fail", func(t *testing.T) {
+		_, err := NewEncrypter(bytes.NewReader(plaintext), testPassword,
+			WithRandReader(&failReadWriter{failOnRead: 0}))
+		if err == nil {
+			t.Fatal("expected encrypter creation to fail with broken random reader")
+		}
+		expErr := "failed to generate random salt"
+		if !strings.Contains(err.Error(), expErr) {
+			t.Errorf("expected error to contain %s, got %s", expErr, err)
+		}
+	})
+	t.Run("WithArgon2 settings are stored in the header", func(t *testing.T) {
+		writer := bytes.NewBuffer(nil)
+		encrypter, err := NewEncryptWriter(writer, testPassword, WithArgon2(8*1024, 1, 1))
+		if err != nil {
+			t.Fatalf("failed to create encrypter: %s", err)
+		}
+		if err = encrypter.Close(); err != nil {
+			t.Fatalf("failed to close encrypter: %s", err)
+		}
+		decrypter, err := NewDecrypter(writer, testPassword, WithPolicy(DecryptPolicy{MaxMemory: 8 * 1024}))
+		if err != nil {
+			t.Fatalf("failed to create decrypter: %s", err)
+		}
+		if _, err = io.ReadAll(decrypter); err != nil {
+			t.Errorf("failed to decrypt ciphertext: %s", err)
+		}
+	})
+	t.Run("WithTempDir is used for the legacy spool file", func(t *testing.T) {
+		legacy, err := newLegacyEncrypter(bytes.NewReader(plaintext), testPassword)
+		if err != nil {
+			t.Fatalf("failed to create legacy encrypter: %s", err)
+		}
+		_, err = NewDecrypter(legacy, testPassword, WithTempDir(filepath.Join(t.TempDir(), "missing")))
+		if err == nil {
+			t.Fatal("expected decryption to fail with non-existing temp dir")
+		}
+		expErr := "failed to create temporary file"
+		if !strings.Contains(err.Error(), expErr) {
+			t.Errorf("expected error to contain %s, got %s", expErr, err)
+		}
+	})
+	t.Run("nil options are ignored", func(t *testing.T) {
+		if _, err := newOptions(nil, WithChunkSize(1)); err != nil {
+			t.Errorf("expected nil option to be ignored, got %s", err)
+		}
+	})
+
+	invalid := []struct {
+		name    string
+		option  Option
+		wantErr error
+	}{
+		{"WithArgon2 with zero time", WithArgon2(defaultArgon2Memory, 0, 1), ErrTooLessRounds},
+		{"WithArgon2 with zero threads", WithArgon2(defaultArgon2Memory, 1, 0), ErrTooFewThreads},
+		{"WithArgon2i with zero time", WithArgon2i(defaultArgon2Memory, 0, 1), ErrTooLessRounds},
+		{"WithScrypt with zero cost", WithScrypt(0, 8, 1), ErrInvalidKDFSettings},
+		{"WithScrypt with zero block size", WithScrypt(10, 0, 1), ErrInvalidKDFSettings},
+		{"WithScrypt with too large r * p", WithScrypt(10, 1<<15, 1<<15), ErrInvalidKDFSettings},
+		{"WithPBKDF2 with zero iterations", WithPBKDF2(0), ErrTooLessRounds},
+		{"WithCipherSuite with unsupported suite", WithCipherSuite(CipherSuite(0)), ErrUnsupportedCipherSuite},
+		{"WithChunkSize with zero size", WithChunkSize(0), ErrInvalidSegmentSize},
+		{"WithChunkSize with too large size", WithChunkSize(maxSegmentSize + 1), ErrInvalidSegmentSize},
+		{"WithRandReader with nil reader", WithRandReader(nil), ErrInvalidOption},
+		{"WithConcurrency with zero workers", WithConcurrency(0), ErrInvalidOption},
+		{"WithHybridSpool with negative threshold", WithHybridSpool(-1), ErrInvalidOption},
+		{"WithSpool with nil function", WithSpool(nil), ErrInvalidOption},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name+" should fail", func(t *testing.T) {
+			_, err := NewEncrypter(bytes.NewReader(plaintext), testPassword, tt.option)
+			if !errors.Is(err, ErrInvalidOption) {
+				t.Errorf("expected error to be %s, got %s", ErrInvalidOption, err)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected error to be %s, got %s", tt.wantErr, err)
+			}
+			if _, err = NewDecrypter(bytes.NewReader(plaintext), testPassword, tt.option); !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected decrypter error to be %s, got %s", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"errors"
+	"fmt"
+
+	wa "github.com/wneessen/argon2"
+)
+
+const (
+	// defaultPolicyMaxMemory defines the default maximum memory in kibibytes for the Argon2 key
+	// derivation that is accepted when decrypting.
+	defaultPolicyMaxMemory = 1024 * 1024
+
+	// defaultPolicyMaxTime defines the default maximum number of iterations for the Argon2 key
+	// derivation that is accepted when decrypting.
+	defaultPolicyMaxTime = 16
+
+	// defaultPolicyMaxThreads defines the default maximum number of threads for the Argon2 key
+	// derivation that is accepted when decrypting.
+	defaultPolicyMaxThreads = 64
+
+	// defaultPolicyMinSaltLength defines the default minimum salt length in bytes that is accepted
+	// when decrypting.
+	defaultPolicyMinSaltLength = 16
+
+	// defaultPolicyMaxSaltLength defines the default maximum salt length in bytes that is accepted
+	// when decrypting.
+	defaultPolicyMaxSaltLength = 64
+
+	// defaultPolicyMaxKeyLength defines the default maximum Argon2 key length in bytes that is
+	// accepted when decrypting.
+	defaultPolicyMaxKeyLength = 128
+
+	// defaultPolicyMaxScryptParallelism defines the default maximum scrypt parallelization parameter
+	// that is accepted when decrypting.
+	defaultPolicyMaxScryptParallelism = 16
+
+	// defaultPolicyMaxPBKDF2Iterations defines the default maximum number of PBKDF2 iterations that
+	// is accepted when decrypting.
+	defaultPolicyMaxPBKDF2Iterations = 10_000_000
+
+	// defaultPolicyMaxPasswordSlots defines the default maximum number of password key slots that is
+	// accepted when decrypting.
+	defaultPolicyMaxPasswordSlots = 8
+
+	// minKeyLength defines the minimum Argon2 key length in bytes, which is required to derive the
+	// AES and HMAC keys.
+	minKeyLength = aesKeySize + hmacKeySize
+)
+
+var (
+	// ErrMemoryLimitExceeded indicates that the Argon2 memory stored in the header exceeds the
+	// maximum allowed by the DecryptPolicy.
+	ErrMemoryLimitExceeded = errors.New("argon2 memory exceeds the limit of the decrypt policy")
+
+	// ErrTimeLimitExceeded indicates that the Argon2 number of iterations stored in the header exceeds
+	// the maximum allowed by the DecryptPolicy.
+	ErrTimeLimitExceeded = errors.New("argon2 time exceeds the limit of the decrypt policy")
+
+	// ErrThreadLimitExceeded indicates that the Argon2 number of threads stored in the header exceeds
+	// the maximum allowed by the DecryptPolicy.
+	ErrThreadLimitExceeded = errors.New("argon2 threads exceed the limit of the decrypt policy")
+
+	// ErrParallelismLimitExceeded indicates that the scrypt parallelization parameter stored in the
+	// header exceeds the maximum allowed by the DecryptPolicy.
+	ErrParallelismLimitExceeded = errors.New("scrypt parallelization exceeds the limit of the decrypt policy")
+
+	// ErrIterationLimitExceeded indicates that the number of PBKDF2 iterations stored in the header
+	// exceeds the maximum allowed by the DecryptPolicy.
+	ErrIterationLimitExceeded = errors.New("PBKDF2 iterations exceed the limit of the decrypt policy")
+
+	// ErrTooFewThreads indicates that the Argon2 number of threads stored in the header is zero.
+	ErrTooFewThreads = errors.New("number of threads too small")
+
+	// ErrInvalidSaltLength indicates that the salt length stored in the header is outside of the range
+	// allowed by the DecryptPolicy.
+	ErrInvalidSaltLength = errors.New("salt length is not allowed by the decrypt policy")
+
+	// ErrInvalidKeyLength indicates that the Argon2 key length stored in the header is outside of the
+	// range allowed by the DecryptPolicy.
+	ErrInvalidKeyLength = errors.New("key length is not allowed by the decrypt policy")
+
+	// ErrKeySlotLimitExceeded indicates that the number of password key slots stored in the header
+	// exceeds the maximum allowed by the DecryptPolicy.
+	ErrKeySlotLimitExceeded = errors.New("number of password key slots exceeds the limit of the decrypt policy")
+)
+
+// DecryptPolicy defines upper and lower bounds for the key derivation parameters that are read from
+// the header of the ciphertext. Since the header is not authenticated before the keys have been
+// derived, the policy is enforced before any memory is allocated or any key derivation takes place,
+// so that decrypting untrusted data cannot be turned into a memory or CPU exhaustion attack.
+//
+// Fields with a zero value are replaced with the corresponding value of DefaultDecryptPolicy.
+type DecryptPolicy struct {
+	// MaxMemory is the maximum Argon2 memory in kibibytes. It limits the memory of the scrypt key
+	// derivation, which is 128 * R * (N + P) + 256 * R bytes, as well.
+	MaxMemory uint32
+
+	// MaxTime is the maximum number of Argon2 iterations.
+	MaxTime uint32
+
+	// MaxThreads is the maximum number of Argon2 threads.
+	MaxThreads uint8
+
+	// MaxScryptParallelism is the maximum scrypt parallelization parameter P. Since the blocks are
+	// mixed sequentially, the time of the scrypt key derivation grows linearly with it.
+	MaxScryptParallelism uint32
+
+	// MaxPBKDF2Iterations is the maximum number of PBKDF2 iterations.
+	MaxPBKDF2Iterations uint32
+
+	// MinSaltLength and MaxSaltLength are the bounds for the salt length in bytes.
+	MinSaltLength uint32
+	MaxSaltLength uint32
+
+	// MinKeyLength and MaxKeyLength are the bounds for the derived key length in bytes. Key lengths
+	// below 64 bytes are never accepted, since they do not suffice to derive the AES and HMAC keys.
+	MinKeyLength uint32
+	MaxKeyLength uint32
+
+	// MaxSegmentSize is the maximum plaintext size in bytes of a single segment, which determines the
+	// size of the buffers allocated by the decrypter.
+	MaxSegmentSize uint32
+
+	// MaxPasswordSlots is the maximum number of password key slots. Each password key slot has its own
+	// Argon2 settings, which are validated against the policy as well.
+	MaxPasswordSlots uint8
+}
+
+// PolicyError is the error returned if a parameter in the header of the ciphertext violates the
+// DecryptPolicy. It wraps one of the policy sentinel errors, so it can be checked with errors.Is.
+type PolicyError struct {
+	// Err is the sentinel error identifying the violated limit.
+	Err error
+
+	// Value is the value read from the header.
+	Value uint64
+
+	// Limit is the limit of the policy that has been violated.
+	Limit uint64
+}
+
+// Error satisfies the error interface for the PolicyError type.
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("%s (value: %d, limit: %d)", e.Err, e.Value, e.Limit)
+}
+
+// Unwrap returns the sentinel error wrapped by the PolicyError.
+func (e *PolicyError) Unwrap() error {
+	return e.Err
+}
+
+// DefaultDecryptPolicy returns the DecryptPolicy that is used by NewDecrypter. It accepts up to 1 GiB
+// of Argon2 or scrypt memory, 16 Argon2 iterations, 64 Argon2 threads, a scrypt parallelization of 16,
+// 10 million PBKDF2 iterations, salts between 16 and 64 bytes, key lengths between 64 and 128 bytes,
+// segments of up to 16 MiB and up to 8 password key slots.
+func DefaultDecryptPolicy() DecryptPolicy {
+	return DecryptPolicy{
+		MaxMemory:            defaultPolicyMaxMemory,
+		MaxTime:              defaultPolicyMaxTime,
+		MaxThreads:           defaultPolicyMaxThreads,
+		MaxScryptParallelism: defaultPolicyMaxScryptParallelism,
+		MaxPBKDF2Iterations:  defaultPolicyMaxPBKDF2Iterations,
+		MinSaltLength:        defaultPolicyMinSaltLength,
+		MaxSaltLength:        defaultPolicyMaxSaltLength,
+		MinKeyLength:         minKeyLength,
+		MaxKeyLength:         defaultPolicyMaxKeyLength,
+		MaxSegmentSize:       maxSegmentSize,
+		MaxPasswordSlots:     defaultPolicyMaxPasswordSlots,
+	}
+}
+
+// withDefaults returns a copy of the DecryptPolicy with all zero value fields replaced with the
+// values of the DefaultDecryptPolicy.
+func (p DecryptPolicy) withDefaults() DecryptPolicy {
+	defaults := DefaultDecryptPolicy()
+	if p.MaxMemory == 0 {
+		p.MaxMemory = defaults.MaxMemory
+	}
+	if p.MaxTime == 0 {
+		p.MaxTime = defaults.MaxTime
+	}
+	if p.MaxThreads == 0 {
+		p.MaxThreads = defaults.MaxThreads
+	}
+	if p.MaxScryptParallelism == 0 {
+		p.MaxScryptParallelism = defaults.MaxScryptParallelism
+	}
+	if p.MaxPBKDF2Iterations == 0 {
+		p.MaxPBKDF2Iterations = defaults.MaxPBKDF2Iterations
+	}
+	if p.MinSaltLength == 0 {
+		p.MinSaltLength = defaults.MinSaltLength
+	}
+	if p.MaxSaltLength == 0 {
+		p.MaxSaltLength = defaults.MaxSaltLength
+	}
+	if p.MinKeyLength < minKeyLength {
+		p.MinKeyLength = minKeyLength
+	}
+	if p.MaxKeyLength == 0 {
+		p.MaxKeyLength = defaults.MaxKeyLength
+	}
+	if p.MaxSegmentSize == 0 || p.MaxSegmentSize > maxSegmentSize {
+		p.MaxSegmentSize = defaults.MaxSegmentSize
+	}
+	if p.MaxPasswordSlots == 0 {
+		p.MaxPasswordSlots = defaults.MaxPasswordSlots
+	}
+	return p
+}
+
+// checkSettings validates the given Argon2 settings against the DecryptPolicy.
+func (p DecryptPolicy) checkSettings(settings wa.Settings) error {
+	switch {
+	case settings.Time < 1:
+		return ErrTooLessRounds
+	case settings.Threads < 1:
+		return ErrTooFewThreads
+	case settings.Memory > p.MaxMemory:
+		return policyError(ErrMemoryLimitExceeded, uint64(settings.Memory), uint64(p.MaxMemory))
+	case settings.Time > p.MaxTime:
+		return policyError(ErrTimeLimitExceeded, uint64(settings.Time), uint64(p.MaxTime))
+	case settings.Threads > p.MaxThreads:
+		return policyError(ErrThreadLimitExceeded, uint64(settings.Threads), uint64(p.MaxThreads))
+	default:
+		return p.checkLengths(settings.SaltLength, settings.KeyLength)
+	}
+}
+
+// checkScryptSettings validates the given scrypt settings against the DecryptPolicy. The key derivation
+// requires 128 * R * (N + P) + 256 * R bytes of memory, which are limited by MaxMemory. Since the time
+// grows with N * R * P, N * R is bounded by the memory limit and P by MaxScryptParallelism. R * P
+// must be below 2^30, as required by scrypt.
+func (p DecryptPolicy) checkScryptSettings(settings ScryptSettings) error {
+	switch {
+	case settings.LogN < 1 || settings.R < 1:
+		return ErrTooLessRounds
+	case settings.P < 1:
+		return ErrTooFewThreads
+	case uint64(settings.R)*uint64(settings.P) >= 1<<30:
+		return fmt.Errorf("%w: scrypt r * p of %d", ErrInvalidKDFSettings, uint64(settings.R)*uint64(settings.P))
+	case settings.memory() > uint64(p.MaxMemory):
+		return policyError(ErrMemoryLimitExceeded, settings.memory(), uint64(p.MaxMemory))
+	case settings.P > p.MaxScryptParallelism:
+		return policyError(ErrParallelismLimitExceeded, uint64(settings.P), uint64(p.MaxScryptParallelism))
+	default:
+		return p.checkLengths(settings.SaltLength, settings.KeyLength)
+	}
+}
+
+// checkPBKDF2Settings validates the given PBKDF2 settings against the DecryptPolicy.
+func (p DecryptPolicy) checkPBKDF2Settings(settings PBKDF2Settings) error {
+	switch {
+	case settings.Iterations < 1:
+		return ErrTooLessRounds
+	case settings.Iterations > p.MaxPBKDF2Iterations:
+		return policyError(ErrIterationLimitExceeded, uint64(settings.Iterations), uint64(p.MaxPBKDF2Iterations))
+	default:
+		return p.checkLengths(settings.SaltLength, settings.KeyLength)
+	}
+}
+
+// checkLengths validates the given salt and key length of a password based key derivation against
+// the DecryptPolicy.
+func (p DecryptPolicy) checkLengths(saltLength, keyLength uint32) error {
+	switch {
+	case saltLength < p.MinSaltLength:
+		return policyError(ErrInvalidSaltLength, uint64(saltLength), uint64(p.MinSaltLength))
+	case saltLength > p.MaxSaltLength:
+		return policyError(ErrInvalidSaltLength, uint64(saltLength), uint64(p.MaxSaltLength))
+	case keyLength < p.MinKeyLength:
+		return policyError(ErrInvalidKeyLength, uint64(keyLength), uint64(p.MinKeyLength))
+	case keyLength > p.MaxKeyLength:
+		return policyError(ErrInvalidKeyLength, uint64(keyLength), uint64(p.MaxKeyLength))
+	default:
+		return nil
+	}
+}
+
+// policyError returns a new PolicyError for the given sentinel error, value and limit.
+func policyError(err error, value, limit uint64) *PolicyError {
+	return &PolicyError{Err: err, Value: value, Limit: limit}
+}
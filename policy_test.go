@@ -0,0 +1,180 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	wa "github.com/wneessen/argon2"
+)
+
+func TestNewDecrypterWithPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings wa.Settings
+		wantErr  error
+	}{
+		{
+			"huge memory should fail", wa.NewSettings(4*1024*1024*1024-1, 1, 1, saltSize, aesKeySize+hmacSize),
+			ErrMemoryLimitExceeded,
+		},
+		{
+			"huge time should fail", wa.NewSettings(defaultArgon2Memory, 1<<31, 1, saltSize, aesKeySize+hmacSize),
+			ErrTimeLimitExceeded,
+		},
+		{
+			"too many threads should fail", wa.NewSettings(defaultArgon2Memory, 1, 255, saltSize, aesKeySize+hmacSize),
+			ErrThreadLimitExceeded,
+		},
+		{
+			"zero threads should fail", wa.NewSettings(defaultArgon2Memory, 1, 0, saltSize, aesKeySize+hmacSize),
+			ErrTooFewThreads,
+		},
+		{
+			"huge salt length should fail", wa.NewSettings(defaultArgon2Memory, 1, 1, 1<<31, aesKeySize+hmacSize),
+			ErrInvalidSaltLength,
+		},
+		{
+			"short salt length should fail", wa.NewSettings(defaultArgon2Memory, 1, 1, 4, aesKeySize+hmacSize),
+			ErrInvalidSaltLength,
+		},
+		{
+			"short key length should fail", wa.NewSettings(defaultArgon2Memory, 1, 1, saltSize, aesKeySize),
+			ErrInvalidKeyLength,
+		},
+		{
+			"huge key length should fail", wa.NewSettings(defaultArgon2Memory, 1, 1, saltSize, 1<<31),
+			ErrInvalidKeyLength,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hdr := &header{
+				version:     currentFormatVersion,
+				suite:       defaultCipherSuite,
+				kdf:         keyDerivationArgon2ID,
+				params:      argon2IDKDF(tt.settings),
+				segmentSize: defaultSegmentSize,
+			}
+			ciphertext := hdr.marshal()
+			_, err := NewDecrypterWithPolicy(bytes.NewReader(ciphertext), testPassword, DecryptPolicy{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected error to be %s, got %s", tt.wantErr, err)
+			}
+		})
+	}
+
+	kdfTests := []struct {
+		name    string
+		params  passwordKDF
+		wantErr error
+	}{
+		{"huge scrypt memory should fail", ScryptSettings{21, 8, 1, saltSize, aesKeySize + hmacSize}, ErrMemoryLimitExceeded},
+		{"huge scrypt cost should fail", ScryptSettings{200, 8, 1, saltSize, aesKeySize + hmacSize}, ErrMemoryLimitExceeded},
+		{
+			"huge scrypt block size and parallelization should fail",
+			ScryptSettings{1, 1 << 22, 16, saltSize, aesKeySize + hmacSize}, ErrMemoryLimitExceeded,
+		},
+		{
+			"scrypt block size times parallelization overflow should fail",
+			ScryptSettings{1, 1 << 27, 8, saltSize, aesKeySize + hmacSize}, ErrInvalidKDFSettings,
+		},
+		{
+			"huge scrypt parallelization should fail", ScryptSettings{10, 8, 17, saltSize, aesKeySize + hmacSize},
+			ErrParallelismLimitExceeded,
+		},
+		{"zero scrypt cost should fail", ScryptSettings{0, 8, 1, saltSize, aesKeySize + hmacSize}, ErrTooLessRounds},
+		{
+			"zero scrypt parallelization should fail", ScryptSettings{10, 8, 0, saltSize, aesKeySize + hmacSize},
+			ErrTooFewThreads,
+		},
+		{"short scrypt salt length should fail", ScryptSettings{10, 8, 1, 4, aesKeySize + hmacSize}, ErrInvalidSaltLength},
+		{
+			"huge PBKDF2 iterations should fail", PBKDF2Settings{1 << 31, saltSize, aesKeySize + hmacSize},
+			ErrIterationLimitExceeded,
+		},
+		{"zero PBKDF2 iterations should fail", PBKDF2Settings{0, saltSize, aesKeySize + hmacSize}, ErrTooLessRounds},
+		{"short PBKDF2 key length should fail", PBKDF2Settings{1000, saltSize, aesKeySize}, ErrInvalidKeyLength},
+	}
+	for _, tt := range kdfTests {
+		t.Run(tt.name, func(t *testing.T) {
+			hdr := &header{
+				version:     currentFormatVersion,
+				suite:       defaultCipherSuite,
+				kdf:         tt.params.kind(),
+				params:      tt.params,
+				segmentSize: defaultSegmentSize,
+			}
+			ciphertext := hdr.marshal()
+			_, err := NewDecrypterWithPolicy(bytes.NewReader(ciphertext), testPassword, DecryptPolicy{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected error to be %s, got %s", tt.wantErr, err)
+			}
+		})
+	}
+
+	ciphertext := encryptBytes(t, []byte("This is the plaintext"))
+	t.Run("decryption within the default policy succeeds", func(t *testing.T) {
+		decrypter, err := NewDecrypterWithPolicy(bytes.NewReader(ciphertext), testPassword, DecryptPolicy{})
+		if err != nil {
+			t.Fatalf("failed to create decrypter: %s", err)
+		}
+		if _, err = io.ReadAll(decrypter); err != nil {
+			t.Errorf("failed to decrypt ciphertext: %s", err)
+		}
+	})
+	t.Run("decryption with stricter policy fails with policy error", func(t *testing.T) {
+		policy := DecryptPolicy{MaxMemory: defaultArgon2Memory / 2}
+		_, err := NewDecrypterWithPolicy(bytes.NewReader(ciphertext), testPassword, policy)
+		var policyErr *PolicyError
+		if !errors.As(err, &policyErr) {
+			t.Fatalf("expected error to be a PolicyError, got %s", err)
+		}
+		if !errors.Is(policyErr, ErrMemoryLimitExceeded) {
+			t.Errorf("expected error to be %s, got %s", ErrMemoryLimitExceeded, policyErr.Err)
+		}
+		if policyErr.Value != defaultArgon2Memory || policyErr.Limit != defaultArgon2Memory/2 {
+			t.Errorf("expected value %d and limit %d, got %d and %d", defaultArgon2Memory,
+				defaultArgon2Memory/2, policyErr.Value, policyErr.Limit)
+		}
+	})
+	t.Run("decryption with too small segment size limit should fail", func(t *testing.T) {
+		policy := DecryptPolicy{MaxSegmentSize: defaultSegmentSize - 1}
+		_, err := NewDecrypterWithPolicy(bytes.NewReader(ciphertext), testPassword, policy)
+		if !errors.Is(err, ErrInvalidSegmentSize) {
+			t.Errorf("expected error to be %s, got %s", ErrInvalidSegmentSize, err)
+		}
+	})
+	t.Run("decrypter creation with nil passphrase should fail", func(t *testing.T) {
+		_, err := NewDecrypterWithPolicy(bytes.NewReader(ciphertext), nil, DecryptPolicy{})
+		if !errors.Is(err, ErrPassPhraseEmpty) {
+			t.Errorf("expected error to be %s, got %s", ErrPassPhraseEmpty, err)
+		}
+	})
+}
+
+func TestDecryptPolicy_withDefaults(t *testing.T) {
+	t.Run("zero value policy equals the default policy", func(t *testing.T) {
+		if got := (DecryptPolicy{}).withDefaults(); got != DefaultDecryptPolicy() {
+			t.Errorf("expected policy to be %+v, got %+v", DefaultDecryptPolicy(), got)
+		}
+	})
+	t.Run("non-zero values are kept", func(t *testing.T) {
+		policy := DecryptPolicy{MaxMemory: 1024, MaxTime: 1, MaxThreads: 1, MaxSegmentSize: 1024}
+		got := policy.withDefaults()
+		if got.MaxMemory != 1024 || got.MaxTime != 1 || got.MaxThreads != 1 || got.MaxSegmentSize != 1024 {
+			t.Errorf("expected non-zero values to be kept, got %+v", got)
+		}
+	})
+	t.Run("key length below the minimum is raised", func(t *testing.T) {
+		got := DecryptPolicy{MinKeyLength: 1}.withDefaults()
+		if got.MinKeyLength != minKeyLength {
+			t.Errorf("expected minimum key length to be %d, got %d", minKeyLength, got.MinKeyLength)
+		}
+	})
+}
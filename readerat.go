@@ -0,0 +1,206 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ErrInvalidOffset indicates that a read or seek has been requested at a negative offset.
+var ErrInvalidOffset = errors.New("invalid offset")
+
+// ReadSeekerAt is the interface that groups the io.ReadSeeker and io.ReaderAt interfaces. It is
+// implemented by the decrypter returned by NewDecrypterAt.
+type ReadSeekerAt interface {
+	io.ReadSeeker
+	io.ReaderAt
+}
+
+// decrypterAt provides random access to the plaintext of ciphertext in the segmented stream format.
+// Only the segments that are touched by a read are read, authenticated and decrypted. The most
+// recently decrypted segment is cached, so that sequential reads do not authenticate the same segment
+// repeatedly.
+type decrypterAt struct {
+	reader      io.ReaderAt
+	cipher      segmentCipher
+	start       int64
+	segmentSize int64
+	sealedSize  int64
+	segments    int64
+	payload     int64
+	size        int64
+	position    int64
+
+	// mu guards the buffers and the cache, so that ReadAt can be called concurrently.
+	mu     sync.Mutex
+	sealed []byte
+	plain  []byte
+	cached int64
+}
+
+// NewDecrypterAt returns a ReadSeekerAt that provides random access to the plaintext of the
+// ciphertext of the given size read from r, decrypted with a key derived from the given password. It
+// accepts the same Options as NewDecrypter.
+//
+// Reads only authenticate and decrypt the segments they touch, which makes it suitable to serve HTTP
+// range requests or to open encrypted files that are accessed at random offsets. The final segment
+// is authenticated before NewDecrypterAt returns, so that the plaintext size reported by Seek is
+// trustworthy and truncation of the ciphertext is detected. Ciphertext in the legacy format cannot be
+// accessed randomly, since it is authenticated as a whole, and is rejected with ErrUnsupportedVersion.
+//
+// ReadAt may be called concurrently, while Read and Seek share an offset and must not be called
+// concurrently.
+func NewDecrypterAt(r io.ReaderAt, size int64, pass []byte, opts ...Option) (ReadSeekerAt, error) {
+	if len(pass) == 0 {
+		return nil, ErrPassPhraseEmpty
+	}
+	o, err := newOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return newDecrypterAt(r, size, passwordKeys(pass), o)
+}
+
+// newDecrypterAt reads the header from r, derives the keys using the given keyFunc and returns a
+// decrypterAt for the segments that follow the header, after authenticating the final segment.
+func newDecrypterAt(r io.ReaderAt, size int64, keys keyFunc, o *options) (*decrypterAt, error) {
+	buffer := bufio.NewReaderSize(io.NewSectionReader(r, 0, size), chunkSize)
+	hdr, encKey, hmacKey, err := readParameters(buffer, keys, o.policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption parameters: %w", err)
+	}
+	if hdr.version == formatVersionLegacy {
+		return nil, fmt.Errorf("%w: legacy format does not support random access", ErrUnsupportedVersion)
+	}
+	segCipher, err := newSegmentCipher(hdr.suite, encKey, hmacKey, hdr.iv, hdr.ad, int(hdr.segmentSize))
+	if err != nil {
+		return nil, err
+	}
+
+	decrypter := &decrypterAt{
+		reader:      r,
+		cipher:      segCipher,
+		start:       int64(len(hdr.raw)),
+		segmentSize: int64(hdr.segmentSize),
+		sealedSize:  int64(hdr.segmentSize) + int64(segCipher.overhead()),
+		payload:     size - int64(len(hdr.raw)),
+		cached:      -1,
+	}
+	decrypter.sealed = make([]byte, decrypter.sealedSize)
+	decrypter.plain = make([]byte, 0, decrypter.segmentSize)
+
+	// Every segment is at least as long as the overhead, including the final segment of an empty
+	// plaintext
+	decrypter.segments = (decrypter.payload + decrypter.sealedSize - 1) / decrypter.sealedSize
+	if decrypter.payload < int64(segCipher.overhead()) ||
+		decrypter.payload-(decrypter.segments-1)*decrypter.sealedSize < int64(segCipher.overhead()) {
+		return nil, ErrMissingData
+	}
+	decrypter.size = decrypter.payload - decrypter.segments*int64(segCipher.overhead())
+
+	// Authenticate the final segment right away, so that an incorrect password, a truncated
+	// ciphertext or corrupted data is reported by the constructor
+	decrypter.mu.Lock()
+	defer decrypter.mu.Unlock()
+	if err = decrypter.openSegment(decrypter.segments - 1); err != nil {
+		return nil, err
+	}
+	return decrypter, nil
+}
+
+// ReadAt satisfies the io.ReaderAt interface for the decrypterAt type.
+func (d *decrypterAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("%w: %d", ErrInvalidOffset, off)
+	}
+	if off >= d.size {
+		return 0, io.EOF
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	read := 0
+	for read < len(p) && off < d.size {
+		index := off / d.segmentSize
+		if err := d.openSegment(index); err != nil {
+			return read, err
+		}
+		n := copy(p[read:], d.plain[off-index*d.segmentSize:])
+		read += n
+		off += int64(n)
+	}
+	if read < len(p) {
+		return read, io.EOF
+	}
+	return read, nil
+}
+
+// Read satisfies the io.Reader interface for the decrypterAt type.
+func (d *decrypterAt) Read(p []byte) (int, error) {
+	if d.position >= d.size {
+		return 0, io.EOF
+	}
+	n, err := d.ReadAt(p, d.position)
+	d.position += int64(n)
+	if errors.Is(err, io.EOF) && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek satisfies the io.Seeker interface for the decrypterAt type. Offsets are relative to the
+// plaintext.
+func (d *decrypterAt) Seek(offset int64, whence int) (int64, error) {
+	var position int64
+	switch whence {
+	case io.SeekStart:
+		position = offset
+	case io.SeekCurrent:
+		position = d.position + offset
+	case io.SeekEnd:
+		position = d.size + offset
+	default:
+		return 0, fmt.Errorf("%w: invalid whence %d", ErrInvalidOffset, whence)
+	}
+	if position < 0 {
+		return 0, fmt.Errorf("%w: %d", ErrInvalidOffset, position)
+	}
+	d.position = position
+	return position, nil
+}
+
+// openSegment reads, authenticates and decrypts the segment with the given index into the plain
+// buffer, unless it is already cached. The caller must hold the mutex.
+func (d *decrypterAt) openSegment(index int64) error {
+	if d.cached == index {
+		return nil
+	}
+	d.cached = -1
+
+	final := index == d.segments-1
+	length := d.sealedSize
+	if final {
+		length = d.payload - index*d.sealedSize
+	}
+	n, err := d.reader.ReadAt(d.sealed[:length], d.start+index*d.sealedSize)
+	if int64(n) < length {
+		if err == nil || errors.Is(err, io.EOF) {
+			return ErrMissingData
+		}
+		return fmt.Errorf("failed to read bytes from reader: %w", err)
+	}
+
+	plain, err := d.cipher.open(d.plain[:0], d.sealed[:length], uint64(index), final)
+	if err != nil {
+		return err
+	}
+	d.plain = plain
+	d.cached = index
+	return nil
+}
@@ -0,0 +1,218 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestNewDecrypterAt(t *testing.T) {
+	const segmentSize = 100
+	plaintext := make([]byte, 10*segmentSize+42)
+	if _, err := io.ReadFull(rand.Reader, plaintext); err != nil {
+		t.Fatalf("failed to generate plaintext: %s", err)
+	}
+	ciphertext := encryptWithChunkSize(t, plaintext, segmentSize)
+
+	t.Run("reading at random offsets", func(t *testing.T) {
+		decrypter := newTestDecrypterAt(t, ciphertext)
+		offsets := []struct {
+			offset int64
+			length int
+		}{
+			{0, 10}, {95, 10}, {segmentSize, segmentSize}, {250, 333}, {int64(len(plaintext)) - 5, 5}, {0, len(plaintext)},
+		}
+		for _, tt := range offsets {
+			buffer := make([]byte, tt.length)
+			n, err := decrypter.ReadAt(buffer, tt.offset)
+			if err != nil {
+				t.Fatalf("failed to read at offset %d: %s", tt.offset, err)
+			}
+			if !bytes.Equal(buffer[:n], plaintext[tt.offset:tt.offset+int64(tt.length)]) {
+				t.Errorf("plaintext and decrypted data at offset %d do not match", tt.offset)
+			}
+		}
+	})
+	t.Run("reading beyond the end returns io.EOF", func(t *testing.T) {
+		decrypter := newTestDecrypterAt(t, ciphertext)
+		buffer := make([]byte, 10)
+		n, err := decrypter.ReadAt(buffer, int64(len(plaintext))-4)
+		if n != 4 || !errors.Is(err, io.EOF) {
+			t.Errorf("expected 4 bytes and io.EOF, got %d bytes and %v", n, err)
+		}
+		if _, err = decrypter.ReadAt(buffer, int64(len(plaintext))); !errors.Is(err, io.EOF) {
+			t.Errorf("expected error to be %s, got %s", io.EOF, err)
+		}
+	})
+	t.Run("reading at negative offset should fail", func(t *testing.T) {
+		decrypter := newTestDecrypterAt(t, ciphertext)
+		if _, err := decrypter.ReadAt(make([]byte, 1), -1); !errors.Is(err, ErrInvalidOffset) {
+			t.Errorf("expected error to be %s, got %s", ErrInvalidOffset, err)
+		}
+	})
+	t.Run("sequential read returns the plaintext", func(t *testing.T) {
+		decrypted, err := io.ReadAll(newTestDecrypterAt(t, ciphertext))
+		if err != nil {
+			t.Fatalf("failed to decrypt ciphertext: %s", err)
+		}
+		if !bytes.Equal(plaintext, decrypted) {
+			t.Error("plaintext and decrypted data do not match")
+		}
+	})
+	t.Run("seeking within the plaintext", func(t *testing.T) {
+		decrypter := newTestDecrypterAt(t, ciphertext)
+		size, err := decrypter.Seek(0, io.SeekEnd)
+		if err != nil {
+			t.Fatalf("failed to seek to end: %s", err)
+		}
+		if size != int64(len(plaintext)) {
+			t.Errorf("expected plaintext size to be %d, got %d", len(plaintext), size)
+		}
+		if _, err = decrypter.Seek(-142, io.SeekEnd); err != nil {
+			t.Fatalf("failed to seek from end: %s", err)
+		}
+		if _, err = decrypter.Seek(42, io.SeekCurrent); err != nil {
+			t.Fatalf("failed to seek from current position: %s", err)
+		}
+		rest, err := io.ReadAll(decrypter)
+		if err != nil {
+			t.Fatalf("failed to decrypt ciphertext: %s", err)
+		}
+		if !bytes.Equal(plaintext[len(plaintext)-100:], rest) {
+			t.Error("plaintext and decrypted data do not match")
+		}
+		if _, err = decrypter.Seek(-1, io.SeekStart); !errors.Is(err, ErrInvalidOffset) {
+			t.Errorf("expected error to be %s, got %s", ErrInvalidOffset, err)
+		}
+		if _, err = decrypter.Seek(0, 42); !errors.Is(err, ErrInvalidOffset) {
+			t.Errorf("expected error to be %s, got %s", ErrInvalidOffset, err)
+		}
+	})
+	t.Run("concurrent reads at different offsets", func(t *testing.T) {
+		decrypter := newTestDecrypterAt(t, ciphertext)
+		wg := sync.WaitGroup{}
+		for i := range 10 {
+			wg.Go(func() {
+				buffer := make([]byte, 50)
+				offset := int64(i * segmentSize)
+				if _, err := decrypter.ReadAt(buffer, offset); err != nil {
+					t.Errorf("failed to read at offset %d: %s", offset, err)
+					return
+				}
+				if !bytes.Equal(buffer, plaintext[offset:offset+50]) {
+					t.Errorf("plaintext and decrypted data at offset %d do not match", offset)
+				}
+			})
+		}
+		wg.Wait()
+	})
+	t.Run("only touched segments are authenticated", func(t *testing.T) {
+		tampered := bytes.Clone(ciphertext)
+		tampered[len(readTestHeader(t, ciphertext).raw)+3*(segmentSize+hmacSize)] ^= 0xff
+		decrypter := newTestDecrypterAt(t, tampered)
+		if _, err := decrypter.ReadAt(make([]byte, segmentSize), 0); err != nil {
+			t.Errorf("failed to read untampered segment: %s", err)
+		}
+		if _, err := decrypter.ReadAt(make([]byte, 1), 3*segmentSize); !errors.Is(err, ErrFailedAuthentication) {
+			t.Errorf("expected error to be %s, got %s", ErrFailedAuthentication, err)
+		}
+	})
+	t.Run("truncated ciphertext should fail", func(t *testing.T) {
+		truncated := ciphertext[:len(readTestHeader(t, ciphertext).raw)+2*(segmentSize+hmacSize)]
+		_, err := NewDecrypterAt(bytes.NewReader(truncated), int64(len(truncated)), testPassword)
+		if !errors.Is(err, ErrFailedAuthentication) {
+			t.Errorf("expected error to be %s, got %s", ErrFailedAuthentication, err)
+		}
+	})
+	t.Run("ciphertext shorter than a segment should fail", func(t *testing.T) {
+		truncated := ciphertext[:len(readTestHeader(t, ciphertext).raw)+10]
+		_, err := NewDecrypterAt(bytes.NewReader(truncated), int64(len(truncated)), testPassword)
+		if !errors.Is(err, ErrMissingData) {
+			t.Errorf("expected error to be %s, got %s", ErrMissingData, err)
+		}
+	})
+	t.Run("size larger than the ciphertext should fail", func(t *testing.T) {
+		_, err := NewDecrypterAt(bytes.NewReader(ciphertext), int64(len(ciphertext))+1, testPassword)
+		if err == nil {
+			t.Error("expected decryption to fail with wrong size")
+		}
+	})
+	t.Run("decryption with wrong password should fail", func(t *testing.T) {
+		_, err := NewDecrypterAt(bytes.NewReader(ciphertext), int64(len(ciphertext)), []byte("wrong password"))
+		if !errors.Is(err, ErrFailedAuthentication) {
+			t.Errorf("expected error to be %s, got %s", ErrFailedAuthentication, err)
+		}
+	})
+	t.Run("decryption with empty password should fail", func(t *testing.T) {
+		_, err := NewDecrypterAt(bytes.NewReader(ciphertext), int64(len(ciphertext)), nil)
+		if !errors.Is(err, ErrPassPhraseEmpty) {
+			t.Errorf("expected error to be %s, got %s", ErrPassPhraseEmpty, err)
+		}
+	})
+	t.Run("empty plaintext", func(t *testing.T) {
+		decrypter := newTestDecrypterAt(t, encryptWithChunkSize(t, nil, segmentSize))
+		if size, _ := decrypter.Seek(0, io.SeekEnd); size != 0 {
+			t.Errorf("expected plaintext size to be 0, got %d", size)
+		}
+		if _, err := decrypter.ReadAt(make([]byte, 1), 0); !errors.Is(err, io.EOF) {
+			t.Errorf("expected error to be %s, got %s", io.EOF, err)
+		}
+	})
+	t.Run("plaintext with a multiple of the segment size", func(t *testing.T) {
+		decrypted, err := io.ReadAll(newTestDecrypterAt(t, encryptWithChunkSize(t, plaintext[:3*segmentSize],
+			segmentSize)))
+		if err != nil {
+			t.Fatalf("failed to decrypt ciphertext: %s", err)
+		}
+		if !bytes.Equal(plaintext[:3*segmentSize], decrypted) {
+			t.Error("plaintext and decrypted data do not match")
+		}
+	})
+	t.Run("legacy format should fail", func(t *testing.T) {
+		legacy, err := newLegacyEncrypter(bytes.NewReader(plaintext), testPassword)
+		if err != nil {
+			t.Fatalf("failed to create legacy encrypter: %s", err)
+		}
+		legacyCiphertext, err := io.ReadAll(legacy)
+		if err != nil {
+			t.Fatalf("failed to encrypt plaintext: %s", err)
+		}
+		_, err = NewDecrypterAt(bytes.NewReader(legacyCiphertext), int64(len(legacyCiphertext)), testPassword)
+		if !errors.Is(err, ErrUnsupportedVersion) {
+			t.Errorf("expected error to be %s, got %s", ErrUnsupportedVersion, err)
+		}
+	})
+}
+
+// encryptWithChunkSize encrypts the given plaintext with the test password, using the given segment
+// size, and returns the ciphertext.
+func encryptWithChunkSize(t *testing.T, plaintext []byte, segmentSize uint32) []byte {
+	t.Helper()
+	encrypter, err := NewEncrypter(bytes.NewReader(plaintext), testPassword, WithChunkSize(segmentSize))
+	if err != nil {
+		t.Fatalf("failed to create encrypter: %s", err)
+	}
+	ciphertext, err := io.ReadAll(encrypter)
+	if err != nil {
+		t.Fatalf("failed to encrypt plaintext: %s", err)
+	}
+	return ciphertext
+}
+
+// newTestDecrypterAt returns a ReadSeekerAt for the given ciphertext, decrypted with the test
+// password.
+func newTestDecrypterAt(t *testing.T, ciphertext []byte) ReadSeekerAt {
+	t.Helper()
+	decrypter, err := NewDecrypterAt(bytes.NewReader(ciphertext), int64(len(ciphertext)), testPassword)
+	if err != nil {
+		t.Fatalf("failed to create decrypter: %s", err)
+	}
+	return decrypter
+}
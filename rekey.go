@@ -0,0 +1,108 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrHeaderLengthChanged indicates that the rekeyed header differs in length from the original
+// header, so that it cannot be rewritten in place.
+var ErrHeaderLengthChanged = errors.New("rekeyed header differs in length from the original header")
+
+// Rekey changes the password of ciphertext that was created with a PasswordKeySlot. It reads the
+// ciphertext from the current offset of r and writes it to w, with the password key slot that is
+// unwrapped by oldPass replaced with a key slot for newPass. Since the payload is encrypted with the
+// file key, which stays the same, only the header is rewritten and the payload is copied unchanged.
+// Use RekeyInPlace to avoid copying the payload.
+//
+// Only ciphertext created by NewEncrypterWithKeySlots with at least one PasswordKeySlot has a
+// password key slot. Ciphertext created by NewEncrypter, NewEncrypterWithSettings, NewEncryptWriter
+// or NewEncrypterWithKey derives its keys directly from the secret, and ciphertext created by
+// NewEncrypterForRecipients only has X25519 key slots, so ErrKeyTypeMismatch is returned for both.
+//
+// The key derivation of the new key slot is configured with WithArgon2, WithArgon2i, WithScrypt or
+// WithPBKDF2, the header is validated against the DecryptPolicy configured with WithPolicy. Other
+// key slots are kept, so the old password can still be used with copies of the ciphertext that have
+// not been rekeyed. The payload is not authenticated by Rekey.
+func Rekey(r io.ReadSeeker, w io.Writer, oldPass, newPass []byte, opts ...Option) error {
+	offset, hdr, headerLength, err := rekeyHeader(r, oldPass, newPass, opts)
+	if err != nil {
+		return err
+	}
+	if _, err = r.Seek(offset+int64(headerLength), io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek to start of payload: %w", err)
+	}
+	if _, err = w.Write(hdr.raw); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+	if _, err = io.Copy(w, r); err != nil {
+		return fmt.Errorf("failed to copy payload: %w", err)
+	}
+	return nil
+}
+
+// RekeyInPlace works like Rekey, but overwrites the header of the ciphertext at the current offset
+// of rw, e.g. an *os.File, instead of copying the ciphertext, so that the payload is neither read nor
+// written. The rekeyed header needs to have the same length as the original one, which is the case
+// unless the new key slot uses a key derivation with a different parameter or salt length. Otherwise
+// ErrHeaderLengthChanged is returned and the ciphertext is left unchanged.
+//
+// Since the header is overwritten, the ciphertext can no longer be decrypted if the write is
+// interrupted. Use Rekey with a separate output if that is not acceptable.
+func RekeyInPlace(rw io.ReadWriteSeeker, oldPass, newPass []byte, opts ...Option) error {
+	offset, hdr, headerLength, err := rekeyHeader(rw, oldPass, newPass, opts)
+	if err != nil {
+		return err
+	}
+	if len(hdr.raw) != headerLength {
+		return fmt.Errorf("%w: %d instead of %d bytes", ErrHeaderLengthChanged, len(hdr.raw), headerLength)
+	}
+	if _, err = rw.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek to start of header: %w", err)
+	}
+	if _, err = rw.Write(hdr.raw); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+	return nil
+}
+
+// rekeyHeader reads the header of the ciphertext at the current offset of r and replaces the password
+// key slot that is unwrapped by oldPass with a key slot for newPass. It returns the offset of the
+// ciphertext, the resealed header and the length of the original header.
+func rekeyHeader(r io.ReadSeeker, oldPass, newPass []byte, opts []Option) (int64, *header, int, error) {
+	if len(oldPass) == 0 || len(newPass) == 0 {
+		return 0, nil, 0, ErrPassPhraseEmpty
+	}
+	o, err := newOptions(opts...)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	offset, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, nil, 0, fmt.Errorf("failed to determine offset of ciphertext: %w", err)
+	}
+
+	hdr, err := readHeader(bufio.NewReaderSize(r, chunkSize), o.policy)
+	if err != nil {
+		return 0, nil, 0, fmt.Errorf("failed to read encryption parameters: %w", err)
+	}
+	headerLength := len(hdr.raw)
+	index, fileKey, err := hdr.unwrapFileKey(keySlotPassword, passwordUnwrapper(oldPass))
+	if err != nil {
+		return 0, nil, 0, fmt.Errorf("failed to unwrap file key: %w", err)
+	}
+	hdr.slots[index], err = PasswordKeySlot(newPass).wrap(fileKey, o)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	if err = hdr.sealHeader(fileKey); err != nil {
+		return 0, nil, 0, err
+	}
+	return offset, hdr, headerLength, nil
+}
@@ -0,0 +1,200 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRekey(t *testing.T) {
+	plaintext := make([]byte, defaultSegmentSize+1)
+	newPassword := []byte("a new secure password")
+	identity := generateIdentity(t)
+	slots := []KeySlot{PasswordKeySlot(testPassword), X25519KeySlot(identity.PublicKey())}
+	encrypter, err := NewEncrypterWithKeySlots(bytes.NewReader(plaintext), slots, WithArgon2(8*1024, 1, 1))
+	if err != nil {
+		t.Fatalf("failed to create encrypter: %s", err)
+	}
+	ciphertext, err := io.ReadAll(encrypter)
+	if err != nil {
+		t.Fatalf("failed to encrypt plaintext: %s", err)
+	}
+
+	t.Run("rekeyed ciphertext is decrypted with the new password", func(t *testing.T) {
+		rekeyed := bytes.NewBuffer(nil)
+		if err := Rekey(bytes.NewReader(ciphertext), rekeyed, testPassword, newPassword); err != nil {
+			t.Fatalf("failed to rekey ciphertext: %s", err)
+		}
+		decrypter, err := NewDecrypter(bytes.NewReader(rekeyed.Bytes()), newPassword)
+		if err != nil {
+			t.Fatalf("failed to create decrypter: %s", err)
+		}
+		decrypted, err := io.ReadAll(decrypter)
+		if err != nil {
+			t.Fatalf("failed to decrypt ciphertext: %s", err)
+		}
+		if !bytes.Equal(plaintext, decrypted) {
+			t.Error("plaintext and decrypted data do not match")
+		}
+		_, err = NewDecrypter(bytes.NewReader(rekeyed.Bytes()), testPassword)
+		if !errors.Is(err, ErrNoMatchingKeySlot) {
+			t.Errorf("expected error to be %s, got %s", ErrNoMatchingKeySlot, err)
+		}
+	})
+	t.Run("rekeying keeps the payload and the other key slots", func(t *testing.T) {
+		rekeyed := bytes.NewBuffer(nil)
+		if err := Rekey(bytes.NewReader(ciphertext), rekeyed, testPassword, newPassword); err != nil {
+			t.Fatalf("failed to rekey ciphertext: %s", err)
+		}
+		oldHeader := readTestHeader(t, ciphertext)
+		newHeader := readTestHeader(t, rekeyed.Bytes())
+		if !bytes.Equal(ciphertext[len(oldHeader.raw):], rekeyed.Bytes()[len(newHeader.raw):]) {
+			t.Error("expected payload to be unchanged")
+		}
+		if !bytes.Equal(oldHeader.ad, newHeader.ad) {
+			t.Error("expected authenticated header data to be unchanged")
+		}
+		if _, err := NewDecrypterWithIdentity(bytes.NewReader(rekeyed.Bytes()), identity); err != nil {
+			t.Errorf("failed to decrypt rekeyed ciphertext with identity: %s", err)
+		}
+	})
+	t.Run("rekeying starts at the current offset of the reader", func(t *testing.T) {
+		prefix := []byte("prefix")
+		reader := bytes.NewReader(append(bytes.Clone(prefix), ciphertext...))
+		if _, err := reader.Seek(int64(len(prefix)), io.SeekStart); err != nil {
+			t.Fatalf("failed to seek: %s", err)
+		}
+		rekeyed := bytes.NewBuffer(nil)
+		if err := Rekey(reader, rekeyed, testPassword, newPassword); err != nil {
+			t.Fatalf("failed to rekey ciphertext: %s", err)
+		}
+		if _, err := NewDecrypter(bytes.NewReader(rekeyed.Bytes()), newPassword); err != nil {
+			t.Errorf("failed to decrypt rekeyed ciphertext: %s", err)
+		}
+	})
+	t.Run("rekeying with wrong password should fail", func(t *testing.T) {
+		err := Rekey(bytes.NewReader(ciphertext), io.Discard, []byte("wrong password"), newPassword)
+		if !errors.Is(err, ErrNoMatchingKeySlot) {
+			t.Errorf("expected error to be %s, got %s", ErrNoMatchingKeySlot, err)
+		}
+	})
+	t.Run("rekeying with tampered header MAC should fail", func(t *testing.T) {
+		tampered := bytes.Clone(ciphertext)
+		tampered[len(readTestHeader(t, ciphertext).raw)-1] ^= 0xff
+		err := Rekey(bytes.NewReader(tampered), io.Discard, testPassword, newPassword)
+		if !errors.Is(err, ErrFailedAuthentication) {
+			t.Errorf("expected error to be %s, got %s", ErrFailedAuthentication, err)
+		}
+	})
+	t.Run("rekeying ciphertext without password key slot should fail", func(t *testing.T) {
+		recipientCiphertext := encryptForRecipients(t, plaintext, []*ecdh.PublicKey{identity.PublicKey()})
+		err := Rekey(bytes.NewReader(recipientCiphertext), io.Discard, testPassword, newPassword)
+		if !errors.Is(err, ErrKeyTypeMismatch) {
+			t.Errorf("expected error to be %s, got %s", ErrKeyTypeMismatch, err)
+		}
+	})
+	t.Run("rekeying ciphertext without key slots should fail", func(t *testing.T) {
+		err := Rekey(bytes.NewReader(encryptBytes(t, plaintext)), io.Discard, testPassword, newPassword)
+		if !errors.Is(err, ErrKeyTypeMismatch) {
+			t.Errorf("expected error to be %s, got %s", ErrKeyTypeMismatch, err)
+		}
+	})
+	t.Run("rekeying with empty password should fail", func(t *testing.T) {
+		err := Rekey(bytes.NewReader(ciphertext), io.Discard, testPassword, nil)
+		if !errors.Is(err, ErrPassPhraseEmpty) {
+			t.Errorf("expected error to be %s, got %s", ErrPassPhraseEmpty, err)
+		}
+	})
+	t.Run("rekeying into broken writer should fail", func(t *testing.T) {
+		err := Rekey(bytes.NewReader(ciphertext), &failReadWriter{}, testPassword, newPassword)
+		if err == nil {
+			t.Error("expected rekeying to fail with broken writer")
+		}
+	})
+}
+
+func TestRekeyInPlace(t *testing.T) {
+	plaintext := make([]byte, defaultSegmentSize+1)
+	newPassword := []byte("a new secure password")
+	slots := []KeySlot{PasswordKeySlot(testPassword)}
+	encrypter, err := NewEncrypterWithKeySlots(bytes.NewReader(plaintext), slots, WithArgon2(8*1024, 1, 1))
+	if err != nil {
+		t.Fatalf("failed to create encrypter: %s", err)
+	}
+	ciphertext, err := io.ReadAll(encrypter)
+	if err != nil {
+		t.Fatalf("failed to encrypt plaintext: %s", err)
+	}
+	// writeCiphertext writes the ciphertext to a file that is positioned at its start
+	writeCiphertext := func(t *testing.T) *os.File {
+		t.Helper()
+		file, err := os.Create(filepath.Join(t.TempDir(), "ciphertext"))
+		if err != nil {
+			t.Fatalf("failed to create file: %s", err)
+		}
+		t.Cleanup(func() {
+			_ = file.Close()
+		})
+		if _, err = file.Write(ciphertext); err != nil {
+			t.Fatalf("failed to write ciphertext: %s", err)
+		}
+		if _, err = file.Seek(0, io.SeekStart); err != nil {
+			t.Fatalf("failed to seek: %s", err)
+		}
+		return file
+	}
+
+	t.Run("header is rewritten in place", func(t *testing.T) {
+		file := writeCiphertext(t)
+		if err := RekeyInPlace(file, testPassword, newPassword, WithArgon2(8*1024, 1, 1)); err != nil {
+			t.Fatalf("failed to rekey ciphertext: %s", err)
+		}
+		rekeyed, err := os.ReadFile(file.Name())
+		if err != nil {
+			t.Fatalf("failed to read rekeyed ciphertext: %s", err)
+		}
+		headerLength := len(readTestHeader(t, ciphertext).raw)
+		if len(rekeyed) != len(ciphertext) || !bytes.Equal(ciphertext[headerLength:], rekeyed[headerLength:]) {
+			t.Error("expected payload to be unchanged")
+		}
+		decrypter, err := NewDecrypter(bytes.NewReader(rekeyed), newPassword)
+		if err != nil {
+			t.Fatalf("failed to create decrypter: %s", err)
+		}
+		decrypted, err := io.ReadAll(decrypter)
+		if err != nil {
+			t.Fatalf("failed to decrypt ciphertext: %s", err)
+		}
+		if !bytes.Equal(plaintext, decrypted) {
+			t.Error("plaintext and decrypted data do not match")
+		}
+	})
+	t.Run("header with different length is not rewritten", func(t *testing.T) {
+		file := writeCiphertext(t)
+		err := RekeyInPlace(file, testPassword, newPassword, WithPBKDF2(1000))
+		if !errors.Is(err, ErrHeaderLengthChanged) {
+			t.Errorf("expected error to be %s, got %s", ErrHeaderLengthChanged, err)
+		}
+		unchanged, err := os.ReadFile(file.Name())
+		if err != nil {
+			t.Fatalf("failed to read ciphertext: %s", err)
+		}
+		if !bytes.Equal(ciphertext, unchanged) {
+			t.Error("expected ciphertext to be unchanged")
+		}
+	})
+	t.Run("rekeying in place with wrong password should fail", func(t *testing.T) {
+		err := RekeyInPlace(writeCiphertext(t), []byte("wrong password"), newPassword)
+		if !errors.Is(err, ErrNoMatchingKeySlot) {
+			t.Errorf("expected error to be %s, got %s", ErrNoMatchingKeySlot, err)
+		}
+	})
+}
@@ -0,0 +1,192 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// ErrSpoolClosed indicates that a Spool has been used after it has been closed.
+var ErrSpoolClosed = errors.New("spool is closed")
+
+// Spool is the temporary storage that the decrypter writes ciphertext in the legacy format to, until
+// it has been authenticated. The decrypter writes the ciphertext sequentially, seeks back to its start
+// and reads it back while decrypting. Close is called once the decrypter is closed or the decryption
+// fails, and must release all resources held by the Spool.
+type Spool interface {
+	io.ReadWriteSeeker
+	io.Closer
+}
+
+// newSpool returns a new Spool for the decrypter. It defaults to a temporary file, unless another
+// Spool has been configured with an Option.
+func (o *options) newSpool() (Spool, error) {
+	if o.spool != nil {
+		return o.spool(o)
+	}
+	return o.newFileSpool()
+}
+
+// newFileSpool returns a temporary file as Spool, which is encrypted if configured with the
+// WithEncryptedSpool Option.
+func (o *options) newFileSpool() (Spool, error) {
+	file, err := createTempFile(o.tempDir, o.unnamedTemp)
+	if err != nil {
+		return nil, err
+	}
+	return o.protectSpool(file)
+}
+
+// protectSpool wraps the given Spool in an encryptedSpool, if configured with the WithEncryptedSpool
+// Option. The given Spool is closed if that fails.
+func (o *options) protectSpool(spool Spool) (Spool, error) {
+	if !o.encrypted {
+		return spool, nil
+	}
+	encrypted, err := newEncryptedSpool(spool, o.random())
+	if err != nil {
+		_ = spool.Close()
+		return nil, err
+	}
+	return encrypted, nil
+}
+
+// memorySpool is a Spool that keeps its data in memory.
+type memorySpool struct {
+	data   []byte
+	offset int64
+	closed bool
+}
+
+// Read satisfies the io.Reader interface for the memorySpool type.
+func (m *memorySpool) Read(p []byte) (int, error) {
+	if m.closed {
+		return 0, ErrSpoolClosed
+	}
+	if m.offset >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[m.offset:])
+	m.offset += int64(n)
+	return n, nil
+}
+
+// Write satisfies the io.Writer interface for the memorySpool type.
+func (m *memorySpool) Write(p []byte) (int, error) {
+	if m.closed {
+		return 0, ErrSpoolClosed
+	}
+	end := int(m.offset) + len(p)
+	if end > len(m.data) {
+		m.data = slices.Grow(m.data, end-len(m.data))[:end]
+	}
+	copy(m.data[m.offset:], p)
+	m.offset = int64(end)
+	return len(p), nil
+}
+
+// Seek satisfies the io.Seeker interface for the memorySpool type.
+func (m *memorySpool) Seek(offset int64, whence int) (int64, error) {
+	if m.closed {
+		return 0, ErrSpoolClosed
+	}
+	var position int64
+	switch whence {
+	case io.SeekStart:
+		position = offset
+	case io.SeekCurrent:
+		position = m.offset + offset
+	case io.SeekEnd:
+		position = int64(len(m.data)) + offset
+	default:
+		return 0, fmt.Errorf("%w: invalid whence %d", ErrInvalidOffset, whence)
+	}
+	if position < 0 {
+		return 0, fmt.Errorf("%w: %d", ErrInvalidOffset, position)
+	}
+	m.offset = position
+	return position, nil
+}
+
+// Close satisfies the io.Closer interface for the memorySpool type. It releases the data held by the
+// memorySpool.
+func (m *memorySpool) Close() error {
+	m.data = nil
+	m.closed = true
+	return nil
+}
+
+// hybridSpool is a Spool that keeps its data in memory up to a threshold and spills it into a Spool
+// created by spill, once the threshold is exceeded.
+type hybridSpool struct {
+	memory    *memorySpool
+	spilled   Spool
+	threshold int64
+	spill     func() (Spool, error)
+}
+
+// current returns the Spool that currently holds the data.
+func (h *hybridSpool) current() Spool {
+	if h.spilled != nil {
+		return h.spilled
+	}
+	return h.memory
+}
+
+// Read satisfies the io.Reader interface for the hybridSpool type.
+func (h *hybridSpool) Read(p []byte) (int, error) {
+	return h.current().Read(p)
+}
+
+// Write satisfies the io.Writer interface for the hybridSpool type. If the write would exceed the
+// threshold, the data held in memory is moved to the spill Spool first.
+func (h *hybridSpool) Write(p []byte) (int, error) {
+	if h.spilled == nil && !h.memory.closed && h.memory.offset+int64(len(p)) > h.threshold {
+		if err := h.spillMemory(); err != nil {
+			return 0, err
+		}
+	}
+	return h.current().Write(p)
+}
+
+// Seek satisfies the io.Seeker interface for the hybridSpool type.
+func (h *hybridSpool) Seek(offset int64, whence int) (int64, error) {
+	return h.current().Seek(offset, whence)
+}
+
+// Close satisfies the io.Closer interface for the hybridSpool type.
+func (h *hybridSpool) Close() error {
+	if err := h.memory.Close(); err != nil {
+		return err
+	}
+	if h.spilled != nil {
+		return h.spilled.Close()
+	}
+	return nil
+}
+
+// spillMemory creates the spill Spool, copies the data held in memory into it and releases the
+// memory.
+func (h *hybridSpool) spillMemory() error {
+	spilled, err := h.spill()
+	if err != nil {
+		return err
+	}
+	if _, err = spilled.Write(h.memory.data); err != nil {
+		_ = spilled.Close()
+		return fmt.Errorf("failed to spill data from memory: %w", err)
+	}
+	if h.memory.offset != int64(len(h.memory.data)) {
+		if _, err = spilled.Seek(h.memory.offset, io.SeekStart); err != nil {
+			_ = spilled.Close()
+			return fmt.Errorf("failed to spill data from memory: %w", err)
+		}
+	}
+	h.spilled = spilled
+	return h.memory.Close()
+}
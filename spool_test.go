@@ -0,0 +1,257 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestSpool(t *testing.T) {
+	data := make([]byte, 3*chunkSize+42)
+	if _, err := io.ReadFull(rand.Reader, data); err != nil {
+		t.Fatalf("failed to generate data: %s", err)
+	}
+	spools := []struct {
+		name     string
+		newSpool func(t *testing.T) Spool
+	}{
+		{"memory spool", func(*testing.T) Spool { return &memorySpool{} }},
+		{"hybrid spool below threshold", func(t *testing.T) Spool {
+			return newTestHybridSpool(t, int64(len(data)))
+		}},
+		{"hybrid spool above threshold", func(t *testing.T) Spool {
+			return newTestHybridSpool(t, int64(chunkSize))
+		}},
+		{"file spool", func(t *testing.T) Spool {
+			file, err := createTempFile(t.TempDir(), false)
+			if err != nil {
+				t.Fatalf("failed to create temporary file: %s", err)
+			}
+			return file
+		}},
+	}
+	for _, tt := range spools {
+		t.Run(tt.name+" returns the written data", func(t *testing.T) {
+			spool := tt.newSpool(t)
+			defer func() {
+				_ = spool.Close()
+			}()
+			for chunk := range slices.Chunk(data, chunkSize-hmacSize) {
+				if _, err := spool.Write(chunk); err != nil {
+					t.Fatalf("failed to write to spool: %s", err)
+				}
+			}
+			if _, err := spool.Seek(0, io.SeekStart); err != nil {
+				t.Fatalf("failed to seek to start of spool: %s", err)
+			}
+			spooled, err := io.ReadAll(spool)
+			if err != nil {
+				t.Fatalf("failed to read from spool: %s", err)
+			}
+			if !bytes.Equal(data, spooled) {
+				t.Error("written and spooled data do not match")
+			}
+			if _, err = spool.Seek(-42, io.SeekEnd); err != nil {
+				t.Fatalf("failed to seek from end of spool: %s", err)
+			}
+			spooled, err = io.ReadAll(spool)
+			if err != nil {
+				t.Fatalf("failed to read from spool: %s", err)
+			}
+			if !bytes.Equal(data[len(data)-42:], spooled) {
+				t.Error("written and spooled data at the end do not match")
+			}
+		})
+		t.Run(tt.name+" can be closed repeatedly", func(t *testing.T) {
+			spool := tt.newSpool(t)
+			if _, err := spool.Write(data); err != nil {
+				t.Fatalf("failed to write to spool: %s", err)
+			}
+			if err := spool.Close(); err != nil {
+				t.Fatalf("failed to close spool: %s", err)
+			}
+			if err := spool.Close(); err != nil {
+				t.Errorf("expected subsequent close to succeed, got %s", err)
+			}
+			if _, err := spool.Read(make([]byte, 1)); err == nil {
+				t.Error("expected read after close to fail")
+			}
+		})
+	}
+	t.Run("memory spool overwrites data at the offset", func(t *testing.T) {
+		spool := &memorySpool{}
+		_, _ = spool.Write([]byte("ciphertext"))
+		if _, err := spool.Seek(2, io.SeekStart); err != nil {
+			t.Fatalf("failed to seek: %s", err)
+		}
+		_, _ = spool.Write([]byte("PH"))
+		if !bytes.Equal(spool.data, []byte("ciPHertext")) {
+			t.Errorf("expected spooled data to be %q, got %q", "ciPHertext", spool.data)
+		}
+	})
+	t.Run("memory spool read after close should fail", func(t *testing.T) {
+		spool := &memorySpool{}
+		_ = spool.Close()
+		if _, err := spool.Read(make([]byte, 1)); !errors.Is(err, ErrSpoolClosed) {
+			t.Errorf("expected error to be %s, got %s", ErrSpoolClosed, err)
+		}
+		if _, err := spool.Write(data); !errors.Is(err, ErrSpoolClosed) {
+			t.Errorf("expected error to be %s, got %s", ErrSpoolClosed, err)
+		}
+		if _, err := spool.Seek(0, io.SeekStart); !errors.Is(err, ErrSpoolClosed) {
+			t.Errorf("expected error to be %s, got %s", ErrSpoolClosed, err)
+		}
+	})
+	t.Run("memory spool seeking to invalid offset should fail", func(t *testing.T) {
+		spool := &memorySpool{}
+		if _, err := spool.Seek(-1, io.SeekStart); !errors.Is(err, ErrInvalidOffset) {
+			t.Errorf("expected error to be %s, got %s", ErrInvalidOffset, err)
+		}
+		if _, err := spool.Seek(0, 42); !errors.Is(err, ErrInvalidOffset) {
+			t.Errorf("expected error to be %s, got %s", ErrInvalidOffset, err)
+		}
+	})
+	t.Run("hybrid spool spills only above the threshold", func(t *testing.T) {
+		spool := newTestHybridSpool(t, int64(chunkSize))
+		defer func() {
+			_ = spool.Close()
+		}()
+		if _, err := spool.Write(data[:chunkSize]); err != nil {
+			t.Fatalf("failed to write to spool: %s", err)
+		}
+		if spool.spilled != nil {
+			t.Fatal("expected spool to stay in memory up to the threshold")
+		}
+		if _, err := spool.Write(data[chunkSize : chunkSize+1]); err != nil {
+			t.Fatalf("failed to write to spool: %s", err)
+		}
+		if spool.spilled == nil {
+			t.Fatal("expected spool to spill above the threshold")
+		}
+		if spool.memory.data != nil {
+			t.Error("expected memory to be released after spilling")
+		}
+	})
+	t.Run("hybrid spool with failing spill should fail", func(t *testing.T) {
+		spool := &hybridSpool{
+			memory: &memorySpool{},
+			spill: func() (Spool, error) {
+				return nil, errors.New("spill failed")
+			},
+		}
+		if _, err := spool.Write(data); err == nil {
+			t.Error("expected write to fail with failing spill")
+		}
+	})
+}
+
+func TestNewDecrypter_Spool(t *testing.T) {
+	plaintext := make([]byte, defaultSegmentSize+1)
+	legacy, err := newLegacyEncrypter(bytes.NewReader(plaintext), testPassword)
+	if err != nil {
+		t.Fatalf("failed to create legacy encrypter: %s", err)
+	}
+	ciphertext, err := io.ReadAll(legacy)
+	if err != nil {
+		t.Fatalf("failed to encrypt plaintext: %s", err)
+	}
+
+	// Derive the keys only once, so that the decrypters can be created repeatedly
+	aesKey, hmacKey, err := passwordKeys(testPassword)(readTestHeader(t, ciphertext))
+	if err != nil {
+		t.Fatalf("failed to derive keys: %s", err)
+	}
+	keys := keyFunc(func(*header) ([]byte, []byte, error) {
+		return aesKey, hmacKey, nil
+	})
+	decrypt := func(t *testing.T, opts ...Option) (io.ReadCloser, error) {
+		t.Helper()
+		o, err := newOptions(opts...)
+		if err != nil {
+			t.Fatalf("failed to create options: %s", err)
+		}
+		return newDecrypter(bytes.NewReader(ciphertext), keys, o)
+	}
+
+	tests := []struct {
+		name      string
+		option    Option
+		wantFiles bool
+	}{
+		{"memory spool", WithMemorySpool(), false},
+		{"hybrid spool below threshold", WithHybridSpool(int64(len(ciphertext))), false},
+		{"hybrid spool above threshold", WithHybridSpool(int64(chunkSize)), true},
+		{"custom spool", WithSpool(func() (Spool, error) { return &memorySpool{}, nil }), false},
+	}
+	for _, tt := range tests {
+		t.Run("decryption with "+tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			decrypter, err := decrypt(t, WithTempDir(dir), tt.option)
+			if err != nil {
+				t.Fatalf("failed to create decrypter: %s", err)
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("failed to read temporary directory: %s", err)
+			}
+			if tt.wantFiles != (len(entries) > 0) {
+				t.Errorf("expected temporary files to be created: %t, got %d entries", tt.wantFiles, len(entries))
+			}
+			decrypted, err := io.ReadAll(decrypter)
+			if err != nil {
+				t.Fatalf("failed to decrypt ciphertext: %s", err)
+			}
+			if !bytes.Equal(plaintext, decrypted) {
+				t.Error("plaintext and decrypted data do not match")
+			}
+			if err = decrypter.Close(); err != nil {
+				t.Fatalf("failed to close decrypter: %s", err)
+			}
+			assertEmptyDir(t, dir)
+		})
+	}
+	t.Run("decryption with failing custom spool should fail", func(t *testing.T) {
+		spoolErr := errors.New("spool not available")
+		_, err := decrypt(t, WithSpool(func() (Spool, error) { return nil, spoolErr }))
+		if !errors.Is(err, spoolErr) {
+			t.Errorf("expected error to be %s, got %s", spoolErr, err)
+		}
+	})
+	t.Run("custom spool is closed on failed authentication", func(t *testing.T) {
+		tampered := bytes.Clone(ciphertext)
+		tampered[len(tampered)-1] ^= 0xff
+		spool := &memorySpool{}
+		o, err := newOptions(WithSpool(func() (Spool, error) { return spool, nil }))
+		if err != nil {
+			t.Fatalf("failed to create options: %s", err)
+		}
+		if _, err = newDecrypter(bytes.NewReader(tampered), keys, o); !errors.Is(err, ErrFailedAuthentication) {
+			t.Errorf("expected error to be %s, got %s", ErrFailedAuthentication, err)
+		}
+		if !spool.closed {
+			t.Error("expected spool to be closed")
+		}
+	})
+}
+
+// newTestHybridSpool returns a hybridSpool with the given threshold, that spills into a temporary
+// file in a test directory.
+func newTestHybridSpool(t *testing.T, threshold int64) *hybridSpool {
+	t.Helper()
+	dir := t.TempDir()
+	return &hybridSpool{
+		memory:    &memorySpool{},
+		threshold: threshold,
+		spill: func() (Spool, error) {
+			return createTempFile(dir, false)
+		},
+	}
+}
@@ -0,0 +1,400 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// segmentCipher is the interface for the authenticated encryption of a single segment of the
+// segmented stream format. Each segment is bound to the stream header, its position in the
+// stream and a flag that indicates whether it is the final segment of the stream, so that
+// segments can neither be reordered, nor can the stream be truncated unnoticed.
+type segmentCipher interface {
+	// overhead returns the number of bytes a sealed segment is longer than its plaintext.
+	overhead() int
+
+	// seal encrypts and authenticates the plaintext and appends the result to dst.
+	seal(dst, plaintext []byte, counter uint64, final bool) []byte
+
+	// open authenticates and decrypts the ciphertext and appends the result to dst. The
+	// plaintext is only returned if the authentication succeeded.
+	open(dst, ciphertext []byte, counter uint64, final bool) ([]byte, error)
+}
+
+// ctrHMACCipher implements the segmentCipher interface using AES-256-CTR for encryption and
+// HMAC-SHA512 for authentication. The keystream of all segments is derived from a single IV,
+// with each segment starting at the counter block that corresponds to its offset in the
+// plaintext.
+type ctrHMACCipher struct {
+	block       cipher.Block
+	hmacKey     []byte
+	iv          []byte
+	header      []byte
+	segmentSize int
+}
+
+// newCTRHMACCipher returns a new ctrHMACCipher for the given keys, IV, stream header and
+// segment size.
+func newCTRHMACCipher(aesKey, hmacKey, iv, header []byte, segmentSize int) (*ctrHMACCipher, error) {
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES block cipher: %w", err)
+	}
+	return &ctrHMACCipher{
+		block:       block,
+		hmacKey:     hmacKey,
+		iv:          iv,
+		header:      header,
+		segmentSize: segmentSize,
+	}, nil
+}
+
+// overhead satisfies the segmentCipher interface for the ctrHMACCipher type.
+func (c *ctrHMACCipher) overhead() int {
+	return hmacSize
+}
+
+// seal satisfies the segmentCipher interface for the ctrHMACCipher type.
+func (c *ctrHMACCipher) seal(dst, plaintext []byte, counter uint64, final bool) []byte {
+	ret, out := sliceForAppend(dst, len(plaintext)+hmacSize)
+	cipher.NewCTR(c.block, c.segmentIV(counter)).XORKeyStream(out, plaintext)
+	copy(out[len(plaintext):], c.tag(out[:len(plaintext)], counter, final))
+	return ret
+}
+
+// open satisfies the segmentCipher interface for the ctrHMACCipher type.
+func (c *ctrHMACCipher) open(dst, ciphertext []byte, counter uint64, final bool) ([]byte, error) {
+	if len(ciphertext) < hmacSize {
+		return nil, ErrMissingData
+	}
+	data := ciphertext[:len(ciphertext)-hmacSize]
+	if !hmac.Equal(ciphertext[len(data):], c.tag(data, counter, final)) {
+		return nil, ErrFailedAuthentication
+	}
+	ret, out := sliceForAppend(dst, len(data))
+	cipher.NewCTR(c.block, c.segmentIV(counter)).XORKeyStream(out, data)
+	return ret, nil
+}
+
+// tag calculates the HMAC over the stream header, the segment counter, the final flag and
+// the ciphertext of the segment.
+func (c *ctrHMACCipher) tag(ciphertext []byte, counter uint64, final bool) []byte {
+	hasher := hmac.New(hashFunc, c.hmacKey)
+	hasher.Write(c.header)
+	hasher.Write(segmentNonce(counter, final))
+	hasher.Write(ciphertext)
+	return hasher.Sum(nil)
+}
+
+// segmentIV returns the initial CTR counter block for the segment with the given counter.
+func (c *ctrHMACCipher) segmentIV(counter uint64) []byte {
+	blocksPerSegment := uint64((c.segmentSize + blockSize - 1) / blockSize)
+	return addToCounter(c.iv, counter*blocksPerSegment)
+}
+
+// streamEncrypter is an io.Reader that reads plaintext from an underlying io.Reader and
+// returns the stream header followed by the sealed segments of the plaintext. Segments are
+// read in batches of up to one segment per worker, which are sealed in parallel.
+type streamEncrypter struct {
+	reader  *bufio.Reader
+	cipher  segmentCipher
+	workers int
+	plain   [][]byte
+	sealed  [][]byte
+	pending [][]byte
+	out     []byte
+	counter uint64
+	done    bool
+	err     error
+}
+
+// newStreamEncrypter returns a new streamEncrypter that will read plaintext from r and seal
+// it in segments of segmentSize bytes using the given segmentCipher, with up to the given
+// number of workers sealing segments in parallel.
+func newStreamEncrypter(r io.Reader, segCipher segmentCipher, header []byte,
+	segmentSize, workers int,
+) *streamEncrypter {
+	workers = max(workers, 1)
+	encrypter := &streamEncrypter{
+		reader:  bufio.NewReader(r),
+		cipher:  segCipher,
+		workers: workers,
+		plain:   make([][]byte, workers),
+		sealed:  make([][]byte, workers),
+		out:     header,
+	}
+	for i := range workers {
+		encrypter.plain[i] = make([]byte, segmentSize)
+		encrypter.sealed[i] = make([]byte, 0, segmentSize+segCipher.overhead())
+	}
+	return encrypter
+}
+
+// Read satisfies the io.Reader interface for the streamEncrypter type.
+func (e *streamEncrypter) Read(p []byte) (int, error) {
+	for len(e.out) == 0 {
+		if len(e.pending) > 0 {
+			e.out, e.pending = e.pending[0], e.pending[1:]
+			continue
+		}
+		if e.err != nil {
+			return 0, e.err
+		}
+		if e.done {
+			return 0, io.EOF
+		}
+		e.err = e.sealSegments()
+	}
+	n := copy(p, e.out)
+	e.out = e.out[n:]
+	return n, nil
+}
+
+// sealSegments reads the next batch of segments from the underlying reader and seals them in
+// parallel.
+func (e *streamEncrypter) sealSegments() error {
+	lengths := make([]int, 0, e.workers)
+	for len(lengths) < e.workers && !e.done {
+		n, final, err := e.readSegment(e.plain[len(lengths)])
+		if err != nil {
+			return err
+		}
+		lengths = append(lengths, n)
+		e.done = final
+	}
+
+	sealed := make([][]byte, len(lengths))
+	_, _ = parallelize(len(lengths), e.workers, func(i int) error {
+		final := e.done && i == len(lengths)-1
+		sealed[i] = e.cipher.seal(e.sealed[i][:0], e.plain[i][:lengths[i]], e.counter+uint64(i), final)
+		return nil
+	})
+	e.pending = sealed
+	e.counter += uint64(len(lengths))
+	return nil
+}
+
+// readSegment reads the plaintext of the next segment from the underlying reader into the given
+// buffer. A segment is considered the final segment if the underlying reader has no more data
+// after it.
+func (e *streamEncrypter) readSegment(buffer []byte) (int, bool, error) {
+	n, err := io.ReadFull(e.reader, buffer)
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return n, true, nil
+	case err != nil:
+		return 0, false, fmt.Errorf("failed to read plaintext: %w", err)
+	}
+	if _, err = e.reader.Peek(1); err != nil {
+		if !errors.Is(err, io.EOF) {
+			return 0, false, fmt.Errorf("failed to read plaintext: %w", err)
+		}
+		return n, true, nil
+	}
+	return n, false, nil
+}
+
+// streamDecrypter is an io.ReadCloser that reads sealed segments from an underlying io.Reader
+// and returns the authenticated plaintext. Plaintext is only released once the segment it
+// belongs to has been successfully authenticated. Segments are read in batches of up to one
+// segment per worker, which are authenticated and decrypted in parallel.
+type streamDecrypter struct {
+	reader   io.Reader
+	cipher   segmentCipher
+	workers  int
+	sealed   [][]byte
+	plain    [][]byte
+	carry    byte
+	hasCarry bool
+	pending  [][]byte
+	out      []byte
+	counter  uint64
+	done     bool
+	err      error
+}
+
+// newStreamDecrypter returns a new streamDecrypter that reads segments of segmentSize bytes of
+// plaintext, sealed with the given segmentCipher, from r, with up to the given number of workers
+// opening segments in parallel.
+func newStreamDecrypter(r io.Reader, segCipher segmentCipher, segmentSize, workers int) *streamDecrypter {
+	workers = max(workers, 1)
+	decrypter := &streamDecrypter{
+		reader:  r,
+		cipher:  segCipher,
+		workers: workers,
+		sealed:  make([][]byte, workers),
+		plain:   make([][]byte, workers),
+	}
+	for i := range workers {
+		decrypter.sealed[i] = make([]byte, segmentSize+segCipher.overhead()+1)
+		decrypter.plain[i] = make([]byte, 0, segmentSize)
+	}
+	return decrypter
+}
+
+// Read satisfies the io.Reader interface for the streamDecrypter type.
+func (d *streamDecrypter) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if len(d.pending) > 0 {
+			d.out, d.pending = d.pending[0], d.pending[1:]
+			continue
+		}
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.done {
+			return 0, io.EOF
+		}
+		d.err = d.openSegments()
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// Close satisfies the io.Closer interface for the streamDecrypter type. The streamDecrypter
+// holds no resources that need to be released, so Close is a no-op.
+func (d *streamDecrypter) Close() error {
+	return nil
+}
+
+// openSegments reads the next batch of sealed segments from the underlying reader and
+// authenticates and decrypts them in parallel. The plaintext of the segments preceding the
+// first segment that fails is still released, since those have been authenticated.
+func (d *streamDecrypter) openSegments() error {
+	var readErr error
+	lengths := make([]int, 0, d.workers)
+	for len(lengths) < d.workers && !d.done {
+		n, final, err := d.readSegment(d.sealed[len(lengths)])
+		if err != nil {
+			readErr = err
+			break
+		}
+		lengths = append(lengths, n)
+		d.done = final
+	}
+
+	plain := make([][]byte, len(lengths))
+	opened, err := parallelize(len(lengths), d.workers, func(i int) error {
+		final := d.done && i == len(lengths)-1
+		var openErr error
+		plain[i], openErr = d.cipher.open(d.plain[i][:0], d.sealed[i][:lengths[i]], d.counter+uint64(i), final)
+		return openErr
+	})
+	d.pending = plain[:opened]
+	d.counter += uint64(opened)
+	if err != nil {
+		return err
+	}
+	return readErr
+}
+
+// readSegment reads the next sealed segment from the underlying reader into the given buffer.
+// To be able to tell whether a segment is the final segment of the stream, one byte more than
+// the size of a sealed segment is read and carried over to the next segment.
+func (d *streamDecrypter) readSegment(buffer []byte) (int, bool, error) {
+	offset := 0
+	if d.hasCarry {
+		buffer[0] = d.carry
+		d.hasCarry = false
+		offset = 1
+	}
+	n, err := io.ReadFull(d.reader, buffer[offset:])
+	n += offset
+	final := false
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		final = true
+	case err != nil:
+		return 0, false, fmt.Errorf("failed to read bytes from reader: %w", err)
+	default:
+		n--
+		d.carry = buffer[n]
+		d.hasCarry = true
+	}
+	if n < d.cipher.overhead() {
+		return 0, false, ErrMissingData
+	}
+	return n, final, nil
+}
+
+// parallelize calls fn for each index from 0 to count-1, using up to the given number of
+// goroutines. It returns the lowest index for which fn failed along with its error, or count
+// and nil if fn succeeded for all indices.
+func parallelize(count, workers int, fn func(int) error) (int, error) {
+	if workers <= 1 || count <= 1 {
+		for i := range count {
+			if err := fn(i); err != nil {
+				return i, err
+			}
+		}
+		return count, nil
+	}
+
+	errs := make([]error, count)
+	semaphore := make(chan struct{}, workers)
+	wg := sync.WaitGroup{}
+	for i := range count {
+		semaphore <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-semaphore }()
+			errs[i] = fn(i)
+		})
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return i, err
+		}
+	}
+	return count, nil
+}
+
+// segmentNonce returns the big-endian encoded segment counter followed by a single byte
+// indicating whether the segment is the final segment of the stream.
+func segmentNonce(counter uint64, final bool) []byte {
+	nonce := make([]byte, 9)
+	binary.BigEndian.PutUint64(nonce, counter)
+	if final {
+		nonce[8] = 1
+	}
+	return nonce
+}
+
+// addToCounter interprets the given CTR counter block as a big-endian integer and returns a
+// copy of it with n added to it.
+func addToCounter(iv []byte, n uint64) []byte {
+	counter := make([]byte, len(iv))
+	copy(counter, iv)
+	for i := len(counter) - 1; i >= 0 && n > 0; i-- {
+		sum := uint64(counter[i]) + n&0xff
+		counter[i] = byte(sum)
+		n = n>>8 + sum>>8
+	}
+	return counter
+}
+
+// sliceForAppend extends the given slice by n bytes and returns the extended slice and a
+// slice of the n appended bytes.
+func sliceForAppend(in []byte, n int) ([]byte, []byte) {
+	total := len(in) + n
+	var head []byte
+	if cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	return head, head[len(in):]
+}
@@ -0,0 +1,194 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"testing"
+)
+
+func TestAddToCounter(t *testing.T) {
+	tests := []struct {
+		name string
+		iv   []byte
+		n    uint64
+		want []byte
+	}{
+		{"add zero", []byte{0, 0, 0, 1}, 0, []byte{0, 0, 0, 1}},
+		{"add without carry", []byte{0, 0, 0, 1}, 2, []byte{0, 0, 0, 3}},
+		{"add with carry", []byte{0, 0, 0, 0xff}, 1, []byte{0, 0, 1, 0}},
+		{"add with multi-byte carry", []byte{0, 0xff, 0xff, 0xff}, 0x101, []byte{1, 0, 1, 0}},
+		{"add with overflow", []byte{0xff, 0xff}, 1, []byte{0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iv := bytes.Clone(tt.iv)
+			got := addToCounter(iv, tt.n)
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("expected counter to be %x, got %x", tt.want, got)
+			}
+			if !bytes.Equal(iv, tt.iv) {
+				t.Errorf("expected IV to be unmodified, got %x", iv)
+			}
+		})
+	}
+}
+
+func TestStreamEncrypter(t *testing.T) {
+	t.Run("encrypter fails on broken reader after full segment", func(t *testing.T) {
+		reader := &failReadWriter{failOnRead: 1}
+		reader.readFunc = func(p []byte) (int, error) {
+			if len(p) > defaultSegmentSize {
+				p = p[:defaultSegmentSize]
+			}
+			return len(p), nil
+		}
+		segCipher, err := newCTRHMACCipher(make([]byte, aesKeySize), make([]byte, hmacKeySize),
+			make([]byte, blockSize), nil, defaultSegmentSize)
+		if err != nil {
+			t.Fatalf("failed to create segment cipher: %s", err)
+		}
+		encrypter := newStreamEncrypter(reader, segCipher, nil, defaultSegmentSize, 1)
+		buffer := bytes.NewBuffer(nil)
+		if _, err = buffer.ReadFrom(encrypter); err == nil {
+			t.Error("expected encrypter to fail with broken reader")
+		}
+	})
+}
+
+func TestStreamConcurrency(t *testing.T) {
+	const segmentSize = 64
+	plaintext := make([]byte, 20*segmentSize+7)
+	if _, err := io.ReadFull(rand.Reader, plaintext); err != nil {
+		t.Fatalf("failed to generate plaintext: %s", err)
+	}
+	encrypt := func(t *testing.T, workers int) []byte {
+		t.Helper()
+		random := bytes.NewReader(bytes.Repeat([]byte{0x42}, 1024))
+		encrypter, err := NewEncrypterWithKey(bytes.NewReader(plaintext), testKey, WithRandReader(random),
+			WithChunkSize(segmentSize), WithConcurrency(workers))
+		if err != nil {
+			t.Fatalf("failed to create encrypter: %s", err)
+		}
+		ciphertext, err := io.ReadAll(encrypter)
+		if err != nil {
+			t.Fatalf("failed to encrypt plaintext: %s", err)
+		}
+		return ciphertext
+	}
+	sequential := encrypt(t, 1)
+
+	for _, workers := range []int{2, 3, 8, 32} {
+		t.Run(fmt.Sprintf("encrypter with %d workers matches sequential encryption", workers), func(t *testing.T) {
+			if !bytes.Equal(sequential, encrypt(t, workers)) {
+				t.Error("expected ciphertext to match the sequentially encrypted ciphertext")
+			}
+		})
+		t.Run(fmt.Sprintf("decrypter with %d workers", workers), func(t *testing.T) {
+			decrypter, err := NewDecrypterWithKey(bytes.NewReader(sequential), testKey, WithConcurrency(workers))
+			if err != nil {
+				t.Fatalf("failed to create decrypter: %s", err)
+			}
+			decrypted, err := io.ReadAll(decrypter)
+			if err != nil {
+				t.Fatalf("failed to decrypt ciphertext: %s", err)
+			}
+			if !bytes.Equal(plaintext, decrypted) {
+				t.Error("plaintext and decrypted data do not match")
+			}
+		})
+	}
+	t.Run("encrypt and decrypt writers with multiple workers", func(t *testing.T) {
+		for _, size := range []int{0, segmentSize, 4 * segmentSize, len(plaintext)} {
+			ciphertext := bytes.NewBuffer(nil)
+			encrypter, err := NewEncryptWriter(ciphertext, testPassword, WithChunkSize(segmentSize),
+				WithConcurrency(4), WithArgon2(8*1024, 1, 1))
+			if err != nil {
+				t.Fatalf("failed to create encrypter: %s", err)
+			}
+			for chunk := range slices.Chunk(plaintext[:size], 100) {
+				if _, err = encrypter.Write(chunk); err != nil {
+					t.Fatalf("failed to write plaintext: %s", err)
+				}
+			}
+			if err = encrypter.Close(); err != nil {
+				t.Fatalf("failed to close encrypter: %s", err)
+			}
+
+			decrypted := bytes.NewBuffer(nil)
+			decrypter, err := NewDecryptWriter(decrypted, testPassword, WithConcurrency(3))
+			if err != nil {
+				t.Fatalf("failed to create decrypter: %s", err)
+			}
+			if _, err = decrypter.Write(ciphertext.Bytes()); err != nil {
+				t.Fatalf("failed to write ciphertext: %s", err)
+			}
+			if err = decrypter.Close(); err != nil {
+				t.Fatalf("failed to close decrypter: %s", err)
+			}
+			if !bytes.Equal(plaintext[:size], decrypted.Bytes()) {
+				t.Errorf("plaintext and decrypted data of %d bytes do not match", size)
+			}
+		}
+	})
+	t.Run("authenticated segments before a tampered segment are released", func(t *testing.T) {
+		tampered := bytes.Clone(sequential)
+		headerLength := len(sequential) - len(plaintext) - 21*hmacSize
+		tampered[headerLength+5*(segmentSize+hmacSize)] ^= 0xff
+		decrypter, err := NewDecrypterWithKey(bytes.NewReader(tampered), testKey, WithConcurrency(8))
+		if err != nil {
+			t.Fatalf("failed to create decrypter: %s", err)
+		}
+		decrypted, err := io.ReadAll(decrypter)
+		if !errors.Is(err, ErrFailedAuthentication) {
+			t.Errorf("expected error to be %s, got %s", ErrFailedAuthentication, err)
+		}
+		if !bytes.Equal(plaintext[:5*segmentSize], decrypted) {
+			t.Errorf("expected %d bytes of authenticated plaintext, got %d", 5*segmentSize, len(decrypted))
+		}
+	})
+	t.Run("truncated ciphertext with multiple workers should fail", func(t *testing.T) {
+		truncated := sequential[:len(sequential)-segmentSize-7-2*hmacSize]
+		decrypter, err := NewDecrypterWithKey(bytes.NewReader(truncated), testKey, WithConcurrency(4))
+		if err != nil {
+			t.Fatalf("failed to create decrypter: %s", err)
+		}
+		if _, err = io.ReadAll(decrypter); !errors.Is(err, ErrFailedAuthentication) {
+			t.Errorf("expected error to be %s, got %s", ErrFailedAuthentication, err)
+		}
+	})
+}
+
+func TestParallelize(t *testing.T) {
+	t.Run("the lowest failing index is returned", func(t *testing.T) {
+		index, err := parallelize(10, 4, func(i int) error {
+			if i == 3 || i == 7 {
+				return fmt.Errorf("failed at %d", i)
+			}
+			return nil
+		})
+		if index != 3 || err == nil || err.Error() != "failed at 3" {
+			t.Errorf("expected failure at index 3, got %d: %v", index, err)
+		}
+	})
+	t.Run("all indices are processed", func(t *testing.T) {
+		processed := make([]bool, 10)
+		index, err := parallelize(len(processed), 3, func(i int) error {
+			processed[i] = true
+			return nil
+		})
+		if index != len(processed) || err != nil {
+			t.Errorf("expected all indices to succeed, got %d: %v", index, err)
+		}
+		if slices.Contains(processed, false) {
+			t.Error("expected all indices to be processed")
+		}
+	})
+}
@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// tempFilePattern is the pattern for the names of the temporary files created by the decrypter.
+const tempFilePattern = "iocrypter-*"
+
+// tempFile is a temporary file that is removed from the filesystem once it is closed. Unnamed
+// temporary files have no name in the filesystem and are released by the operating system when they
+// are closed or the process exits.
+type tempFile struct {
+	*os.File
+	named  bool
+	closed bool
+}
+
+// createTempFile creates a new temporary file in the given directory, or in the directory returned by
+// os.TempDir if dir is empty. If unnamed is set, an unnamed temporary file is created where the
+// platform and the filesystem support it, otherwise it falls back to a named temporary file.
+func createTempFile(dir string, unnamed bool) (*tempFile, error) {
+	if unnamed {
+		file, err := openUnnamedTempFile(dir)
+		if err == nil {
+			return &tempFile{File: file}, nil
+		}
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return nil, fmt.Errorf("failed to create unnamed temporary file: %w", err)
+		}
+	}
+	file, err := os.CreateTemp(dir, tempFilePattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	return &tempFile{File: file, named: true}, nil
+}
+
+// Close satisfies the io.Closer interface for the tempFile type. It closes the file and removes it
+// from the filesystem. Subsequent calls to Close are no-ops.
+func (t *tempFile) Close() error {
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	var errs []error
+	if err := t.File.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close temporary file: %w", err))
+	}
+	if t.named {
+		if err := os.Remove(t.Name()); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove temporary file: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
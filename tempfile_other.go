@@ -0,0 +1,18 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+//go:build !linux
+
+package iocrypter
+
+import (
+	"errors"
+	"os"
+)
+
+// openUnnamedTempFile returns errors.ErrUnsupported, since unnamed temporary files are only
+// supported on Linux.
+func openUnnamedTempFile(string) (*os.File, error) {
+	return nil, errors.ErrUnsupported
+}
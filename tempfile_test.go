@@ -0,0 +1,183 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestCreateTempFile(t *testing.T) {
+	t.Run("named temporary file is removed on close", func(t *testing.T) {
+		dir := t.TempDir()
+		file, err := createTempFile(dir, false)
+		if err != nil {
+			t.Fatalf("failed to create temporary file: %s", err)
+		}
+		if _, err = os.Stat(file.Name()); err != nil {
+			t.Fatalf("expected temporary file to exist: %s", err)
+		}
+		if filepath.Dir(file.Name()) != dir {
+			t.Errorf("expected temporary file to be created in %s, got %s", dir, file.Name())
+		}
+		if err = file.Close(); err != nil {
+			t.Fatalf("failed to close temporary file: %s", err)
+		}
+		if _, err = os.Stat(file.Name()); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected error to be %s, got %s", os.ErrNotExist, err)
+		}
+		if err = file.Close(); err != nil {
+			t.Errorf("expected subsequent close to succeed, got %s", err)
+		}
+	})
+	t.Run("unnamed temporary file leaves no trace in the directory", func(t *testing.T) {
+		if runtime.GOOS != "linux" {
+			t.Skip("unnamed temporary files are only supported on Linux")
+		}
+		dir := t.TempDir()
+		file, err := createTempFile(dir, true)
+		if err != nil {
+			t.Fatalf("failed to create temporary file: %s", err)
+		}
+		defer func() {
+			_ = file.Close()
+		}()
+		if file.named {
+			t.Skip("filesystem does not support O_TMPFILE")
+		}
+		if _, err = file.Write([]byte("ciphertext")); err != nil {
+			t.Fatalf("failed to write to temporary file: %s", err)
+		}
+		assertEmptyDir(t, dir)
+	})
+	t.Run("creating temporary file in non-existing directory should fail", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "non-existing")
+		for _, unnamed := range []bool{false, true} {
+			if _, err := createTempFile(dir, unnamed); err == nil {
+				t.Errorf("expected temporary file creation to fail in non-existing directory (unnamed: %t)",
+					unnamed)
+			}
+		}
+	})
+}
+
+func TestNewDecrypter_TempFiles(t *testing.T) {
+	plaintext := make([]byte, defaultSegmentSize+1)
+	legacy, err := newLegacyEncrypter(bytes.NewReader(plaintext), testPassword)
+	if err != nil {
+		t.Fatalf("failed to create legacy encrypter: %s", err)
+	}
+	ciphertext, err := io.ReadAll(legacy)
+	if err != nil {
+		t.Fatalf("failed to encrypt plaintext: %s", err)
+	}
+	tampered := bytes.Clone(ciphertext)
+	tampered[len(tampered)-1] ^= 0xff
+
+	// Derive the keys only once, so that the decrypters can be created repeatedly
+	aesKey, hmacKey, err := passwordKeys(testPassword)(readTestHeader(t, ciphertext))
+	if err != nil {
+		t.Fatalf("failed to derive keys: %s", err)
+	}
+	keys := keyFunc(func(*header) ([]byte, []byte, error) {
+		return aesKey, hmacKey, nil
+	})
+
+	for _, unnamed := range []bool{false, true} {
+		decrypt := func(t *testing.T, data []byte, dir string) (io.ReadCloser, error) {
+			t.Helper()
+			o, err := newOptions(WithTempDir(dir))
+			if err != nil {
+				t.Fatalf("failed to create options: %s", err)
+			}
+			o.unnamedTemp = unnamed
+			return newDecrypter(bytes.NewReader(data), keys, o)
+		}
+		t.Run(fmt.Sprintf("closing the decrypter removes the temporary file (unnamed: %t)", unnamed),
+			func(t *testing.T) {
+				dir := t.TempDir()
+				decrypter, err := decrypt(t, ciphertext, dir)
+				if err != nil {
+					t.Fatalf("failed to create decrypter: %s", err)
+				}
+				decrypted, err := io.ReadAll(decrypter)
+				if err != nil {
+					t.Fatalf("failed to decrypt ciphertext: %s", err)
+				}
+				if !bytes.Equal(plaintext, decrypted) {
+					t.Error("plaintext and decrypted data do not match")
+				}
+				if err = decrypter.Close(); err != nil {
+					t.Fatalf("failed to close decrypter: %s", err)
+				}
+				assertEmptyDir(t, dir)
+				if _, err = decrypter.Read(make([]byte, 1)); err == nil {
+					t.Error("expected read after close to fail")
+				}
+			})
+		t.Run(fmt.Sprintf("failed authentication removes the temporary file (unnamed: %t)", unnamed),
+			func(t *testing.T) {
+				dir := t.TempDir()
+				if _, err := decrypt(t, tampered, dir); !errors.Is(err, ErrFailedAuthentication) {
+					t.Errorf("expected error to be %s, got %s", ErrFailedAuthentication, err)
+				}
+				assertEmptyDir(t, dir)
+			})
+		t.Run(fmt.Sprintf("repeated decryption does not leak file descriptors (unnamed: %t)", unnamed),
+			func(t *testing.T) {
+				before, ok := openFileDescriptors()
+				if !ok {
+					t.Skip("counting open file descriptors is not supported on this platform")
+				}
+				dir := t.TempDir()
+				for range 1000 {
+					decrypter, err := decrypt(t, ciphertext, dir)
+					if err != nil {
+						t.Fatalf("failed to create decrypter: %s", err)
+					}
+					if err = decrypter.Close(); err != nil {
+						t.Fatalf("failed to close decrypter: %s", err)
+					}
+					if _, err = decrypt(t, tampered, dir); err == nil {
+						t.Fatal("expected decryption of tampered ciphertext to fail")
+					}
+				}
+				// A leak leaves at least one descriptor open per iteration, so a few descriptors that
+				// the runtime opens in the meantime are tolerated
+				after, _ := openFileDescriptors()
+				if after > before+10 {
+					t.Errorf("expected no leaked file descriptors, got %d open before and %d after", before, after)
+				}
+			})
+	}
+}
+
+// assertEmptyDir fails the test if the given directory is not empty.
+func assertEmptyDir(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read directory: %s", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected directory to be empty, got %d entries", len(entries))
+	}
+}
+
+// openFileDescriptors returns the number of file descriptors opened by the process. It returns false
+// if the number cannot be determined on the platform.
+func openFileDescriptors() (int, bool) {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, false
+	}
+	return len(entries), true
+}
@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// Verify reads ciphertext from r and authenticates it with a key derived from the given password,
+// without releasing any plaintext. It returns nil if the whole ciphertext is authentic, otherwise
+// the error that a decrypter would have returned, e.g. ErrFailedAuthentication or ErrMissingData.
+//
+// Unlike NewDecrypter, Verify never spools the ciphertext: ciphertext in the segmented stream
+// format is authenticated segment by segment and ciphertext in the legacy format is streamed
+// through its HMAC, so Verify uses constant memory and no disk space regardless of the size of
+// the ciphertext. The options WithPolicy and WithConcurrency apply to Verify as well.
+func Verify(r io.Reader, password []byte, opts ...Option) error {
+	if len(password) == 0 {
+		return ErrPassPhraseEmpty
+	}
+	o, err := newOptions(opts...)
+	if err != nil {
+		return err
+	}
+	return verify(r, passwordKeys(password), o)
+}
+
+// verify reads the header from r, derives the keys using the given keyFunc and authenticates the
+// ciphertext that follows the header, discarding the plaintext.
+func verify(r io.Reader, keys keyFunc, o *options) error {
+	buffer := bufio.NewReaderSize(r, chunkSize)
+	hdr, aesKey, hmacKey, err := readParameters(buffer, keys, o.policy)
+	if err != nil {
+		return fmt.Errorf("failed to read encryption parameters: %w", err)
+	}
+	if hdr.version == formatVersionLegacy {
+		return authenticateLegacy(buffer, hdr, hmacKey, io.Discard)
+	}
+
+	segCipher, err := newSegmentCipher(hdr.suite, aesKey, hmacKey, hdr.iv, hdr.ad, int(hdr.segmentSize))
+	if err != nil {
+		return err
+	}
+	decrypter := newStreamDecrypter(buffer, segCipher, int(hdr.segmentSize), o.concurrency)
+	_, err = io.Copy(io.Discard, decrypter)
+	return err
+}
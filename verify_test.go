@@ -0,0 +1,145 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package iocrypter
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	plaintext := make([]byte, 10*1000+123)
+	if _, err := io.ReadFull(rand.Reader, plaintext); err != nil {
+		t.Fatalf("failed to generate plaintext: %s", err)
+	}
+	ciphertext := encryptWithChunkSize(t, plaintext, 1000)
+
+	t.Run("verifying authentic ciphertext", func(t *testing.T) {
+		if err := Verify(bytes.NewReader(ciphertext), testPassword); err != nil {
+			t.Errorf("failed to verify ciphertext: %s", err)
+		}
+	})
+	t.Run("verifying with empty password should fail", func(t *testing.T) {
+		if err := Verify(bytes.NewReader(ciphertext), nil); !errors.Is(err, ErrPassPhraseEmpty) {
+			t.Errorf("expected error to be %s, got %s", ErrPassPhraseEmpty, err)
+		}
+	})
+	t.Run("verifying with invalid password should fail", func(t *testing.T) {
+		err := Verify(bytes.NewReader(ciphertext), []byte("invalid passphrase"))
+		if !errors.Is(err, ErrFailedAuthentication) {
+			t.Errorf("expected error to be %s, got %s", ErrFailedAuthentication, err)
+		}
+	})
+	t.Run("verifying with invalid option should fail", func(t *testing.T) {
+		if err := Verify(bytes.NewReader(ciphertext), testPassword, WithConcurrency(-1)); err == nil {
+			t.Error("expected verification with invalid option to fail")
+		}
+	})
+
+	// Derive the keys once, so that the subtests do not need to run Argon2 for every ciphertext
+	aesKey, hmacKey, err := passwordKeys(testPassword)(readTestHeader(t, ciphertext))
+	if err != nil {
+		t.Fatalf("failed to derive keys: %s", err)
+	}
+	keys := keyFunc(func(*header) ([]byte, []byte, error) {
+		return aesKey, hmacKey, nil
+	})
+	t.Run("verifying with concurrency", func(t *testing.T) {
+		if err := verifyWithKeys(t, keys, ciphertext, WithConcurrency(4)); err != nil {
+			t.Errorf("failed to verify ciphertext: %s", err)
+		}
+	})
+	t.Run("verifying modified last segment should fail", func(t *testing.T) {
+		for _, workers := range []int{1, 4} {
+			modified := bytes.Clone(ciphertext)
+			modified[len(modified)-hmacSize-1] ^= 0x01
+			err := verifyWithKeys(t, keys, modified, WithConcurrency(workers))
+			if !errors.Is(err, ErrFailedAuthentication) {
+				t.Errorf("expected error to be %s, got %s", ErrFailedAuthentication, err)
+			}
+		}
+	})
+	t.Run("verifying truncated ciphertext should fail", func(t *testing.T) {
+		// Drop the final segment, so that the ciphertext ends at a segment boundary
+		finalSegment := len(plaintext)%1000 + hmacSize
+		err := verifyWithKeys(t, keys, ciphertext[:len(ciphertext)-finalSegment])
+		if !errors.Is(err, ErrFailedAuthentication) {
+			t.Errorf("expected error to be %s, got %s", ErrFailedAuthentication, err)
+		}
+	})
+	t.Run("verifying ciphertext with appended data should fail", func(t *testing.T) {
+		err := verifyWithKeys(t, keys, append(bytes.Clone(ciphertext), 0x00))
+		if !errors.Is(err, ErrFailedAuthentication) {
+			t.Errorf("expected error to be %s, got %s", ErrFailedAuthentication, err)
+		}
+	})
+	t.Run("verifying from failing reader should fail", func(t *testing.T) {
+		header := readTestHeader(t, ciphertext).raw
+		o, err := newOptions()
+		if err != nil {
+			t.Fatalf("failed to create options: %s", err)
+		}
+		if err = verify(io.MultiReader(bytes.NewReader(header), &failReadWriter{}), keys, o); err == nil {
+			t.Error("expected verification from failing reader to fail")
+		}
+	})
+}
+
+func TestVerify_Legacy(t *testing.T) {
+	plaintext := make([]byte, 3*chunkSize+42)
+	if _, err := io.ReadFull(rand.Reader, plaintext); err != nil {
+		t.Fatalf("failed to generate plaintext: %s", err)
+	}
+	legacy, err := newLegacyEncrypter(bytes.NewReader(plaintext), testPassword)
+	if err != nil {
+		t.Fatalf("failed to create legacy encrypter: %s", err)
+	}
+	ciphertext, err := io.ReadAll(legacy)
+	if err != nil {
+		t.Fatalf("failed to encrypt plaintext: %s", err)
+	}
+	aesKey, hmacKey, err := passwordKeys(testPassword)(readTestHeader(t, ciphertext))
+	if err != nil {
+		t.Fatalf("failed to derive keys: %s", err)
+	}
+	keys := keyFunc(func(*header) ([]byte, []byte, error) {
+		return aesKey, hmacKey, nil
+	})
+
+	t.Run("verifying authentic legacy ciphertext does not spool", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := verifyWithKeys(t, keys, ciphertext, WithTempDir(dir)); err != nil {
+			t.Errorf("failed to verify ciphertext: %s", err)
+		}
+		assertEmptyDir(t, dir)
+	})
+	t.Run("verifying modified legacy ciphertext should fail", func(t *testing.T) {
+		modified := bytes.Clone(ciphertext)
+		modified[len(modified)-hmacSize-1] ^= 0x01
+		err := verifyWithKeys(t, keys, modified)
+		if !errors.Is(err, ErrFailedAuthentication) {
+			t.Errorf("expected error to be %s, got %s", ErrFailedAuthentication, err)
+		}
+	})
+	t.Run("verifying truncated legacy ciphertext should fail", func(t *testing.T) {
+		err := verifyWithKeys(t, keys, ciphertext[:len(ciphertext)-1])
+		if !errors.Is(err, ErrFailedAuthentication) {
+			t.Errorf("expected error to be %s, got %s", ErrFailedAuthentication, err)
+		}
+	})
+}
+
+// verifyWithKeys verifies the given ciphertext with the keys returned by the given keyFunc.
+func verifyWithKeys(t *testing.T, keys keyFunc, ciphertext []byte, opts ...Option) error {
+	t.Helper()
+	o, err := newOptions(opts...)
+	if err != nil {
+		t.Fatalf("failed to create options: %s", err)
+	}
+	return verify(bytes.NewReader(ciphertext), keys, o)
+}